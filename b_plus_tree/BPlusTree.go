@@ -2,97 +2,397 @@ package b_plus_tree
 
 import (
 	"dmds_lab2/shared"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
+// BPlusTree is an in-memory B+ tree, safe for concurrent use by latch crabbing: every node has a reader/writer latch, taken
+// from the root down, and the latch of a node is released as soon as the operation cannot modify it anymore.
+//   - Get, Rank and Select read-latch a child before releasing its parent.
+//   - Insert, Update and Delete first read-latch the interior nodes of the path and write-latch the leaf only. The numbers of
+//     keys of the subtrees are then updated atomically, the read latches preventing any split or merge of the path meanwhile.
+//   - When the leaf has to be split or merged, Insert and Delete restart and write-latch the path, releasing the ancestors of
+//     every safe node (one that cannot be split or merged by the operation): only the unsafe part of the path stays latched.
+//
+// The latches are always taken from the root down, or from left to right between siblings under a write-latched parent,
+// so that they cannot deadlock. Cursors are not latched, they must not be used while the tree is modified.
 type BPlusTree struct {
-	root  *InteriorNode
-	count uint64
+	root             Node
+	rootLatch        sync.RWMutex // Protects root, write-latched while the root may be replaced
+	count            atomic.Uint64
+	leafCapacity     uint64
+	interiorCapacity uint64
 }
 
+// minCapacity is the smallest number of keys per node: with fewer, splitting an interior node would leave one of the halves empty.
+const minCapacity = 2
+
+// CacheLineSize is the size of a CPU cache line, the smallest sensible budget for NodeCapacitiesForSize, PageSize being another.
+const CacheLineSize = 64
+
+// NewBPlusTree creates an empty tree holding up to capacity keys per node, at least minCapacity.
 func NewBPlusTree(capacity uint64) *BPlusTree {
-	root := NewInteriorNode(capacity)
-	root.isRoot = true
-	leaf1 := NewLeafNode(capacity)
-	leaf2 := NewLeafNode(capacity)
-	leaf1.next = leaf2
-	root.next[0] = leaf1
-	root.next[1] = leaf2
+	return NewBPlusTreeWithFanout(capacity, capacity)
+}
+
+// NewBPlusTreeWithFanout creates an empty tree holding up to leafCapacity keys per leaf and interiorCapacity keys per interior
+// node, that is interiorCapacity+1 children, both at least minCapacity.
+func NewBPlusTreeWithFanout(leafCapacity uint64, interiorCapacity uint64) *BPlusTree {
+	leafCapacity = max(leafCapacity, minCapacity)
 	return &BPlusTree{
-		root: root,
+		root:             NewLeafNode(leafCapacity),
+		leafCapacity:     leafCapacity,
+		interiorCapacity: max(interiorCapacity, minCapacity),
 	}
 }
 
-func (t *BPlusTree) Get(key shared.KeyType) (*LeafNode, uint64, []*InteriorNode, error) {
-	var current Node = t.root
-	var currentLeaf *LeafNode
-	path := make([]*InteriorNode, 0)
+// NodeCapacitiesForSize returns the capacities of the leaves and of the interior nodes whose arrays of keys, values and
+// children fit in nodeSize bytes, such as CacheLineSize or PageSize, both at least minCapacity.
+// A leaf holds a key and a value pointer per key, an interior node a key, a child and the count of its subtree per key, and
+// both keep one more slot for the key that overflows before a split.
+func NodeCapacitiesForSize(nodeSize uint64) (leafCapacity uint64, interiorCapacity uint64) {
+	keySize := uint64(unsafe.Sizeof(shared.KeyType(0)))
+	leafSlotSize := keySize + uint64(unsafe.Sizeof((*shared.ValueType)(nil)))
+	childSize := uint64(unsafe.Sizeof(Node(nil))) + uint64(unsafe.Sizeof(uint64(0)))
 
-	for currentLeaf == nil {
-		idx, err := current.Scan(key)
-		if err != nil {
-			return nil, idx, path, err
+	// (capacity + 1) leaf slots, (capacity + 1) interior keys and (capacity + 2) children
+	if nodeSize >= 2*leafSlotSize {
+		leafCapacity = nodeSize/leafSlotSize - 1
+	}
+	if nodeSize >= keySize+2*childSize {
+		interiorCapacity = (nodeSize - keySize - 2*childSize) / (keySize + childSize)
+	}
+	return max(leafCapacity, minCapacity), max(interiorCapacity, minCapacity)
+}
+
+// Count returns the number of keys in the tree.
+func (t *BPlusTree) Count() uint64 {
+	return t.count.Load()
+}
+
+// latchRoot latches the root for reading, or for writing if it is a leaf and write is set, and returns it.
+func (t *BPlusTree) latchRoot(write bool) Node {
+	t.rootLatch.RLock()
+	defer t.rootLatch.RUnlock()
+
+	root := t.root
+	latchNode(root, write)
+	return root
+}
+
+// latchNode latches the node, for writing if it is a leaf and write is set, else for reading.
+func latchNode(node Node, write bool) {
+	if _, isLeafNode := node.(*LeafNode); isLeafNode && write {
+		node.getLatch().Lock()
+	} else {
+		node.getLatch().RLock()
+	}
+}
+
+// readLeaf returns the leaf the key belongs to, read-latched. The latch of every interior node on the path is released once
+// the child is latched.
+func (t *BPlusTree) readLeaf(key shared.KeyType) *LeafNode {
+	current := t.latchRoot(false)
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
+			return current.(*LeafNode)
 		}
+		idx, _ := node.Scan(key)
+		current = node.next[idx]
+		current.getLatch().RLock()
+		node.latch.RUnlock()
+	}
+}
 
-		currentInterior, isInteriorNode := current.(*InteriorNode)
-		path = append(path, currentInterior)
-		if isInteriorNode {
-			nextNode := currentInterior.GetNodeAtIndex(idx)
-			if nextNode == nil {
-				return nil, idx, path, shared.KeyNotFoundError
-			}
-			current = nextNode
+// latchLeaf returns the leaf the key belongs to, write-latched, and the interior nodes on the path to it, from the root,
+// read-latched until unlatchLeaf: none of them can be split or merged, so the counts of their subtrees can be updated atomically.
+func (t *BPlusTree) latchLeaf(key shared.KeyType) (*LeafNode, []*InteriorNode) {
+	current := t.latchRoot(true)
+	path := make([]*InteriorNode, 0)
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
+			return current.(*LeafNode), path
 		}
+		path = append(path, node)
+		idx, _ := node.Scan(key)
+		current = node.next[idx]
+		latchNode(current, true)
+	}
+}
+
+func unlatchLeaf(leaf *LeafNode, path []*InteriorNode) {
+	leaf.latch.Unlock()
+	for _, node := range path {
+		node.latch.RUnlock()
+	}
+}
+
+// Get returns the value of the key, or KeyNotFoundError if the tree does not hold it.
+func (t *BPlusTree) Get(key shared.KeyType) (*shared.ValueType, error) {
+	leaf := t.readLeaf(key)
+	defer leaf.latch.RUnlock()
+
+	idx, err := leaf.Scan(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value := *leaf.GetValueAtIndex(idx)
+	return &value, nil
+}
 
-		currentLeafCheck, isLeafNode := current.(*LeafNode)
-		if isLeafNode {
-			currentLeaf = currentLeafCheck
+// Insert inserts the key-value pair, or replaces the value if the key is already in the tree.
+func (t *BPlusTree) Insert(key shared.KeyType, value shared.ValueType) error {
+	leaf, path := t.latchLeaf(key)
+	if idx, err := leaf.Scan(key); err == nil {
+		leaf.values[idx] = &value
+		unlatchLeaf(leaf, path)
+		return nil
+	}
+	if !leaf.IsFull() {
+		leaf.Insert(key, value)
+		t.count.Add(1)
+		addToCounts(key, path, 1)
+		unlatchLeaf(leaf, path)
+		return nil
+	}
+	unlatchLeaf(leaf, path)
+
+	// The leaf splits: insert again with the unsafe part of the path write-latched
+	w := t.latchPath(key, 1, func(node Node, _ bool) bool {
+		return !node.IsFull()
+	})
+	newLeaf, inserted, midKey := w.leaf.Insert(key, value)
+	if !inserted {
+		// Inserted concurrently
+		w.unlatch(t)
+		t.repairCounts(key, w)
+		return nil
+	}
+	defer w.unlatch(t)
+	t.count.Add(1)
+	addToCounts(key, w.path, 1)
+	if newLeaf == nil {
+		return nil
+	}
+
+	// Insert the key separating the split nodes in their parent, up to the root while the parents overflow
+	var right Node = newLeaf
+	for i := len(w.path) - 1; i >= 0; i-- {
+		newInterior, newMidKey, err := w.path[i].Insert(midKey, right)
+		if err != nil {
+			return err
+		}
+		if newInterior == nil {
+			return nil
 		}
+		right, midKey = newInterior, newMidKey
+	}
+	if !w.rootLatched {
+		return errors.New("split of a node that was safe")
 	}
 
-	idx, err := currentLeaf.Scan(key)
+	newRoot := NewInteriorNode(t.interiorCapacity)
+	newRoot.keys[0] = midKey
+	newRoot.next[0] = t.root
+	newRoot.next[1] = right
+	newRoot.count = 1
+	newRoot.refreshCount(0)
+	newRoot.refreshCount(1)
+	t.root = newRoot
+	return nil
+}
 
-	return currentLeaf, idx, path, err
+// Update replaces the value of the key, or returns KeyNotFoundError if the tree does not hold it.
+func (t *BPlusTree) Update(key shared.KeyType, value shared.ValueType) error {
+	leaf, path := t.latchLeaf(key)
+	defer unlatchLeaf(leaf, path)
+
+	idx, err := leaf.Scan(key)
+	if err != nil {
+		return err
+	}
+
+	leaf.values[idx] = &value
+	return nil
 }
 
-func (t *BPlusTree) Insert(key shared.KeyType) (*LeafNode, []*InteriorNode, error) {
-	if t.count <= 0 {
-		_, _, _, err := t.root.Insert(key, t.root.next[0], t.root.next[1])
-		t.count++
-		return nil, []*InteriorNode{t.root}, err
+// Delete removes the key, or returns KeyNotFoundError if the tree does not hold it.
+// The nodes left with fewer keys than the minimum are rebalanced bottom-up, and the root is removed once it has a single child.
+func (t *BPlusTree) Delete(key shared.KeyType) error {
+	leaf, path := t.latchLeaf(key)
+	idx, err := leaf.Scan(key)
+	if err != nil {
+		unlatchLeaf(leaf, path)
+		return err
+	}
+	if len(path) == 0 || leaf.count > leaf.minCount() {
+		leaf.RemoveAtIndex(idx)
+		t.count.Add(^uint64(0))
+		addToCounts(key, path, -1)
+		unlatchLeaf(leaf, path)
+		return nil
 	}
+	unlatchLeaf(leaf, path)
 
-	leaf, _, path, _ := t.Get(key)
-	s1, s2, midKey, err := leaf.Insert(key)
+	// The leaf underflows: delete again with the unsafe part of the path write-latched
+	w := t.latchPath(key, -1, func(node Node, isRoot bool) bool {
+		switch node := node.(type) {
+		case *LeafNode:
+			return isRoot || node.count > node.minCount()
+		case *InteriorNode:
+			return isRoot && node.count > 1 || !isRoot && node.count > node.minCount()
+		}
+		return false
+	})
+	idx, err = w.leaf.Scan(key)
 	if err != nil {
-		return nil, nil, err
+		// Deleted concurrently
+		w.unlatch(t)
+		t.repairCounts(key, w)
+		return err
 	}
+	defer w.unlatch(t)
+	w.leaf.RemoveAtIndex(idx)
+	t.count.Add(^uint64(0))
+	addToCounts(key, w.path, -1)
 
-	if s2 == nil {
-		t.count++
-		return leaf, path, nil
+	underflows := w.leaf.count < w.leaf.minCount()
+	for i := len(w.path) - 1; i >= 0 && underflows; i-- {
+		childIdx, _ := w.path[i].Scan(key)
+		rebalance(w.path[i], int(childIdx))
+		underflows = w.path[i].count < w.path[i].minCount()
 	}
 
-	for i := len(path) - 1; i >= 0; i-- {
-		in := path[i]
+	if !w.rootLatched {
+		return nil
+	}
+	if root, isInteriorNode := t.root.(*InteriorNode); isInteriorNode && root.count == 0 {
+		t.root = root.next[0]
+	}
+	return nil
+}
 
-		var s2Next *InteriorNode
-		var s1Next *InteriorNode
-		s1Next, s2Next, midKey, err = in.Insert(midKey, s1, s2)
-		if err != nil {
-			return nil, nil, err
+// writePath is the write-latched part of the path to the leaf of a key, see latchPath.
+type writePath struct {
+	rootLatched bool            // The root may be replaced, so rootLatch is held
+	path        []*InteriorNode // Latched interior nodes, from the highest one
+	leaf        *LeafNode
+	speculative bool // The counts of ancestors released before the leaf was reached have been changed
+}
+
+// latchPath write-latches the path from the root to the leaf of the key. Once a node is safe, that is the operation cannot
+// split or merge it, the latches of its ancestors are released. As they cannot be updated once the leaf is reached, delta is
+// added to the counts of their subtrees the key is routed to first: repairCounts undoes it if the leaf is not changed.
+func (t *BPlusTree) latchPath(key shared.KeyType, delta int, isSafe func(node Node, isRoot bool) bool) *writePath {
+	t.rootLatch.Lock()
+	w := &writePath{rootLatched: true}
+	current := t.root
+	current.getLatch().Lock()
+	if isSafe(current, true) {
+		t.rootLatch.Unlock()
+		w.rootLatched = false
+	}
+
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
+			w.leaf = current.(*LeafNode)
+			return w
 		}
+		w.path = append(w.path, node)
+		idx, _ := node.Scan(key)
+		current = node.next[idx]
+		current.getLatch().Lock()
 
-		if i == 0 {
-			t.root = s1Next
+		if isSafe(current, false) {
+			addToCounts(key, w.path, delta)
+			for _, ancestor := range w.path {
+				ancestor.latch.Unlock()
+			}
+			if w.rootLatched {
+				t.rootLatch.Unlock()
+				w.rootLatched = false
+			}
+			w.path = w.path[:0]
+			w.speculative = true
 		}
+	}
+}
+
+func (w *writePath) unlatch(t *BPlusTree) {
+	w.leaf.latch.Unlock()
+	for _, node := range w.path {
+		node.latch.Unlock()
+	}
+	if w.rootLatched {
+		t.rootLatch.Unlock()
+	}
+}
 
-		if s2Next == nil {
+// repairCounts recomputes the counts of the subtrees along the path to the key, bottom-up, after latchPath changed them for
+// an operation that did not change the leaf. The whole path is write-latched, so no operation is in progress below it; the
+// changes of the operations in progress in other subtrees are kept, as only the counts the key is routed to are recomputed.
+func (t *BPlusTree) repairCounts(key shared.KeyType, w *writePath) {
+	if !w.speculative {
+		return
+	}
+
+	t.rootLatch.Lock()
+	defer t.rootLatch.Unlock()
+	current := t.root
+	current.getLatch().Lock()
+	path := make([]*InteriorNode, 0)
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
 			break
 		}
-		s1 = s1Next
-		s2 = s2Next
+		path = append(path, node)
+		idx, _ := node.Scan(key)
+		current = node.next[idx]
+		current.getLatch().Lock()
 	}
 
-	t.count++
-	return leaf, path, nil
+	for i := len(path) - 1; i >= 0; i-- {
+		idx, _ := path[i].Scan(key)
+		path[i].refreshCount(int(idx))
+	}
+
+	current.getLatch().Unlock()
+	for _, node := range path {
+		node.latch.Unlock()
+	}
+}
+
+// rebalance rebalances the child at the index of the write-latched node, write-latching its siblings meanwhile.
+func rebalance(node *InteriorNode, index int) {
+	siblings := make([]Node, 0, 2)
+	if index > 0 {
+		siblings = append(siblings, node.next[index-1])
+	}
+	if index < node.count {
+		siblings = append(siblings, node.next[index+1])
+	}
+	for _, sibling := range siblings {
+		sibling.getLatch().Lock()
+	}
+
+	node.Rebalance(index)
+
+	for _, sibling := range siblings {
+		sibling.getLatch().Unlock()
+	}
+}
+
+// addToCounts atomically adds delta to the number of keys of the subtrees the key is routed to along the path.
+func addToCounts(key shared.KeyType, path []*InteriorNode, delta int) {
+	for _, node := range path {
+		idx, _ := node.Scan(key)
+		atomic.AddUint64(&node.counts[idx], uint64(delta)) // Wraps around for a negative delta
+	}
 }
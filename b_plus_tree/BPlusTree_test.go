@@ -0,0 +1,440 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/key_value"
+	"dmds_lab2/shared"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+var testCapacities = []uint64{2, 3, 4, 7, 32}
+
+// checkTree checks the invariants of the tree: sorted keys within the bounds of the separators, the occupancy of the nodes,
+// leaves all at the same depth, the counts of keys of the subtrees, and the chain of leaves holding the keys of the model in order.
+func checkTree(t *testing.T, tree *BPlusTree, model map[shared.KeyType]shared.ValueType) {
+	t.Helper()
+
+	leaves := make([]*LeafNode, 0)
+	leafDepth := -1
+	var check func(node Node, depth int, low, high *shared.KeyType, isRoot bool)
+	check = func(node Node, depth int, low, high *shared.KeyType, isRoot bool) {
+		switch node := node.(type) {
+		case *LeafNode:
+			if !isRoot && node.count < node.minCount() || node.count > node.capacity {
+				t.Fatalf("leaf holds %d keys, capacity %d", node.count, node.capacity)
+			}
+			if leafDepth != -1 && depth != leafDepth {
+				t.Fatalf("leaves at depths %d and %d", leafDepth, depth)
+			}
+			leafDepth = depth
+			for i := uint64(0); i < node.count; i++ {
+				key := node.keys[i]
+				if i > 0 && key <= node.keys[i-1] || low != nil && key < *low || high != nil && key >= *high {
+					t.Fatalf("leaf key %d out of order or bounds", key)
+				}
+			}
+			leaves = append(leaves, node)
+		case *InteriorNode:
+			if isRoot && node.count == 0 || !isRoot && node.count < node.minCount() || node.count > node.capacity {
+				t.Fatalf("interior node holds %d keys, capacity %d", node.count, node.capacity)
+			}
+			for i := 0; i <= node.count; i++ {
+				childLow, childHigh := low, high
+				if i > 0 {
+					childLow = &node.keys[i-1]
+				}
+				if i < node.count {
+					childHigh = &node.keys[i]
+				}
+				check(node.next[i], depth+1, childLow, childHigh, false)
+				if node.counts[i] != node.next[i].Count() {
+					t.Fatalf("interior node counts %d keys in a subtree holding %d", node.counts[i], node.next[i].Count())
+				}
+			}
+		}
+	}
+	check(tree.root, 0, nil, nil, true)
+
+	keys := make([]shared.KeyType, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	position := 0
+	leaf := leaves[0]
+	for i := 0; leaf != nil; i++ {
+		if i >= len(leaves) || leaf != leaves[i] || i > 0 && leaf.prev != leaves[i-1] || i == 0 && leaf.prev != nil {
+			t.Fatalf("the chain of leaves does not follow the tree")
+		}
+		for j := uint64(0); j < leaf.count; j++ {
+			if position >= len(keys) || leaf.keys[j] != keys[position] || *leaf.values[j] != model[keys[position]] {
+				t.Fatalf("leaf holds %d at position %d, expected the keys %v", leaf.keys[j], position, keys)
+			}
+			position++
+		}
+		leaf = leaf.next
+	}
+	if position != len(keys) || tree.Count() != uint64(len(keys)) {
+		t.Fatalf("tree holds %d keys (count %d), expected %d", position, tree.Count(), len(keys))
+	}
+}
+
+func TestBPlusTree_RandomOperations(t *testing.T) {
+	for _, capacity := range testCapacities {
+		rng := rand.New(rand.NewSource(int64(capacity)))
+		tree := NewBPlusTree(capacity)
+		var store key_value.KeyValueStore = tree
+		model := make(map[shared.KeyType]shared.ValueType)
+
+		for i := 0; i < 5000; i++ {
+			key := shared.KeyType(rng.Intn(300))
+			value := shared.ValueType(rng.Uint64())
+			_, exists := model[key]
+
+			// Insert more often than delete in the first half, then delete more often so that the tree shrinks back
+			insertRatio := 65
+			if i >= 2500 {
+				insertRatio = 35
+			}
+
+			switch operation := rng.Intn(100); {
+			case operation < insertRatio:
+				if err := store.Insert(key, value); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", key, err)
+				}
+				model[key] = value
+			case operation < insertRatio+10:
+				err := store.Update(key, value)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("Update(%d) = %v, key exists: %v", key, err, exists)
+				}
+				if exists {
+					model[key] = value
+				}
+			default:
+				err := store.Delete(key)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("Delete(%d) = %v, key exists: %v", key, err, exists)
+				}
+				delete(model, key)
+			}
+
+			checkTree(t, tree, model)
+			got, err := store.Get(key)
+			if expected, ok := model[key]; ok && (err != nil || *got != expected) || !ok && !errors.Is(err, shared.KeyNotFoundError) {
+				t.Fatalf("Get(%d) = %v, %v after operation %d", key, got, err, i)
+			}
+		}
+	}
+}
+
+func TestBPlusTree_DeleteAll(t *testing.T) {
+	for _, capacity := range testCapacities {
+		rng := rand.New(rand.NewSource(int64(capacity)))
+		tree := NewBPlusTree(capacity)
+		model := make(map[shared.KeyType]shared.ValueType)
+		for key := shared.KeyType(0); key < 1000; key++ {
+			if err := tree.Insert(key, key*10); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+			model[key] = key * 10
+		}
+
+		for _, i := range rng.Perm(1000) {
+			key := shared.KeyType(i)
+			if err := tree.Delete(key); err != nil {
+				t.Fatalf("Delete(%d) failed: %v", key, err)
+			}
+			delete(model, key)
+			checkTree(t, tree, model)
+		}
+
+		if _, isLeafNode := tree.root.(*LeafNode); !isLeafNode {
+			t.Fatalf("expected the root to shrink back to a leaf")
+		}
+	}
+}
+
+func TestBPlusTree_KeySpaceBounds(t *testing.T) {
+	tree := NewBPlusTree(2)
+	model := make(map[shared.KeyType]shared.ValueType)
+	for _, key := range []shared.KeyType{math.MaxUint64, 0, 1, math.MaxUint64 - 1, 2, 0} {
+		if err := tree.Insert(key, key/2); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		model[key] = key / 2
+		checkTree(t, tree, model)
+	}
+
+	for _, key := range []shared.KeyType{0, math.MaxUint64} {
+		if value, err := tree.Get(key); err != nil || *value != key/2 {
+			t.Fatalf("Get(%d) = %v, %v", key, value, err)
+		}
+		if err := tree.Delete(key); err != nil {
+			t.Fatalf("Delete(%d) failed: %v", key, err)
+		}
+		delete(model, key)
+		checkTree(t, tree, model)
+		if _, err := tree.Get(key); !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("expected key %d to be deleted, got %v", key, err)
+		}
+	}
+}
+
+func TestBPlusTree_InsertExisting(t *testing.T) {
+	for _, capacity := range testCapacities {
+		tree := NewBPlusTree(capacity)
+		model := make(map[shared.KeyType]shared.ValueType)
+		for key := shared.KeyType(0); key < 200; key++ {
+			if err := tree.Insert(key, key); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+			model[key] = key
+		}
+
+		// Replacing the value of a key, including in a full leaf, neither adds a key nor splits a node
+		for key := shared.KeyType(0); key < 200; key++ {
+			if err := tree.Insert(key, key+1000); err != nil {
+				t.Fatalf("Insert(%d) over an existing key failed: %v", key, err)
+			}
+			model[key] = key + 1000
+		}
+		checkTree(t, tree, model)
+
+		for key := shared.KeyType(0); key < 200; key++ {
+			if value, err := tree.Get(key); err != nil || *value != key+1000 {
+				t.Fatalf("Get(%d) = %v, %v, expected %d", key, value, err, key+1000)
+			}
+		}
+	}
+}
+
+func TestBPlusTree_UpdateMissing(t *testing.T) {
+	tree := NewBPlusTree(2)
+	if err := tree.Update(1, 1); !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("Update on an empty tree = %v, expected KeyNotFoundError", err)
+	}
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 100; key += 2 {
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		model[key] = key
+	}
+
+	// Between the keys, around the separators, and past the greatest key
+	for _, key := range []shared.KeyType{1, 51, 99, 100, math.MaxUint64} {
+		if err := tree.Update(key, 1); !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("Update(%d) = %v, expected KeyNotFoundError", key, err)
+		}
+		if _, err := tree.Get(key); !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("Update(%d) inserted the key", key)
+		}
+	}
+	checkTree(t, tree, model)
+}
+
+func TestBPlusTree_GetAfterSplits(t *testing.T) {
+	for _, capacity := range testCapacities {
+		rng := rand.New(rand.NewSource(int64(capacity)))
+		tree := NewBPlusTree(capacity)
+		keys := rng.Perm(2000)
+		for _, i := range keys {
+			key := shared.KeyType(i)
+			if err := tree.Insert(key, key*3); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+		}
+		if _, isLeafNode := tree.root.(*LeafNode); isLeafNode {
+			t.Fatalf("capacity %d: expected the root to have split", capacity)
+		}
+
+		for _, i := range keys {
+			key := shared.KeyType(i)
+			if value, err := tree.Get(key); err != nil || *value != key*3 {
+				t.Fatalf("capacity %d: Get(%d) = %v, %v, expected %d", capacity, key, value, err, key*3)
+			}
+		}
+		if tree.Count() != uint64(len(keys)) {
+			t.Fatalf("tree counts %d keys, expected %d", tree.Count(), len(keys))
+		}
+	}
+}
+
+func TestBPlusTree_Concurrent(t *testing.T) {
+	const goroutines = 8
+	const hotKeys = 16
+
+	for _, capacity := range testCapacities {
+		tree := NewBPlusTree(capacity)
+		models := make([]map[shared.KeyType]shared.ValueType, goroutines)
+		var wg sync.WaitGroup
+		for g := range models {
+			models[g] = make(map[shared.KeyType]shared.ValueType)
+			wg.Add(1)
+			go func(g int, model map[shared.KeyType]shared.ValueType) {
+				defer wg.Done()
+				rng := rand.New(rand.NewSource(int64(capacity)*goroutines + int64(g)))
+
+				for i := 0; i < 3000; i++ {
+					// The hot keys are shared by all the goroutines and hold themselves as values
+					if rng.Intn(2) == 0 {
+						key := shared.KeyType(rng.Intn(hotKeys))
+						switch rng.Intn(4) {
+						case 0:
+							_ = tree.Insert(key, key)
+						case 1:
+							_ = tree.Delete(key)
+						case 2:
+							_ = tree.Update(key, key)
+							tree.Select(key)
+						default:
+							if value, err := tree.Get(key); err == nil && *value != key {
+								t.Errorf("Get(%d) = %d", key, *value)
+								return
+							}
+						}
+						continue
+					}
+
+					// The other keys are owned by a single goroutine, which checks them against its model
+					key := hotKeys + shared.KeyType(rng.Intn(500)*goroutines+g)
+					value := shared.ValueType(rng.Uint64())
+					_, exists := model[key]
+					insertRatio := 60
+					if i >= 1500 {
+						insertRatio = 30
+					}
+
+					switch operation := rng.Intn(100); {
+					case operation < insertRatio:
+						if err := tree.Insert(key, value); err != nil {
+							t.Errorf("Insert(%d) failed: %v", key, err)
+							return
+						}
+						model[key] = value
+					case operation < insertRatio+20:
+						err := tree.Delete(key)
+						if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+							t.Errorf("Delete(%d) = %v, key exists: %v", key, err, exists)
+							return
+						}
+						delete(model, key)
+					default:
+						got, err := tree.Get(key)
+						if expected, ok := model[key]; ok && (err != nil || *got != expected) || !ok && !errors.Is(err, shared.KeyNotFoundError) {
+							t.Errorf("Get(%d) = %v, %v", key, got, err)
+							return
+						}
+					}
+					tree.Rank(key)
+				}
+			}(g, models[g])
+		}
+		wg.Wait()
+		if t.Failed() {
+			return
+		}
+
+		model := make(map[shared.KeyType]shared.ValueType)
+		for _, goroutineModel := range models {
+			for key, value := range goroutineModel {
+				model[key] = value
+			}
+		}
+		for key := shared.KeyType(0); key < hotKeys; key++ {
+			if _, err := tree.Get(key); err == nil {
+				model[key] = key
+			}
+		}
+		checkTree(t, tree, model)
+	}
+}
+
+// The counts changed by latchPath before a concurrent operation inserted or deleted the key first must be repaired.
+func TestBPlusTree_RepairCounts(t *testing.T) {
+	tree := NewBPlusTree(2)
+	model := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 200; key += 2 {
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		model[key] = key
+	}
+
+	allSafe := func(Node, bool) bool { return true }
+	for key := shared.KeyType(0); key < 200; key++ {
+		delta := 1
+		if key%2 == 1 {
+			delta = -1
+		}
+		w := tree.latchPath(key, delta, allSafe)
+		if !w.speculative {
+			t.Fatalf("expected the counts of the path to %d to be changed", key)
+		}
+		w.unlatch(tree)
+		tree.repairCounts(key, w)
+		checkTree(t, tree, model)
+	}
+}
+
+func TestBPlusTree_Fanout(t *testing.T) {
+	if leaf, interior := NodeCapacitiesForSize(CacheLineSize); leaf != 3 || interior != minCapacity {
+		t.Fatalf("NodeCapacitiesForSize(CacheLineSize) = %d, %d", leaf, interior)
+	}
+	if leaf, interior := NodeCapacitiesForSize(PageSize); leaf != 255 || interior != 126 {
+		t.Fatalf("NodeCapacitiesForSize(PageSize) = %d, %d", leaf, interior)
+	}
+
+	for _, fanout := range [][2]uint64{{2, 7}, {7, 2}, {3, 32}, {64, 4}} {
+		rng := rand.New(rand.NewSource(int64(fanout[0])))
+		pairs := make([]Pair, 500)
+		model := make(map[shared.KeyType]shared.ValueType)
+		for i := range pairs {
+			pairs[i] = Pair{Key: shared.KeyType(i * 2), Value: shared.ValueType(i)}
+			model[pairs[i].Key] = pairs[i].Value
+		}
+		tree, err := BulkLoadWithFanout(fanout[0], fanout[1], pairs, 0.7)
+		if err != nil {
+			t.Fatalf("BulkLoadWithFanout failed: %v", err)
+		}
+
+		for i := 0; i < 2000; i++ {
+			key := shared.KeyType(rng.Intn(1500))
+			if i%2 == 0 {
+				_ = tree.Delete(key)
+				delete(model, key)
+			} else {
+				if err := tree.Insert(key, key); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", key, err)
+				}
+				model[key] = key
+			}
+		}
+		checkTree(t, tree, model)
+
+		var check func(node Node)
+		check = func(node Node) {
+			switch node := node.(type) {
+			case *LeafNode:
+				if node.capacity != fanout[0] {
+					t.Fatalf("leaf of capacity %d, expected %d", node.capacity, fanout[0])
+				}
+			case *InteriorNode:
+				if uint64(node.capacity) != fanout[1] {
+					t.Fatalf("interior node of capacity %d, expected %d", node.capacity, fanout[1])
+				}
+				for i := 0; i <= node.count; i++ {
+					check(node.next[i])
+				}
+			}
+		}
+		check(tree.root)
+	}
+}
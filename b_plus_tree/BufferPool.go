@@ -0,0 +1,192 @@
+package b_plus_tree
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// DefaultBufferPoolSize is the default number of pages held in memory by the buffer pool of a DiskBPlusTree.
+const DefaultBufferPoolSize = 256
+
+// BufferPoolFullError is the error returned when a page has to be loaded but all the pages of the buffer pool are pinned.
+var BufferPoolFullError = errors.New("all the pages of the buffer pool are pinned")
+
+// frame holds a page in the buffer pool.
+type frame struct {
+	id       PageID
+	data     []byte
+	pinCount int
+	dirty    bool          // The page has been modified since it was read, it is written back when evicted or flushed
+	element  *list.Element // Position in the list of the unpinned pages, nil while pinned
+}
+
+// BufferPool keeps up to capacity pages of a file in memory. A page is pinned while it is used (FetchPage or NewPage) and
+// cannot be evicted until it is unpinned; the least recently unpinned page is evicted first (LRU), and written back if dirty.
+// It is safe for concurrent use, the data of a page is only protected by the pin: concurrent users must not modify it.
+type BufferPool struct {
+	mutex    sync.Mutex // Protects the frames and the pager
+	pager    *Pager
+	capacity int
+	frames   map[PageID]*frame
+	unpinned *list.List // Unpinned frames, from the most recently used (front) to the least recently used (back)
+}
+
+// FetchPage pins the page and returns its data, read from the file if it is not in the pool.
+// The data may be modified until the page is unpinned with dirty set.
+func (b *BufferPool) FetchPage(id PageID) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if f, ok := b.frames[id]; ok {
+		b.pin(f)
+		return f.data, nil
+	}
+
+	f, err := b.newFrame(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.pager.ReadPage(id, f.data); err != nil {
+		delete(b.frames, id)
+		return nil, err
+	}
+	return f.data, nil
+}
+
+// NewPage allocates a page and returns it pinned, zeroed and dirty.
+func (b *BufferPool) NewPage() (PageID, []byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.frames) >= b.capacity && b.unpinned.Len() == 0 {
+		return 0, nil, BufferPoolFullError
+	}
+
+	id, err := b.pager.Allocate()
+	if err != nil {
+		return 0, nil, err
+	}
+	f, err := b.newFrame(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	f.dirty = true
+	return id, f.data, nil
+}
+
+// UnpinPage releases a page pinned by FetchPage or NewPage, dirty tells whether its data has been modified.
+func (b *BufferPool) UnpinPage(id PageID, dirty bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	f, ok := b.frames[id]
+	if !ok || f.pinCount == 0 {
+		return errors.New("page not pinned")
+	}
+
+	f.dirty = f.dirty || dirty
+	f.pinCount--
+	if f.pinCount == 0 {
+		f.element = b.unpinned.PushFront(f)
+	}
+	return nil
+}
+
+// FreePage removes an unpinned page from the pool and returns it to the free-page list of the file.
+func (b *BufferPool) FreePage(id PageID) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if f, ok := b.frames[id]; ok {
+		if f.pinCount > 0 {
+			return errors.New("page pinned")
+		}
+		b.unpinned.Remove(f.element)
+		delete(b.frames, id)
+	}
+	return b.pager.Free(id)
+}
+
+// writeFreeList writes the free pages of the file in a free list of the transaction (see Pager.writeFreeList), it returns
+// the pages of the list.
+func (b *BufferPool) writeFreeList(txID uint64, pending []PageID) ([]PageID, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.pager.writeFreeList(txID, pending)
+}
+
+// FlushAll writes all the dirty pages back to the file.
+func (b *BufferPool) FlushAll() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, f := range b.frames {
+		if err := b.flush(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPinnedCount returns the number of pages currently pinned.
+func (b *BufferPool) GetPinnedCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.frames) - b.unpinned.Len()
+}
+
+func (b *BufferPool) flush(f *frame) error {
+	if !f.dirty {
+		return nil
+	}
+	if err := b.pager.WritePage(f.id, f.data); err != nil {
+		return err
+	}
+	f.dirty = false
+	return nil
+}
+
+func (b *BufferPool) pin(f *frame) {
+	if f.pinCount == 0 {
+		b.unpinned.Remove(f.element)
+		f.element = nil
+	}
+	f.pinCount++
+}
+
+// newFrame returns a pinned frame for the page, evicting the least recently used page if the pool is full.
+func (b *BufferPool) newFrame(id PageID) (*frame, error) {
+	var data []byte
+	if len(b.frames) >= b.capacity {
+		back := b.unpinned.Back()
+		if back == nil {
+			return nil, BufferPoolFullError
+		}
+		victim := back.Value.(*frame)
+		if err := b.flush(victim); err != nil {
+			return nil, err
+		}
+		b.unpinned.Remove(back)
+		delete(b.frames, victim.id)
+		data = victim.data
+		clear(data)
+	} else {
+		data = make([]byte, PageSize)
+	}
+
+	f := &frame{id: id, data: data, pinCount: 1}
+	b.frames[id] = f
+	return f, nil
+}
+
+func NewBufferPool(pager *Pager, capacity int) *BufferPool {
+	return &BufferPool{
+		pager:    pager,
+		capacity: max(capacity, 1),
+		frames:   make(map[PageID]*frame),
+		unpinned: list.New(),
+	}
+}
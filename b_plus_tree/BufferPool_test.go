@@ -0,0 +1,52 @@
+package b_plus_tree
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBufferPool_Eviction(t *testing.T) {
+	file, err := os.Create(path.Join(t.TempDir(), "pages"))
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer file.Close()
+	bufferPool := NewBufferPool(NewPager(file, 0, 0), 2)
+
+	// Write a marker in 3 pages, more than the pool holds
+	for i := 0; i < 3; i++ {
+		id, data, err := bufferPool.NewPage()
+		if err != nil {
+			t.Fatalf("NewPage failed: %v", err)
+		}
+		data[0] = byte(id + 1)
+		if err := bufferPool.UnpinPage(id, true); err != nil {
+			t.Fatalf("UnpinPage failed: %v", err)
+		}
+	}
+
+	// The page 0 has been evicted and written back
+	data, err := bufferPool.FetchPage(0)
+	if err != nil || data[0] != 1 {
+		t.Fatalf("FetchPage(0) = %v, %v", data[:1], err)
+	}
+
+	// Pinned pages are not evicted
+	if _, err := bufferPool.FetchPage(1); err != nil {
+		t.Fatalf("FetchPage(1) failed: %v", err)
+	}
+	if _, err := bufferPool.FetchPage(2); !errors.Is(err, BufferPoolFullError) {
+		t.Fatalf("expected the pool to be full, got %v", err)
+	}
+	if err := bufferPool.UnpinPage(1, false); err != nil {
+		t.Fatalf("UnpinPage failed: %v", err)
+	}
+	if data, err := bufferPool.FetchPage(2); err != nil || data[0] != 3 {
+		t.Fatalf("FetchPage(2) = %v, %v", data, err)
+	}
+	if bufferPool.GetPinnedCount() != 2 {
+		t.Fatalf("expected 2 pinned pages, got %d", bufferPool.GetPinnedCount())
+	}
+}
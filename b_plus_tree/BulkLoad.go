@@ -0,0 +1,117 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"math"
+)
+
+// Pair is a key-value pair loaded into a BPlusTree by BulkLoad.
+type Pair struct {
+	Key   shared.KeyType
+	Value shared.ValueType
+}
+
+// BulkLoad builds a tree of the given capacity from pairs sorted by strictly increasing keys, in O(n): the leaves are filled
+// from left to right, then every level of interior nodes is built on top of the previous one up to the root.
+// The nodes are filled to fillFactor of their capacity (in (0, 1]), leaving room for later inserts before they split.
+// Sorted runs such as the records of an SSTable can be indexed this way without the splits of top-down inserts.
+func BulkLoad(capacity uint64, sortedPairs []Pair, fillFactor float64) (*BPlusTree, error) {
+	return BulkLoadWithFanout(capacity, capacity, sortedPairs, fillFactor)
+}
+
+// BulkLoadWithFanout is BulkLoad with distinct capacities for the leaves and the interior nodes, see NewBPlusTreeWithFanout.
+func BulkLoadWithFanout(leafCapacity uint64, interiorCapacity uint64, sortedPairs []Pair, fillFactor float64) (*BPlusTree, error) {
+	if fillFactor <= 0 || fillFactor > 1 {
+		return nil, errors.New("invalid fill factor: it must be in (0, 1]")
+	}
+	for i := 1; i < len(sortedPairs); i++ {
+		if sortedPairs[i].Key <= sortedPairs[i-1].Key {
+			return nil, errors.New("the pairs are not sorted by strictly increasing keys")
+		}
+	}
+
+	tree := NewBPlusTreeWithFanout(leafCapacity, interiorCapacity)
+	if len(sortedPairs) == 0 {
+		return tree, nil
+	}
+	leafCapacity, interiorCapacity = tree.leafCapacity, tree.interiorCapacity
+	tree.count.Store(uint64(len(sortedPairs)))
+
+	// Build the leaves and link them
+	leafSizes := getNodeSizes(len(sortedPairs), fillCount(leafCapacity, fillFactor, (leafCapacity+1)/2), int((leafCapacity+1)/2), int(leafCapacity))
+	nodes := make([]Node, 0, len(leafSizes))
+	firstKeys := make([]shared.KeyType, 0, len(leafSizes))
+	var previous *LeafNode
+	for _, size := range leafSizes {
+		leaf := NewLeafNode(leafCapacity)
+		for i, pair := range sortedPairs[:size] {
+			value := pair.Value
+			leaf.keys[i] = pair.Key
+			leaf.values[i] = &value
+		}
+		leaf.count = uint64(size)
+		leaf.prev = previous
+		if previous != nil {
+			previous.next = leaf
+		}
+		previous = leaf
+
+		nodes = append(nodes, leaf)
+		firstKeys = append(firstKeys, sortedPairs[0].Key)
+		sortedPairs = sortedPairs[size:]
+	}
+
+	// Build the interior levels, the first key of every child but the first one separates it from the child on its left
+	minChildren := int(interiorCapacity/2) + 1
+	for len(nodes) > 1 {
+		sizes := getNodeSizes(len(nodes), fillCount(interiorCapacity, fillFactor, interiorCapacity/2)+1, minChildren, int(interiorCapacity)+1)
+		parents := make([]Node, 0, len(sizes))
+		parentFirstKeys := make([]shared.KeyType, 0, len(sizes))
+		for _, size := range sizes {
+			interior := NewInteriorNode(interiorCapacity)
+			for i, child := range nodes[:size] {
+				interior.next[i] = child
+				interior.counts[i] = child.Count()
+				if i > 0 {
+					interior.keys[i-1] = firstKeys[i]
+				}
+			}
+			interior.count = size - 1
+
+			parents = append(parents, interior)
+			parentFirstKeys = append(parentFirstKeys, firstKeys[0])
+			nodes, firstKeys = nodes[size:], firstKeys[size:]
+		}
+		nodes, firstKeys = parents, parentFirstKeys
+	}
+
+	tree.root = nodes[0]
+	return tree, nil
+}
+
+// fillCount returns the number of keys of a node of the capacity filled to the fill factor, at least minCount.
+func fillCount(capacity uint64, fillFactor float64, minCount uint64) int {
+	count := uint64(math.Round(float64(capacity) * fillFactor))
+	return int(min(max(count, minCount, 1), capacity))
+}
+
+// getNodeSizes splits n items (keys of leaves or children of interior nodes) into nodes of fill items.
+// The last node is merged with the one before it, or shares their items evenly with it, if it would hold less than minSize.
+func getNodeSizes(n int, fill int, minSize int, maxSize int) []int {
+	sizes := make([]int, 0, n/fill+1)
+	for remaining := n; remaining > 0; remaining -= fill {
+		sizes = append(sizes, min(fill, remaining))
+	}
+
+	last := len(sizes) - 1
+	if last > 0 && sizes[last] < minSize {
+		total := sizes[last-1] + sizes[last]
+		if total <= maxSize {
+			sizes = append(sizes[:last-1], total)
+		} else {
+			sizes[last-1], sizes[last] = total-total/2, total/2
+		}
+	}
+	return sizes
+}
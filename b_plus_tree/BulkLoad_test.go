@@ -0,0 +1,56 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"math/rand"
+	"testing"
+)
+
+func TestBulkLoad(t *testing.T) {
+	for _, capacity := range testCapacities {
+		for _, fillFactor := range []float64{0.1, 0.5, 0.7, 1} {
+			for _, n := range []int{0, 1, 2, int(capacity) + 1, 1000} {
+				rng := rand.New(rand.NewSource(int64(n)))
+				pairs := make([]Pair, n)
+				model := make(map[shared.KeyType]shared.ValueType)
+				for i := range pairs {
+					pairs[i] = Pair{Key: shared.KeyType(i * 3), Value: rng.Uint64()}
+					model[pairs[i].Key] = pairs[i].Value
+				}
+
+				tree, err := BulkLoad(capacity, pairs, fillFactor)
+				if err != nil {
+					t.Fatalf("BulkLoad failed: %v", err)
+				}
+				checkTree(t, tree, model)
+
+				// The tree is usable as if it had been built by inserts
+				for i := 0; i < 200; i++ {
+					key := shared.KeyType(rng.Intn(3 * (n + 1)))
+					if i%2 == 0 {
+						_ = tree.Delete(key)
+						delete(model, key)
+					} else {
+						if err := tree.Insert(key, key); err != nil {
+							t.Fatalf("Insert(%d) failed: %v", key, err)
+						}
+						model[key] = key
+					}
+				}
+				checkTree(t, tree, model)
+			}
+		}
+	}
+}
+
+func TestBulkLoad_InvalidInput(t *testing.T) {
+	if _, err := BulkLoad(4, []Pair{{Key: 2}, {Key: 1}}, 1); err == nil {
+		t.Fatalf("expected unsorted pairs to be rejected")
+	}
+	if _, err := BulkLoad(4, []Pair{{Key: 1}, {Key: 1}}, 1); err == nil {
+		t.Fatalf("expected duplicate keys to be rejected")
+	}
+	if _, err := BulkLoad(4, nil, 0); err == nil {
+		t.Fatalf("expected a fill factor of 0 to be rejected")
+	}
+}
@@ -0,0 +1,150 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"sync/atomic"
+)
+
+// Cursor walks the keys of a BPlusTree in order, in both directions, along the chain of leaves.
+// A cursor is positioned on a key while Valid returns true. It does not latch the leaves, so it must not be used while or
+// after the tree is modified.
+type Cursor struct {
+	leaf  *LeafNode
+	index uint64
+	start shared.KeyType // The cursor is not valid before start when bounded
+	end   shared.KeyType // The cursor is not valid from end when bounded
+	bound bool
+}
+
+// Valid returns whether the cursor is positioned on a key.
+func (c *Cursor) Valid() bool {
+	if c.leaf == nil || c.index >= c.leaf.count {
+		return false
+	}
+	key := c.leaf.keys[c.index]
+	return !c.bound || key >= c.start && key < c.end
+}
+
+// Key returns the key the cursor is positioned on.
+func (c *Cursor) Key() shared.KeyType {
+	return c.leaf.keys[c.index]
+}
+
+// Value returns the value of the key the cursor is positioned on.
+func (c *Cursor) Value() shared.ValueType {
+	return *c.leaf.values[c.index]
+}
+
+// Next moves the cursor to the next key and returns whether it is valid.
+func (c *Cursor) Next() bool {
+	if c.leaf == nil {
+		return false
+	}
+
+	c.index++
+	if c.index >= c.leaf.count && c.leaf.next != nil {
+		c.leaf, c.index = c.leaf.next, 0
+	}
+	return c.Valid()
+}
+
+// Prev moves the cursor to the previous key and returns whether it is valid.
+func (c *Cursor) Prev() bool {
+	if c.leaf == nil {
+		return false
+	}
+
+	if c.index > 0 {
+		c.index--
+	} else if c.leaf.prev != nil {
+		c.leaf, c.index = c.leaf.prev, c.leaf.prev.count-1
+	} else {
+		// Before the first key
+		c.leaf = nil
+	}
+	return c.Valid()
+}
+
+// Seek returns a cursor positioned on the first key greater than or equal to the key, not valid if there is none.
+func (t *BPlusTree) Seek(key shared.KeyType) *Cursor {
+	leaf := t.readLeaf(key)
+	defer leaf.latch.RUnlock()
+
+	idx, _ := leaf.Scan(key)
+	if idx >= leaf.count && leaf.next != nil {
+		return &Cursor{leaf: leaf.next}
+	}
+	return &Cursor{leaf: leaf, index: idx}
+}
+
+// Range returns a cursor over the keys in [start, end), positioned on the first one.
+func (t *BPlusTree) Range(start shared.KeyType, end shared.KeyType) *Cursor {
+	cursor := t.Seek(start)
+	cursor.start, cursor.end, cursor.bound = start, end, true
+	return cursor
+}
+
+// Min returns a cursor positioned on the smallest key, not valid if the tree is empty.
+func (t *BPlusTree) Min() *Cursor {
+	return t.Select(0)
+}
+
+// Max returns a cursor positioned on the greatest key, not valid if the tree is empty.
+func (t *BPlusTree) Max() *Cursor {
+	count := t.Count()
+	if count == 0 {
+		return &Cursor{}
+	}
+	return t.Select(count - 1)
+}
+
+// Rank returns the number of keys lower than the key, using the number of keys of the subtrees kept by the interior nodes.
+func (t *BPlusTree) Rank(key shared.KeyType) uint64 {
+	rank := uint64(0)
+	current := t.latchRoot(false)
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
+			idx, _ := current.Scan(key)
+			current.getLatch().RUnlock()
+			return rank + idx
+		}
+
+		idx, _ := node.Scan(key)
+		for i := uint64(0); i < idx; i++ {
+			rank += atomic.LoadUint64(&node.counts[i])
+		}
+		current = node.next[idx]
+		current.getLatch().RLock()
+		node.latch.RUnlock()
+	}
+}
+
+// Select returns a cursor positioned on the key of rank k (the k+1-th smallest), not valid if the tree holds k keys or less.
+func (t *BPlusTree) Select(k uint64) *Cursor {
+	if k >= t.Count() {
+		return &Cursor{}
+	}
+
+	current := t.latchRoot(false)
+	for {
+		node, isInteriorNode := current.(*InteriorNode)
+		if !isInteriorNode {
+			current.getLatch().RUnlock()
+			return &Cursor{leaf: current.(*LeafNode), index: k}
+		}
+
+		idx := 0
+		for idx < node.count {
+			count := atomic.LoadUint64(&node.counts[idx])
+			if k < count {
+				break
+			}
+			k -= count
+			idx++
+		}
+		current = node.next[idx]
+		current.getLatch().RLock()
+		node.latch.RUnlock()
+	}
+}
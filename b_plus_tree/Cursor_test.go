@@ -0,0 +1,93 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestCursor_Walk(t *testing.T) {
+	for _, capacity := range testCapacities {
+		rng := rand.New(rand.NewSource(int64(capacity)))
+		tree := NewBPlusTree(capacity)
+		if tree.Min().Valid() || tree.Max().Valid() || tree.Seek(0).Valid() {
+			t.Fatalf("expected no key in an empty tree")
+		}
+
+		model := make(map[shared.KeyType]shared.ValueType)
+		for i := 0; i < 2000; i++ {
+			key := shared.KeyType(rng.Intn(4000))
+			if i%4 == 3 {
+				_ = tree.Delete(key)
+				delete(model, key)
+				continue
+			}
+			if err := tree.Insert(key, key+1); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+			model[key] = key + 1
+		}
+		checkTree(t, tree, model)
+
+		keys := make([]shared.KeyType, 0, len(model))
+		for key := range model {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		// Forward from Min and backward from Max
+		position := 0
+		for cursor := tree.Min(); cursor.Valid(); cursor.Next() {
+			if cursor.Key() != keys[position] || cursor.Value() != model[keys[position]] {
+				t.Fatalf("cursor at %d, expected %d", cursor.Key(), keys[position])
+			}
+			position++
+		}
+		if position != len(keys) {
+			t.Fatalf("walked %d keys forward, expected %d", position, len(keys))
+		}
+		for cursor := tree.Max(); cursor.Valid(); cursor.Prev() {
+			position--
+			if cursor.Key() != keys[position] {
+				t.Fatalf("cursor at %d, expected %d", cursor.Key(), keys[position])
+			}
+		}
+		if position != 0 {
+			t.Fatalf("walked %d keys backward, expected %d", len(keys)-position, len(keys))
+		}
+
+		for i := 0; i < 200; i++ {
+			start := shared.KeyType(rng.Intn(4100))
+			end := start + shared.KeyType(rng.Intn(300))
+			first := sort.Search(len(keys), func(j int) bool { return keys[j] >= start })
+
+			// Seek, Rank and Select agree with the position of the first key greater than or equal to start
+			cursor := tree.Seek(start)
+			if first == len(keys) && cursor.Valid() || first < len(keys) && (!cursor.Valid() || cursor.Key() != keys[first]) {
+				t.Fatalf("Seek(%d) is not positioned on the key of rank %d", start, first)
+			}
+			if rank := tree.Rank(start); rank != uint64(first) {
+				t.Fatalf("Rank(%d) = %d, expected %d", start, rank, first)
+			}
+			if selected := tree.Select(uint64(first)); first < len(keys) && selected.Key() != keys[first] || first == len(keys) && selected.Valid() {
+				t.Fatalf("Select(%d) is not positioned on %d", first, start)
+			}
+			if first > 0 && (!cursor.Prev() || cursor.Key() != keys[first-1]) {
+				t.Fatalf("Prev after Seek(%d) is not positioned on %d", start, keys[first-1])
+			}
+
+			// Range stops at end
+			count := 0
+			for cursor := tree.Range(start, end); cursor.Valid(); cursor.Next() {
+				if cursor.Key() != keys[first+count] {
+					t.Fatalf("Range(%d, %d) at %d, expected %d", start, end, cursor.Key(), keys[first+count])
+				}
+				count++
+			}
+			if first+count < len(keys) && keys[first+count] < end {
+				t.Fatalf("Range(%d, %d) stopped before %d", start, end, keys[first+count])
+			}
+		}
+	}
+}
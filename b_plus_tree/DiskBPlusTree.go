@@ -0,0 +1,765 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+var FileNotOpenError = errors.New("file not open")
+
+var FileAlreadyOpenError = errors.New("file already open")
+
+// diskBPlusTreeMagic identifies the files of a DiskBPlusTree, it is the first field of the meta pages.
+const diskBPlusTreeMagic = 0x31305450_4c505442 // "BTPLPT01"
+
+// The meta pages store: magic | page size | transaction | page count | free-page list head | root | key count |
+// leaf capacity | interior capacity | copy-on-write | checksum of the previous fields (CRC-32).
+// In copy-on-write mode, the free-page list head is the first page of the free list written by the transaction.
+const (
+	metaFieldCount = 10
+	metaSize       = (metaFieldCount + 1) * 8
+)
+
+// DiskBPlusTree is a B+ tree stored in the pages of a file, read and written through a buffer pool.
+//
+// The state of the tree is committed by Commit (and Close) in one of the two meta pages, alternately: the pages of the nodes
+// are written first, then the meta page of the new transaction. Open reads the meta page of the last transaction whose
+// checksum is valid, so a commit interrupted by a crash leaves the previous one.
+// The tree is only crash consistent in copy-on-write mode (see SetCopyOnWrite), otherwise the pages are modified in place.
+//
+// It implements key_value.KeyValueStoreDisk, Delete removes the file: the keys are deleted with DeleteKey.
+// The writes must not be concurrent, the snapshots can be read concurrently with them.
+type DiskBPlusTree struct {
+	path             string
+	file             *os.File
+	pager            *Pager
+	bufferPool       *BufferPool
+	bufferPoolSize   int
+	root             PageID
+	count            uint64
+	leafCapacity     uint64
+	interiorCapacity uint64
+	copyOnWrite      bool
+
+	// Copy-on-write
+	written     map[PageID]bool // Pages allocated by the current transaction, which are modified in place
+	pendingFree []PageID        // Pages of the last commit replaced by the current transaction
+	freed       []freedPages    // Pages replaced by the committed transactions, waiting for the snapshots that may read them
+	freeList    []PageID        // Pages of the free list written by the last commit
+
+	mutex          sync.Mutex // Protects the last commit and the snapshots
+	txID           uint64     // Last committed transaction
+	committedRoot  PageID
+	committedCount uint64
+	snapshots      map[uint64]int // Number of open snapshots of every transaction
+}
+
+// freedPages are the pages replaced by a transaction, they may be read by the snapshots of the previous transactions.
+type freedPages struct {
+	txID  uint64
+	pages []PageID
+}
+
+// NewDiskBPlusTree returns a tree stored in the file at the path, to create or open.
+// The nodes hold as many keys as fit in a page unless set otherwise with SetCapacity.
+func NewDiskBPlusTree(path string) *DiskBPlusTree {
+	return &DiskBPlusTree{
+		path:             path,
+		bufferPoolSize:   DefaultBufferPoolSize,
+		leafCapacity:     MaxLeafCapacity,
+		interiorCapacity: MaxInteriorCapacity,
+		snapshots:        make(map[uint64]int),
+	}
+}
+
+func (t *DiskBPlusTree) GetPath() string {
+	return t.path
+}
+
+// SetBufferPoolSize sets the number of pages of the buffer pool, it is used by the next Create or Open.
+func (t *DiskBPlusTree) SetBufferPoolSize(pageCount int) {
+	t.bufferPoolSize = pageCount
+}
+
+// SetCapacity sets the number of keys per node of a tree to create, at least minCapacity and at most what fits in a page.
+// The capacity of an existing tree is read from its meta page by Open.
+func (t *DiskBPlusTree) SetCapacity(capacity uint64) {
+	t.leafCapacity = min(max(capacity, minCapacity), MaxLeafCapacity)
+	t.interiorCapacity = min(max(capacity, minCapacity), MaxInteriorCapacity)
+}
+
+// SetCopyOnWrite sets the copy-on-write mode of a tree to create, like LMDB or bbolt: the committed pages are never modified,
+// a write copies the pages from the leaf to the root to new pages (once per transaction) and the commit switches to the new
+// root. It makes the commits crash consistent without a log, and allows snapshots of the committed trees.
+// The leaves are not linked in this mode, since a link would require copying the path to the sibling leaf as well.
+// The mode of an existing tree is read from its meta page by Open.
+func (t *DiskBPlusTree) SetCopyOnWrite(copyOnWrite bool) {
+	t.copyOnWrite = copyOnWrite
+}
+
+// Count returns the number of keys in the tree.
+func (t *DiskBPlusTree) Count() uint64 {
+	return t.count
+}
+
+// GetBufferPool returns the buffer pool of the open tree.
+func (t *DiskBPlusTree) GetBufferPool() *BufferPool {
+	return t.bufferPool
+}
+
+// Create creates the file with an empty tree: the meta pages and an empty root leaf.
+func (t *DiskBPlusTree) Create() error {
+	if t.file != nil {
+		return FileAlreadyOpenError
+	}
+	if _, err := os.Stat(t.path); err == nil {
+		return errors.New("file already exists")
+	}
+
+	file, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	t.open(file, NewPager(file, metaPageCount, 0), 0, 0, 0)
+
+	if t.root, err = t.newNode(&diskNode{isLeaf: true}); err == nil {
+		err = t.Commit()
+	}
+	if err != nil {
+		_ = t.closeFile()
+		return err
+	}
+	return nil
+}
+
+// Open opens the file of an existing tree at its last commit. In copy-on-write mode, the free pages are read from the free
+// list written by the commit. If it cannot be read (e.g. its page is corrupted), the pages not used by the tree are
+// collected instead, including the ones written by a transaction that was not committed.
+func (t *DiskBPlusTree) Open() error {
+	if t.file != nil {
+		return FileAlreadyOpenError
+	}
+
+	file, err := os.OpenFile(t.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	var meta []uint64
+	for id := PageID(0); id < metaPageCount; id++ {
+		data := make([]byte, metaSize)
+		if _, err := file.ReadAt(data, int64(id*PageSize)); err != nil {
+			continue
+		}
+		if fields, ok := decodeMeta(data); ok && (meta == nil || fields[2] > meta[2]) {
+			meta = fields
+		}
+	}
+	if meta == nil {
+		_ = file.Close()
+		return errors.New("invalid B+ tree file: no valid meta page")
+	}
+
+	t.leafCapacity, t.interiorCapacity, t.copyOnWrite = meta[7], meta[8], meta[9] == 1
+	if !t.copyOnWrite {
+		t.open(file, NewPager(file, meta[3], meta[4]), meta[2], meta[5], meta[6])
+		return nil
+	}
+
+	t.open(file, NewPager(file, meta[3], 0), meta[2], meta[5], meta[6])
+	if freeList, freePages, err := t.pager.readFreeList(meta[2], meta[4]); err == nil {
+		t.freeList, t.pager.freePages = freeList, freePages
+		return nil
+	}
+	if err := t.collectFreePages(); err != nil {
+		_ = t.closeFile()
+		return err
+	}
+	return nil
+}
+
+func (t *DiskBPlusTree) open(file *os.File, pager *Pager, txID uint64, root PageID, count uint64) {
+	t.file = file
+	t.pager = pager
+	t.bufferPool = NewBufferPool(pager, t.bufferPoolSize)
+	if t.copyOnWrite {
+		// A freed page may still be read by the previous commit, in case the meta page of the next one is torn
+		pager.KeepFreeListInMemory()
+	}
+	t.root, t.count = root, count
+	t.written = make(map[PageID]bool)
+	t.pendingFree, t.freed, t.freeList = nil, nil, nil
+
+	t.mutex.Lock()
+	t.txID, t.committedRoot, t.committedCount = txID, root, count
+	t.mutex.Unlock()
+}
+
+// collectFreePages rebuilds the in-memory free-page list with the pages that cannot be reached from the root, it is the
+// recovery when the free list of the commit cannot be read.
+func (t *DiskBPlusTree) collectFreePages() error {
+	used := make(map[PageID]bool)
+	pages := []PageID{t.root}
+	for len(pages) > 0 {
+		id := pages[len(pages)-1]
+		pages = pages[:len(pages)-1]
+		used[id] = true
+
+		node, err := t.readNode(id)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, node.children...)
+	}
+
+	t.pager.freeListHead = 0
+	t.pager.freePages = nil
+	for id := t.pager.GetPageCount() - 1; id >= metaPageCount; id-- {
+		if !used[id] {
+			if err := t.pager.Free(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Commit writes the pages modified since the last commit, then the meta page of the new transaction, and syncs the file
+// after each step. In copy-on-write mode, the free pages are written in a free list along with the pages, all the pages
+// not used by the transaction are free once the file is reopened. The pages replaced by the transactions are then freed
+// once no snapshot can read them.
+func (t *DiskBPlusTree) Commit() error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+
+	if err := t.bufferPool.FlushAll(); err != nil {
+		return err
+	}
+
+	txID := t.txID + 1
+	freeListHead := t.pager.freeListHead
+	var freeList []PageID
+	if t.copyOnWrite {
+		// The free list of the last commit is only free once this one is committed
+		pending := append(append([]PageID{}, t.pendingFree...), t.freeList...)
+		for _, freed := range t.freed {
+			pending = append(pending, freed.pages...)
+		}
+		var err error
+		if freeList, err = t.bufferPool.writeFreeList(txID, pending); err != nil {
+			return err
+		}
+		freeListHead = freeList[0]
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+
+	fields := []uint64{diskBPlusTreeMagic, PageSize, txID, t.pager.GetPageCount(), freeListHead, t.root, t.count, t.leafCapacity, t.interiorCapacity, 0}
+	if t.copyOnWrite {
+		fields[9] = 1
+	}
+	if _, err := t.file.WriteAt(encodeMeta(fields), int64(txID%metaPageCount*PageSize)); err != nil {
+		return err
+	}
+	if err := t.file.Sync(); err != nil {
+		return err
+	}
+
+	for _, id := range t.freeList {
+		if err := t.bufferPool.FreePage(id); err != nil {
+			return err
+		}
+	}
+	t.freeList = freeList
+
+	t.mutex.Lock()
+	t.txID, t.committedRoot, t.committedCount = txID, t.root, t.count
+	t.mutex.Unlock()
+
+	if len(t.pendingFree) > 0 {
+		t.freed = append(t.freed, freedPages{txID: txID, pages: t.pendingFree})
+	}
+	t.written = make(map[PageID]bool)
+	t.pendingFree = nil
+	return t.releaseFreedPages()
+}
+
+// Rollback discards the changes since the last commit, in copy-on-write mode only.
+func (t *DiskBPlusTree) Rollback() error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+	if !t.copyOnWrite {
+		return errors.New("rollback requires the copy-on-write mode")
+	}
+
+	for id := range t.written {
+		if err := t.bufferPool.FreePage(id); err != nil {
+			return err
+		}
+	}
+	t.mutex.Lock()
+	t.root, t.count = t.committedRoot, t.committedCount
+	t.mutex.Unlock()
+	t.written = make(map[PageID]bool)
+	t.pendingFree = nil
+	return nil
+}
+
+// releaseFreedPages frees the pages replaced by the transactions that no open snapshot precedes.
+func (t *DiskBPlusTree) releaseFreedPages() error {
+	t.mutex.Lock()
+	oldestSnapshot := t.txID
+	for txID := range t.snapshots {
+		oldestSnapshot = min(oldestSnapshot, txID)
+	}
+	t.mutex.Unlock()
+
+	for len(t.freed) > 0 && t.freed[0].txID <= oldestSnapshot {
+		for _, id := range t.freed[0].pages {
+			if err := t.bufferPool.FreePage(id); err != nil {
+				return err
+			}
+		}
+		t.freed = t.freed[1:]
+	}
+	return nil
+}
+
+// Close commits the tree and closes the file.
+func (t *DiskBPlusTree) Close() error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+
+	err := t.Commit()
+	return errors.Join(err, t.closeFile())
+}
+
+// Delete closes the file if it is open and removes it.
+func (t *DiskBPlusTree) Delete() error {
+	if t.file != nil {
+		if err := t.closeFile(); err != nil {
+			return err
+		}
+	}
+	return os.Remove(t.path)
+}
+
+func (t *DiskBPlusTree) closeFile() error {
+	err := t.file.Close()
+	t.file, t.pager, t.bufferPool = nil, nil, nil
+	return err
+}
+
+// encodeMeta encodes the fields of a meta page followed by their checksum.
+func encodeMeta(fields []uint64) []byte {
+	data := make([]byte, metaSize)
+	for i, field := range fields {
+		shared.Endianess.PutUint64(data[i*8:(i+1)*8], field)
+	}
+	shared.Endianess.PutUint64(data[metaFieldCount*8:], uint64(crc32.ChecksumIEEE(data[:metaFieldCount*8])))
+	return data
+}
+
+// decodeMeta decodes the fields of a meta page, it returns false if the page is not a valid meta page.
+func decodeMeta(data []byte) ([]uint64, bool) {
+	fields := make([]uint64, metaFieldCount)
+	for i := range fields {
+		fields[i] = shared.Endianess.Uint64(data[i*8 : (i+1)*8])
+	}
+	checksum := shared.Endianess.Uint64(data[metaFieldCount*8:])
+	valid := fields[0] == diskBPlusTreeMagic && fields[1] == PageSize && checksum == uint64(crc32.ChecksumIEEE(data[:metaFieldCount*8]))
+	return fields, valid
+}
+
+// readNode decodes the node of the page, the page is only pinned while it is decoded.
+func (t *DiskBPlusTree) readNode(id PageID) (*diskNode, error) {
+	data, err := t.bufferPool.FetchPage(id)
+	if err != nil {
+		return nil, err
+	}
+	node, err := decodeDiskNode(id, data)
+	return node, errors.Join(err, t.bufferPool.UnpinPage(id, false))
+}
+
+// writeNode encodes the node into its page. In copy-on-write mode, a page not written by the current transaction is replaced
+// by a new one: the page of the node changes, its parent has to be written with it as well.
+func (t *DiskBPlusTree) writeNode(node *diskNode) error {
+	if t.copyOnWrite && !t.written[node.id] {
+		replaced := node.id
+		if _, err := t.newNode(node); err != nil {
+			return err
+		}
+		return t.freePage(replaced)
+	}
+
+	data, err := t.bufferPool.FetchPage(node.id)
+	if err != nil {
+		return err
+	}
+	node.encode(data)
+	return t.bufferPool.UnpinPage(node.id, true)
+}
+
+// newNode allocates a page for the node and writes it, it returns the page.
+func (t *DiskBPlusTree) newNode(node *diskNode) (PageID, error) {
+	id, data, err := t.bufferPool.NewPage()
+	if err != nil {
+		return 0, err
+	}
+	node.id = id
+	node.encode(data)
+	if t.copyOnWrite {
+		t.written[id] = true
+	}
+	return id, t.bufferPool.UnpinPage(id, true)
+}
+
+// freePage frees the page of a node removed from the tree. In copy-on-write mode, the pages of the last commit are only freed
+// after the commit of the current transaction.
+func (t *DiskBPlusTree) freePage(id PageID) error {
+	if t.copyOnWrite && !t.written[id] {
+		t.pendingFree = append(t.pendingFree, id)
+		return nil
+	}
+	delete(t.written, id)
+	return t.bufferPool.FreePage(id)
+}
+
+// setPrev sets the previous leaf of the leaf of the page, if any. The leaves are not linked in copy-on-write mode.
+func (t *DiskBPlusTree) setPrev(id PageID, prev PageID) error {
+	if id == 0 || t.copyOnWrite {
+		return nil
+	}
+	leaf, err := t.readNode(id)
+	if err != nil {
+		return err
+	}
+	leaf.prev = prev
+	return t.writeNode(leaf)
+}
+
+// Get returns the value of the key, or KeyNotFoundError if the tree does not hold it.
+func (t *DiskBPlusTree) Get(key shared.KeyType) (*shared.ValueType, error) {
+	if t.file == nil {
+		return nil, FileNotOpenError
+	}
+	return t.get(t.root, key)
+}
+
+// get returns the value of the key in the tree of the root.
+func (t *DiskBPlusTree) get(root PageID, key shared.KeyType) (*shared.ValueType, error) {
+	node, err := t.readNode(root)
+	for err == nil && !node.isLeaf {
+		idx, _ := node.search(key)
+		node, err = t.readNode(node.children[idx])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	idx, found := node.search(key)
+	if !found {
+		return nil, shared.KeyNotFoundError
+	}
+	value := node.values[idx]
+	return &value, nil
+}
+
+// Insert inserts the key-value pair, or replaces the value if the key is already in the tree.
+func (t *DiskBPlusTree) Insert(key shared.KeyType, value shared.ValueType) error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+
+	root, right, midKey, err := t.insert(t.root, key, value, false)
+	if err != nil {
+		return err
+	}
+	t.root = root
+	if right == 0 {
+		return nil
+	}
+
+	t.root, err = t.newNode(&diskNode{keys: []shared.KeyType{midKey}, children: []PageID{root, right}})
+	return err
+}
+
+// Update replaces the value of the key, or returns KeyNotFoundError if the tree does not hold it.
+func (t *DiskBPlusTree) Update(key shared.KeyType, value shared.ValueType) error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+
+	root, _, _, err := t.insert(t.root, key, value, true)
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+// insert inserts the key-value pair in the subtree of the page, only if the key exists when onlyUpdate is set.
+// It returns the page of the node, which changes in copy-on-write mode. When the node overflows it is split: the page of the
+// new node on its right and their separator key are returned as well.
+func (t *DiskBPlusTree) insert(id PageID, key shared.KeyType, value shared.ValueType, onlyUpdate bool) (PageID, PageID, shared.KeyType, error) {
+	node, err := t.readNode(id)
+	if err != nil {
+		return id, 0, 0, err
+	}
+	idx, found := node.search(key)
+
+	if node.isLeaf {
+		if found {
+			node.values[idx] = value
+			return node.id, 0, 0, t.writeNode(node)
+		}
+		if onlyUpdate {
+			return id, 0, 0, shared.KeyNotFoundError
+		}
+
+		slots := node.leaf()
+		slots.insert(idx, key, value)
+		node.setLeaf(slots)
+		t.count++
+		if uint64(len(node.keys)) <= t.leafCapacity {
+			return node.id, 0, 0, t.writeNode(node)
+		}
+
+		right := &diskNode{isLeaf: true}
+		rightSlots := right.leaf()
+		slots.split(rightSlots)
+		node.setLeaf(slots)
+		right.setLeaf(rightSlots)
+		if !t.copyOnWrite {
+			right.next, right.prev = node.next, node.id
+		}
+		if _, err := t.newNode(right); err != nil {
+			return id, 0, 0, err
+		}
+		if err := t.setPrev(right.next, right.id); err != nil {
+			return id, 0, 0, err
+		}
+		if !t.copyOnWrite {
+			node.next = right.id
+		}
+		return node.id, right.id, right.keys[0], t.writeNode(node)
+	}
+
+	child, right, midKey, err := t.insert(node.children[idx], key, value, onlyUpdate)
+	if err != nil {
+		return id, 0, 0, err
+	}
+	if child == node.children[idx] && right == 0 {
+		return node.id, 0, 0, nil
+	}
+
+	node.children[idx] = child
+	slots := node.interior()
+	if right != 0 {
+		slots.insert(idx, midKey, right, 0)
+		node.setInterior(slots)
+	}
+	if uint64(len(node.keys)) <= t.interiorCapacity {
+		return node.id, 0, 0, t.writeNode(node)
+	}
+
+	newInterior := &diskNode{}
+	newSlots := newInterior.interior()
+	midKey = slots.split(newSlots)
+	node.setInterior(slots)
+	newInterior.setInterior(newSlots)
+	if _, err := t.newNode(newInterior); err != nil {
+		return id, 0, 0, err
+	}
+	return node.id, newInterior.id, midKey, t.writeNode(node)
+}
+
+// DeleteKey removes the key, or returns KeyNotFoundError if the tree does not hold it.
+// The underflowing nodes borrow from or are merged with a sibling, the pages of the merged nodes are freed.
+func (t *DiskBPlusTree) DeleteKey(key shared.KeyType) error {
+	if t.file == nil {
+		return FileNotOpenError
+	}
+
+	root, _, err := t.remove(t.root, key)
+	if err != nil {
+		return err
+	}
+	t.root = root
+
+	node, err := t.readNode(t.root)
+	if err != nil {
+		return err
+	}
+	if !node.isLeaf && len(node.keys) == 0 {
+		t.root = node.children[0]
+		return t.freePage(node.id)
+	}
+	return nil
+}
+
+// remove removes the key from the subtree of the page and returns the page of the node, which changes in copy-on-write mode,
+// and whether the node underflows.
+func (t *DiskBPlusTree) remove(id PageID, key shared.KeyType) (PageID, bool, error) {
+	node, err := t.readNode(id)
+	if err != nil {
+		return id, false, err
+	}
+	idx, found := node.search(key)
+
+	if node.isLeaf {
+		if !found {
+			return id, false, shared.KeyNotFoundError
+		}
+		slots := node.leaf()
+		slots.remove(idx)
+		node.setLeaf(slots)
+		t.count--
+		return node.id, len(node.keys) < minLeafCount(t.leafCapacity), t.writeNode(node)
+	}
+
+	child, underflows, err := t.remove(node.children[idx], key)
+	if err != nil {
+		return id, false, err
+	}
+	node.children[idx] = child
+	if underflows {
+		if err := t.rebalance(node, idx); err != nil {
+			return id, false, err
+		}
+	}
+	if err := t.writeNode(node); err != nil {
+		return id, false, err
+	}
+	return node.id, len(node.keys) < minInteriorCount(t.interiorCapacity), nil
+}
+
+// rebalance fixes the underflow of the child at the index of the node, by borrowing a key from a sibling that has more than
+// the minimum, or else by merging it with a sibling (see rebalanceSlots). The node is modified but not written.
+func (t *DiskBPlusTree) rebalance(node *diskNode, idx int) error {
+	child, err := t.readNode(node.children[idx])
+	if err != nil {
+		return err
+	}
+
+	var left, right *diskNode
+	if idx > 0 {
+		if left, err = t.readNode(node.children[idx-1]); err != nil {
+			return err
+		}
+	}
+	if idx < len(node.keys) {
+		if right, err = t.readNode(node.children[idx+1]); err != nil {
+			return err
+		}
+	}
+
+	slots := node.interior()
+	var action rebalanceAction
+	if child.isLeaf {
+		childSlots, leftSlots, rightSlots := child.leaf(), left.leaf(), right.leaf()
+		action = rebalanceSlots(slots, idx, childSlots, leftSlots, rightSlots, minLeafCount(t.leafCapacity))
+		child.setLeaf(childSlots)
+		left.setLeaf(leftSlots)
+		right.setLeaf(rightSlots)
+	} else {
+		childSlots, leftSlots, rightSlots := child.interior(), left.interior(), right.interior()
+		action = rebalanceSlots(slots, idx, childSlots, leftSlots, rightSlots, minInteriorCount(t.interiorCapacity))
+		child.setInterior(childSlots)
+		left.setInterior(leftSlots)
+		right.setInterior(rightSlots)
+	}
+	node.setInterior(slots)
+
+	switch action {
+	case borrowedFromLeft:
+		err = errors.Join(t.writeNode(left), t.writeNode(child))
+		node.children[idx-1], node.children[idx] = left.id, child.id
+	case borrowedFromRight:
+		err = errors.Join(t.writeNode(right), t.writeNode(child))
+		node.children[idx], node.children[idx+1] = child.id, right.id
+	case mergedIntoLeft:
+		err = t.writeMerged(node, idx-1, left, child)
+	case mergedWithRight:
+		err = t.writeMerged(node, idx, child, right)
+	}
+	return err
+}
+
+// writeMerged writes the left node, the child at the index of the node, into which the right node has been merged, and frees
+// the page of the right node.
+func (t *DiskBPlusTree) writeMerged(node *diskNode, idx int, left *diskNode, right *diskNode) error {
+	if left.isLeaf {
+		left.next = right.next
+		if err := t.setPrev(right.next, left.id); err != nil {
+			return err
+		}
+	}
+	if err := t.writeNode(left); err != nil {
+		return err
+	}
+	node.children[idx] = left.id
+	return t.freePage(right.id)
+}
+
+// DiskSnapshot is a read-only view of a committed DiskBPlusTree, which is not affected by the later writes.
+// Its pages are not freed until it is released, it must be released before the tree is closed.
+type DiskSnapshot struct {
+	tree     *DiskBPlusTree
+	root     PageID
+	count    uint64
+	txID     uint64
+	released bool
+}
+
+// Snapshot returns a snapshot of the last commit, in copy-on-write mode only. It can be read concurrently with the writes.
+func (t *DiskBPlusTree) Snapshot() (*DiskSnapshot, error) {
+	if t.file == nil {
+		return nil, FileNotOpenError
+	}
+	if !t.copyOnWrite {
+		return nil, errors.New("snapshots require the copy-on-write mode")
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.snapshots[t.txID]++
+	return &DiskSnapshot{tree: t, root: t.committedRoot, count: t.committedCount, txID: t.txID}, nil
+}
+
+// Get returns the value of the key at the time of the snapshot, or KeyNotFoundError if the tree did not hold it.
+func (s *DiskSnapshot) Get(key shared.KeyType) (*shared.ValueType, error) {
+	if s.released {
+		return nil, errors.New("snapshot released")
+	}
+	return s.tree.get(s.root, key)
+}
+
+// Count returns the number of keys at the time of the snapshot.
+func (s *DiskSnapshot) Count() uint64 {
+	return s.count
+}
+
+// Release releases the snapshot, the pages only it reads are freed by the next commit.
+func (s *DiskSnapshot) Release() {
+	if s.released {
+		return
+	}
+	s.released = true
+
+	s.tree.mutex.Lock()
+	defer s.tree.mutex.Unlock()
+
+	s.tree.snapshots[s.txID]--
+	if s.tree.snapshots[s.txID] == 0 {
+		delete(s.tree.snapshots, s.txID)
+	}
+}
@@ -0,0 +1,441 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/key_value"
+	"dmds_lab2/shared"
+	"errors"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func newTestDiskBPlusTree(t *testing.T, capacity uint64, bufferPoolSize int, copyOnWrite bool) *DiskBPlusTree {
+	tree := NewDiskBPlusTree(path.Join(t.TempDir(), "tree.bpt"))
+	tree.SetCapacity(capacity)
+	tree.SetCopyOnWrite(copyOnWrite)
+	tree.SetBufferPoolSize(bufferPoolSize)
+	if err := tree.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	return tree
+}
+
+// checkDiskTree checks that the leaves, in order, hold the keys of the model, and that they are linked in both directions
+// unless the tree is in copy-on-write mode.
+func checkDiskTree(t *testing.T, tree *DiskBPlusTree, model map[shared.KeyType]shared.ValueType) {
+	t.Helper()
+
+	keys := make([]shared.KeyType, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	leaves := make([]*diskNode, 0)
+	var walk func(id PageID)
+	walk = func(id PageID) {
+		node, err := tree.readNode(id)
+		if err != nil {
+			t.Fatalf("reading page %d failed: %v", id, err)
+		}
+		if node.isLeaf {
+			leaves = append(leaves, node)
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(tree.root)
+
+	position := 0
+	for i, leaf := range leaves {
+		for j, key := range leaf.keys {
+			if position >= len(keys) || key != keys[position] || leaf.values[j] != model[key] {
+				t.Fatalf("leaf holds %d at position %d", key, position)
+			}
+			position++
+		}
+
+		var prev, next PageID
+		if i > 0 && !tree.copyOnWrite {
+			prev = leaves[i-1].id
+		}
+		if i+1 < len(leaves) && !tree.copyOnWrite {
+			next = leaves[i+1].id
+		}
+		if leaf.prev != prev || leaf.next != next {
+			t.Fatalf("leaf %d is linked to %d and %d, expected %d and %d", leaf.id, leaf.prev, leaf.next, prev, next)
+		}
+	}
+	if position != len(keys) || tree.Count() != uint64(len(keys)) {
+		t.Fatalf("tree holds %d keys (count %d), expected %d", position, tree.Count(), len(keys))
+	}
+}
+
+func TestDiskBPlusTree_RandomOperations(t *testing.T) {
+	for _, test := range []struct {
+		capacity    uint64
+		copyOnWrite bool
+	}{{2, false}, {5, false}, {MaxLeafCapacity, false}, {2, true}, {5, true}, {MaxLeafCapacity, true}} {
+		rng := rand.New(rand.NewSource(int64(test.capacity)))
+		tree := newTestDiskBPlusTree(t, test.capacity, 8, test.copyOnWrite)
+		model := make(map[shared.KeyType]shared.ValueType)
+
+		for i := 0; i < 4000; i++ {
+			key := shared.KeyType(rng.Intn(500))
+			value := shared.ValueType(rng.Uint64())
+			_, exists := model[key]
+
+			insertRatio := 60
+			if i >= 2000 {
+				insertRatio = 30
+			}
+			switch operation := rng.Intn(100); {
+			case operation < insertRatio:
+				if err := tree.Insert(key, value); err != nil {
+					t.Fatalf("Insert(%d) failed: %v", key, err)
+				}
+				model[key] = value
+			case operation < insertRatio+10:
+				err := tree.Update(key, value)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("Update(%d) = %v, key exists: %v", key, err, exists)
+				}
+				if exists {
+					model[key] = value
+				}
+			default:
+				err := tree.DeleteKey(key)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("DeleteKey(%d) = %v, key exists: %v", key, err, exists)
+				}
+				delete(model, key)
+			}
+
+			got, err := tree.Get(key)
+			if expected, ok := model[key]; ok && (err != nil || *got != expected) || !ok && !errors.Is(err, shared.KeyNotFoundError) {
+				t.Fatalf("Get(%d) = %v, %v after operation %d", key, got, err, i)
+			}
+			if pinned := tree.GetBufferPool().GetPinnedCount(); pinned != 0 {
+				t.Fatalf("%d pages left pinned", pinned)
+			}
+
+			if i%100 == 99 {
+				if err := tree.Commit(); err != nil {
+					t.Fatalf("Commit failed: %v", err)
+				}
+			}
+
+			// Reopen the file from time to time, the tree is read back from the pages
+			if i%500 == 499 {
+				checkDiskTree(t, tree, model)
+				if err := tree.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+				if err := tree.Open(); err != nil {
+					t.Fatalf("Open failed: %v", err)
+				}
+				checkDiskTree(t, tree, model)
+			}
+		}
+
+		if err := tree.Delete(); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+}
+
+func TestDiskBPlusTree_FreePages(t *testing.T) {
+	tree := newTestDiskBPlusTree(t, 4, 16, false)
+	for round := 0; round < 3; round++ {
+		for key := shared.KeyType(0); key < 1000; key++ {
+			if err := tree.Insert(key, key); err != nil {
+				t.Fatalf("Insert(%d) failed: %v", key, err)
+			}
+		}
+		pageCount := tree.pager.GetPageCount()
+		for key := shared.KeyType(0); key < 1000; key++ {
+			if err := tree.DeleteKey(key); err != nil {
+				t.Fatalf("DeleteKey(%d) failed: %v", key, err)
+			}
+		}
+		checkDiskTree(t, tree, map[shared.KeyType]shared.ValueType{})
+
+		// The pages freed by the deletes are reused by the next round of inserts
+		if round > 0 && pageCount != tree.pager.GetPageCount() {
+			t.Fatalf("round %d grew the file to %d pages, expected %d", round, tree.pager.GetPageCount(), pageCount)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestDiskBPlusTree_KeyValueStoreDisk(t *testing.T) {
+	var kv key_value.KeyValueStoreDisk = NewDiskBPlusTree(path.Join(t.TempDir(), "tree.bpt"))
+	if err := kv.Open(); err == nil {
+		t.Fatalf("expected Open of a missing file to fail")
+	}
+	if err := kv.Close(); !errors.Is(err, FileNotOpenError) {
+		t.Fatalf("expected Close of a tree not open to fail, got %v", err)
+	}
+	if err := kv.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := kv.Create(); err == nil {
+		t.Fatalf("expected Create of an open tree to fail")
+	}
+	if err := kv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := kv.Create(); err == nil {
+		t.Fatalf("expected Create of an existing file to fail")
+	}
+	if err := kv.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if err := kv.Delete(); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(kv.GetPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+}
+
+func TestDiskBPlusTree_CopyOnWriteCrash(t *testing.T) {
+	tree := newTestDiskBPlusTree(t, 4, 8, true)
+	committed := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 500; key++ {
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		committed[key] = key
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Writes that are not committed, a part of their pages are written by the evictions of the small buffer pool
+	for key := shared.KeyType(0); key < 500; key += 2 {
+		if err := tree.DeleteKey(key); err != nil {
+			t.Fatalf("DeleteKey(%d) failed: %v", key, err)
+		}
+		if err := tree.Insert(key+1000, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key+1000, err)
+		}
+	}
+
+	// Crash: the file is closed without a commit
+	if err := tree.closeFile(); err != nil {
+		t.Fatalf("closing the file failed: %v", err)
+	}
+	if err := tree.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkDiskTree(t, tree, committed)
+
+	// A commit whose meta page is torn is ignored, the previous commit is read instead
+	if err := tree.Update(1, 42); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	file, err := os.OpenFile(tree.GetPath(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("opening the file failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, int64(tree.txID%metaPageCount*PageSize+metaSize-1)); err != nil {
+		t.Fatalf("corrupting the meta page failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("closing the file failed: %v", err)
+	}
+	if err := tree.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkDiskTree(t, tree, committed)
+
+	// The uncommitted writes can also be rolled back
+	if err := tree.Insert(5000, 1); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := tree.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	checkDiskTree(t, tree, committed)
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestDiskBPlusTree_CopyOnWriteFreeList(t *testing.T) {
+	tree := newTestDiskBPlusTree(t, 4, 16, true)
+	model := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 2000; key++ {
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		model[key] = key
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for key := shared.KeyType(0); key < 2000; key += 3 {
+		if err := tree.DeleteKey(key); err != nil {
+			t.Fatalf("DeleteKey(%d) failed: %v", key, err)
+		}
+		delete(model, key)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The free pages are read from the free list of the last commit
+	if err := tree.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkDiskTree(t, tree, model)
+	if len(tree.freeList) == 0 || len(tree.pager.freePages) == 0 {
+		t.Fatalf("expected the free pages to be read from the free list, got %d pages in %d list pages",
+			len(tree.pager.freePages), len(tree.freeList))
+	}
+	expected := make(map[PageID]bool)
+	for _, id := range append(append([]PageID{}, tree.pager.freePages...), tree.freeList...) {
+		expected[id] = true
+	}
+	freeListPage := tree.freeList[0]
+	if err := tree.closeFile(); err != nil {
+		t.Fatalf("closing the file failed: %v", err)
+	}
+
+	// A corrupted free list is rebuilt from the pages the tree does not use, the pages of the list included
+	file, err := os.OpenFile(tree.GetPath(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("opening the file failed: %v", err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, int64(freeListPage*PageSize+PageSize-1)); err != nil {
+		t.Fatalf("corrupting the free list failed: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("closing the file failed: %v", err)
+	}
+	if err := tree.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkDiskTree(t, tree, model)
+	collected := make(map[PageID]bool)
+	for _, id := range tree.pager.freePages {
+		collected[id] = true
+	}
+	if len(collected) != len(expected) || len(tree.freeList) != 0 {
+		t.Fatalf("collected %d free pages, expected %d", len(collected), len(expected))
+	}
+	for id := range expected {
+		if !collected[id] {
+			t.Fatalf("free page %d has not been collected", id)
+		}
+	}
+
+	// The free pages are reused before the file is extended
+	pageCount := tree.pager.GetPageCount()
+	for key := shared.KeyType(0); key < 2000; key += 3 {
+		if err := tree.Insert(key, key); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+		model[key] = key
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := tree.Open(); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	checkDiskTree(t, tree, model)
+	if tree.pager.GetPageCount() > pageCount+uint64(len(tree.freeList)) {
+		t.Fatalf("the file grew from %d to %d pages", pageCount, tree.pager.GetPageCount())
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestDiskBPlusTree_Snapshots(t *testing.T) {
+	tree := newTestDiskBPlusTree(t, 4, 32, true)
+	for key := shared.KeyType(0); key < 300; key++ {
+		if err := tree.Insert(key, 0); err != nil {
+			t.Fatalf("Insert(%d) failed: %v", key, err)
+		}
+	}
+	if err := tree.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	pageCount := tree.pager.GetPageCount()
+
+	// A snapshot per version, read concurrently with the writes of the next versions
+	snapshots := make([]*DiskSnapshot, 0)
+	done := make(chan error)
+	for version := shared.ValueType(0); version < 5; version++ {
+		snapshot, err := tree.Snapshot()
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		snapshots = append(snapshots, snapshot)
+
+		go func() {
+			for key := shared.KeyType(0); key < 300; key++ {
+				value, err := snapshot.Get(key)
+				if err != nil || *value != version {
+					done <- errors.Join(err, errors.New("snapshot read a later version"))
+					return
+				}
+			}
+			done <- nil
+		}()
+
+		for key := shared.KeyType(0); key < 300; key++ {
+			if err := tree.Update(key, version+1); err != nil {
+				t.Fatalf("Update(%d) failed: %v", key, err)
+			}
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+	}
+	for range snapshots {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The pages of the snapshots are kept until they are released
+	for i, snapshot := range snapshots {
+		if value, err := snapshot.Get(7); err != nil || *value != shared.ValueType(i) {
+			t.Fatalf("snapshot %d holds %v, %v", i, value, err)
+		}
+		snapshot.Release()
+	}
+	if _, err := snapshots[0].Get(7); err == nil {
+		t.Fatalf("expected a released snapshot not to be readable")
+	}
+
+	// Once released, their pages are reused by the next transactions
+	for version := shared.ValueType(5); version < 10; version++ {
+		for key := shared.KeyType(0); key < 300; key++ {
+			if err := tree.Update(key, version+1); err != nil {
+				t.Fatalf("Update(%d) failed: %v", key, err)
+			}
+		}
+		if err := tree.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+	}
+	if grown := tree.pager.GetPageCount(); grown > 7*pageCount {
+		t.Fatalf("the file grew from %d to %d pages", pageCount, grown)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+)
+
+// The pages of the nodes start with their type and number of keys:
+//   - leaf:     type | count | next | prev | count * (key | value)
+//   - interior: type | count | count * key | (count + 1) * child
+//
+// The type takes 8 bytes to keep the other fields aligned, all the fields are shared.Endianess uint64.
+const (
+	pageTypeLeaf     = 1
+	pageTypeInterior = 2
+
+	leafHeaderSize     = 32
+	interiorHeaderSize = 16
+
+	// MaxLeafCapacity is the greatest number of keys of a leaf page.
+	MaxLeafCapacity = (PageSize - leafHeaderSize) / (shared.KeySize + shared.ValueSize)
+	// MaxInteriorCapacity is the greatest number of keys of an interior page, which holds one more child than keys.
+	MaxInteriorCapacity = (PageSize - interiorHeaderSize - 8) / (shared.KeySize + 8)
+)
+
+// diskNode is a node of a DiskBPlusTree decoded from its page.
+type diskNode struct {
+	id       PageID
+	isLeaf   bool
+	keys     []shared.KeyType
+	values   []shared.ValueType // Leaf only
+	next     PageID             // Leaf only, 0 for the last leaf
+	prev     PageID             // Leaf only, 0 for the first leaf
+	children []PageID           // Interior only
+}
+
+// decodeDiskNode decodes the node stored in the page.
+func decodeDiskNode(id PageID, data []byte) (*diskNode, error) {
+	node := &diskNode{id: id}
+	count := shared.Endianess.Uint64(data[8:16])
+
+	switch data[0] {
+	case pageTypeLeaf:
+		if count > MaxLeafCapacity {
+			return nil, errors.New("invalid leaf page")
+		}
+		node.isLeaf = true
+		node.next = shared.Endianess.Uint64(data[16:24])
+		node.prev = shared.Endianess.Uint64(data[24:32])
+		node.keys = make([]shared.KeyType, count)
+		node.values = make([]shared.ValueType, count)
+		for i := uint64(0); i < count; i++ {
+			offset := leafHeaderSize + i*16
+			node.keys[i] = shared.Endianess.Uint64(data[offset : offset+8])
+			node.values[i] = shared.Endianess.Uint64(data[offset+8 : offset+16])
+		}
+	case pageTypeInterior:
+		if count > MaxInteriorCapacity {
+			return nil, errors.New("invalid interior page")
+		}
+		node.keys = make([]shared.KeyType, count)
+		node.children = make([]PageID, count+1)
+		for i := uint64(0); i < count; i++ {
+			offset := interiorHeaderSize + i*8
+			node.keys[i] = shared.Endianess.Uint64(data[offset : offset+8])
+		}
+		for i := uint64(0); i <= count; i++ {
+			offset := interiorHeaderSize + (count+i)*8
+			node.children[i] = shared.Endianess.Uint64(data[offset : offset+8])
+		}
+	default:
+		return nil, errors.New("invalid node page type")
+	}
+	return node, nil
+}
+
+// encode encodes the node into its page.
+func (n *diskNode) encode(data []byte) {
+	clear(data)
+	count := uint64(len(n.keys))
+	shared.Endianess.PutUint64(data[8:16], count)
+
+	if n.isLeaf {
+		data[0] = pageTypeLeaf
+		shared.Endianess.PutUint64(data[16:24], n.next)
+		shared.Endianess.PutUint64(data[24:32], n.prev)
+		for i := uint64(0); i < count; i++ {
+			offset := leafHeaderSize + i*16
+			shared.Endianess.PutUint64(data[offset:offset+8], n.keys[i])
+			shared.Endianess.PutUint64(data[offset+8:offset+16], n.values[i])
+		}
+		return
+	}
+
+	data[0] = pageTypeInterior
+	for i := uint64(0); i < count; i++ {
+		offset := interiorHeaderSize + i*8
+		shared.Endianess.PutUint64(data[offset:offset+8], n.keys[i])
+	}
+	for i := uint64(0); i <= count; i++ {
+		offset := interiorHeaderSize + (count+i)*8
+		shared.Endianess.PutUint64(data[offset:offset+8], n.children[i])
+	}
+}
+
+// search returns the index of the first key greater than or equal to the key (leaf), or of the child the key is routed to
+// (interior), and whether the leaf holds the key.
+func (n *diskNode) search(key shared.KeyType) (int, bool) {
+	low, high := 0, len(n.keys)
+	for low < high {
+		mid := (low + high) / 2
+		if n.keys[mid] < key || !n.isLeaf && n.keys[mid] == key {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, n.isLeaf && low < len(n.keys) && n.keys[low] == key
+}
+
+// leaf returns the slots of the leaf, nil for a nil node, see setLeaf.
+func (n *diskNode) leaf() *leafSlots[shared.ValueType] {
+	if n == nil {
+		return nil
+	}
+	return &leafSlots[shared.ValueType]{keys: n.keys, values: n.values}
+}
+
+// setLeaf sets the keys and values of the leaf from its slots.
+func (n *diskNode) setLeaf(slots *leafSlots[shared.ValueType]) {
+	if n != nil {
+		n.keys, n.values = slots.keys, slots.values
+	}
+}
+
+// interior returns the slots of the interior node, nil for a nil node, see setInterior. The counts of the subtrees are not kept.
+func (n *diskNode) interior() *interiorSlots[PageID] {
+	if n == nil {
+		return nil
+	}
+	return &interiorSlots[PageID]{keys: n.keys, children: n.children}
+}
+
+// setInterior sets the keys and children of the interior node from its slots.
+func (n *diskNode) setInterior(slots *interiorSlots[PageID]) {
+	if n != nil {
+		n.keys, n.children = slots.keys, slots.children
+	}
+}
@@ -4,109 +4,207 @@ import (
 	"dmds_lab2/shared"
 	"errors"
 )
-import "sort"
+import (
+	"sort"
+	"sync"
+)
 
 type InteriorNode struct {
-	keys   []shared.KeyType
-	next   []Node
-	count  int
-	isRoot bool
+	keys     []shared.KeyType
+	next     []Node
+	counts   []uint64 // Number of keys in the subtree of every child, for Rank and Select
+	count    int
+	capacity int
+	latch    sync.RWMutex // Protects the node, the counts may also be changed atomically under a read latch, see BPlusTree
 }
 
 func NewInteriorNode(capacity uint64) *InteriorNode {
 	return &InteriorNode{
-		keys: make([]shared.KeyType, capacity),
-		next: make([]Node, capacity+1),
+		keys:     make([]shared.KeyType, capacity+1),
+		next:     make([]Node, capacity+2),
+		counts:   make([]uint64, capacity+2),
+		capacity: int(capacity),
 	}
 }
 
 func (in *InteriorNode) IsFull() bool {
-	return in.count >= cap(in.keys)
+	return in.count >= in.capacity
+}
+
+func (in *InteriorNode) getLatch() *sync.RWMutex {
+	return &in.latch
+}
+
+// Count returns the number of keys in the subtree of the node.
+func (in *InteriorNode) Count() uint64 {
+	count := uint64(0)
+	for i := 0; i <= in.count; i++ {
+		count += in.counts[i]
+	}
+	return count
+}
+
+// refreshCount recomputes the number of keys in the subtree of the child at the index.
+func (in *InteriorNode) refreshCount(index int) {
+	if index >= 0 && index <= in.count {
+		in.counts[index] = in.next[index].Count()
+	}
 }
 
 func (in *InteriorNode) GetNodeAtIndex(index uint64) Node {
-	if index > uint64(len(in.next)) {
+	if index >= uint64(len(in.next)) {
 		return nil
 	}
 	return in.next[index]
 }
 
+// Scan returns the index of the child the key is routed to: the first one whose separator on the right is greater than the key.
+// Only the first count keys are occupied, so every key, 0 included, can be routed.
 func (in *InteriorNode) Scan(key shared.KeyType) (uint64, error) {
-	idx := sort.Search(len(in.keys), func(i int) bool {
-		if in.keys[i] == 0 {
-			return true
-		}
+	idx := sort.Search(in.count, func(i int) bool {
 		return in.keys[i] > key
 	})
 
-	if idx == -1 {
-		return uint64(cap(in.keys)), nil
-	}
-
 	return uint64(idx), nil
 }
 
 func (in *InteriorNode) MakeSpaceAtIndex(index uint64) error {
-	if index < 0 || index > uint64(len(in.keys)) {
+	if index > uint64(in.count) {
 		return errors.New("index out of range")
 	}
-	if in.count+1 > cap(in.keys) {
+	if in.count+1 > len(in.keys) {
 		return errors.New("out of space")
 	}
 	in.keys = append(in.keys[:index+1], in.keys[index:len(in.keys)-1]...)
-	in.next = append(in.next[:index+1], in.next[index:len(in.next)-1]...)
+	in.next = append(in.next[:index+2], in.next[index+1:len(in.next)-1]...)
+	in.counts = append(in.counts[:index+2], in.counts[index+1:len(in.counts)-1]...)
 	return nil
 }
 
-func (in *InteriorNode) Insert(key shared.KeyType, node1 Node, node2 Node) (*InteriorNode, *InteriorNode, shared.KeyType, error) {
+// Insert inserts the key separating the child it is routed to from the node on its right, split from that child.
+// When the node overflows it is split: the new node on its right and the key moved up to the parent are returned.
+func (in *InteriorNode) Insert(key shared.KeyType, right Node) (*InteriorNode, shared.KeyType, error) {
 	idx, _ := in.Scan(key)
+	if in.count+1 > len(in.keys) {
+		return nil, 0, errors.New("out of space")
+	}
+
+	slots := in.slots()
+	slots.insert(int(idx), key, right, right.Count())
+	in.setSlots(slots)
+	in.refreshCount(int(idx))
 
-	if err := in.MakeSpaceAtIndex(idx); err != nil {
-		return nil, nil, 0, nil
+	if in.count > in.capacity {
+		_, s2, midKey := in.Split()
+		return s2, midKey, nil
 	}
 
-	in.keys[idx] = key
-	in.next[idx] = node1
-	in.next[idx+1] = node2
-	in.count++
+	return nil, 0, nil
+}
 
-	if in.IsFull() {
-		s1, s2, midKey := in.Split()
+// Split moves the keys and children after the middle key of an overflowing node to a new node.
+// It returns both nodes and the middle key, which is removed from the node to be moved up to the parent.
+func (in *InteriorNode) Split() (*InteriorNode, *InteriorNode, shared.KeyType) {
+	newInteriorNode := NewInteriorNode(uint64(in.capacity))
+	slots, newSlots := in.slots(), newInteriorNode.slots()
+	midKey := slots.split(newSlots)
+	in.setSlots(slots)
+	newInteriorNode.setSlots(newSlots)
 
-		if in.isRoot {
-			in.isRoot = false
-			newRoot := NewInteriorNode(uint64(cap(in.keys)))
-			newRoot.isRoot = true
-			newRoot.count = 1
-			newRoot.keys[0] = midKey
-			newRoot.next[0] = s1
-			newRoot.next[1] = s2
+	return in, newInteriorNode, midKey
+}
 
-			return newRoot, nil, midKey, nil
-		}
+// RemoveAtIndex removes the key at the index and the child on its right.
+func (in *InteriorNode) RemoveAtIndex(index int) {
+	slots := in.slots()
+	slots.remove(index)
+	in.setSlots(slots)
+}
 
-		return s1, s2, midKey, nil
-	}
+// minCount returns the number of keys under which an interior node other than the root has to be rebalanced.
+func (in *InteriorNode) minCount() int {
+	return minInteriorCount(uint64(in.capacity))
+}
 
-	return in, nil, 0, nil
+// slots returns the occupied slots of the node, nil for a nil node. They stay in the arrays of the node, which hold one more
+// key than its capacity, until they are set back with setSlots.
+func (in *InteriorNode) slots() *interiorSlots[Node] {
+	if in == nil {
+		return nil
+	}
+	return &interiorSlots[Node]{keys: in.keys[:in.count], children: in.next[:in.count+1], counts: in.counts[:in.count+1]}
 }
 
-func (in *InteriorNode) Split() (*InteriorNode, *InteriorNode, shared.KeyType) {
-	midIdx := cap(in.keys) / 2
-	midKey := in.keys[midIdx]
+// setSlots sets the number of keys of the node from its slots, modified in place.
+func (in *InteriorNode) setSlots(slots *interiorSlots[Node]) {
+	if in != nil {
+		in.count = len(slots.keys)
+	}
+}
 
-	newInteriorNode := NewInteriorNode(uint64(cap(in.keys)))
-	copy(newInteriorNode.keys, in.keys[midIdx+1:])
-	copy(newInteriorNode.next, in.next[midIdx+1:])
-	newInteriorNode.count = len(in.keys) - midIdx - 1
+// Rebalance fixes the underflow of the child at the index, by borrowing a key from a sibling that has more than the minimum,
+// or else by merging it with a sibling, which removes their separator key from the node (see rebalanceSlots).
+// Only the counts of the children that changed are refreshed, the other ones may be in use by concurrent operations.
+func (in *InteriorNode) Rebalance(index int) {
+	slots := in.slots()
+	var action rebalanceAction
+	switch child := in.next[index].(type) {
+	case *LeafNode:
+		left, right := in.siblingLeaves(index)
+		childSlots, leftSlots, rightSlots := child.slots(), left.slots(), right.slots()
+		action = rebalanceSlots(slots, index, childSlots, leftSlots, rightSlots, minLeafCount(child.capacity))
+		child.setSlots(childSlots)
+		left.setSlots(leftSlots)
+		right.setSlots(rightSlots)
+		if action == mergedIntoLeft {
+			left.unlinkNext()
+		} else if action == mergedWithRight {
+			child.unlinkNext()
+		}
+	case *InteriorNode:
+		left, right := in.siblingInteriors(index)
+		childSlots, leftSlots, rightSlots := child.slots(), left.slots(), right.slots()
+		action = rebalanceSlots(slots, index, childSlots, leftSlots, rightSlots, child.minCount())
+		child.setSlots(childSlots)
+		left.setSlots(leftSlots)
+		right.setSlots(rightSlots)
+	}
+	in.setSlots(slots)
+
+	switch action {
+	case borrowedFromLeft:
+		in.refreshCount(index - 1)
+		in.refreshCount(index)
+	case borrowedFromRight:
+		in.refreshCount(index)
+		in.refreshCount(index + 1)
+	case mergedIntoLeft:
+		in.refreshCount(index - 1)
+	case mergedWithRight:
+		in.refreshCount(index)
+	}
+}
 
-	in.keys[midIdx] = 0
-	for i := midIdx + 1; i < cap(in.keys); i++ {
-		in.keys[i] = 0
-		in.next[i] = nil
+// siblingLeaves returns the leaves before and after the child at the index, nil if there is none.
+func (in *InteriorNode) siblingLeaves(index int) (*LeafNode, *LeafNode) {
+	var left, right *LeafNode
+	if index > 0 {
+		left = in.next[index-1].(*LeafNode)
+	}
+	if index < in.count {
+		right = in.next[index+1].(*LeafNode)
 	}
-	in.next[cap(in.next)-1] = nil
-	in.count = midIdx
+	return left, right
+}
 
-	return in, newInteriorNode, midKey
+// siblingInteriors returns the interior nodes before and after the child at the index, nil if there is none.
+func (in *InteriorNode) siblingInteriors(index int) (*InteriorNode, *InteriorNode) {
+	var left, right *InteriorNode
+	if index > 0 {
+		left = in.next[index-1].(*InteriorNode)
+	}
+	if index < in.count {
+		right = in.next[index+1].(*InteriorNode)
+	}
+	return left, right
 }
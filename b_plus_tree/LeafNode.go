@@ -3,14 +3,17 @@ package b_plus_tree
 import (
 	"dmds_lab2/shared"
 	"sort"
+	"sync"
 )
 
 type LeafNode struct {
 	keys     []shared.KeyType
 	values   []*shared.ValueType
 	next     *LeafNode
+	prev     *LeafNode
 	count    uint64
 	capacity uint64
+	latch    sync.RWMutex // Protects the keys and values, next and count, see BPlusTree
 }
 
 func NewLeafNode(capacity uint64) *LeafNode {
@@ -25,14 +28,27 @@ func (ln *LeafNode) IsFull() bool {
 	return ln.count >= ln.capacity
 }
 
+func (ln *LeafNode) getLatch() *sync.RWMutex {
+	return &ln.latch
+}
+
 func (ln *LeafNode) GetKeys() []shared.KeyType {
-	return ln.keys[:len(ln.keys)-1]
+	return ln.keys[:ln.count]
 }
 
 func (ln *LeafNode) GetNext() *LeafNode {
 	return ln.next
 }
 
+func (ln *LeafNode) GetPrev() *LeafNode {
+	return ln.prev
+}
+
+// Count returns the number of keys in the leaf.
+func (ln *LeafNode) Count() uint64 {
+	return ln.count
+}
+
 func (ln *LeafNode) GetValueAtIndex(index uint64) *shared.ValueType {
 	if index >= uint64(len(ln.values)) {
 		return nil
@@ -40,37 +56,34 @@ func (ln *LeafNode) GetValueAtIndex(index uint64) *shared.ValueType {
 	return ln.values[index]
 }
 
+// Scan returns the index of the key in the leaf, or KeyNotFoundError with the index where the key would be inserted if the leaf
+// does not hold it. Only the first count slots are occupied, so every key, 0 included, can be stored.
 func (ln *LeafNode) Scan(key shared.KeyType) (uint64, error) {
-	idx := sort.Search(len(ln.keys), func(i int) bool {
-		if ln.keys[i] == 0 {
-			return true
-		}
+	idx := uint64(sort.Search(int(ln.count), func(i int) bool {
 		return ln.keys[i] >= key
-	})
+	}))
 
-	if idx == -1 {
-		return 0, shared.KeyNotFoundError
+	if idx == ln.count || ln.keys[idx] != key {
+		return idx, shared.KeyNotFoundError
 	}
 
-	return uint64(idx), nil
+	return idx, nil
 }
 
+// Split moves the upper half of the keys and values of an overflowing leaf to a new leaf, linked after it.
+// It returns both leaves and the first key of the new one.
 func (ln *LeafNode) Split() (*LeafNode, *LeafNode, shared.KeyType) {
-	midIdx := ln.capacity / 2
-	midKey := ln.keys[midIdx]
-
 	newLeafNode := NewLeafNode(ln.capacity)
-	copy(newLeafNode.keys, ln.keys[midIdx+1:])
-	copy(newLeafNode.values, ln.values[midIdx+1:])
-	newLeafNode.count = ln.count - midIdx
-
-	for i := midIdx + 1; i < ln.capacity+1; i++ {
-		ln.keys[i] = 0
-		ln.values[i] = nil
-	}
-	ln.count = midIdx + 1
+	slots, newSlots := ln.slots(), newLeafNode.slots()
+	midKey := slots.split(newSlots)
+	ln.setSlots(slots)
+	newLeafNode.setSlots(newSlots)
 
 	newLeafNode.next = ln.next
+	newLeafNode.prev = ln
+	if ln.next != nil {
+		ln.next.prev = newLeafNode
+	}
 	ln.next = newLeafNode
 
 	return ln, newLeafNode, midKey
@@ -81,18 +94,59 @@ func (ln *LeafNode) MakeSpaceAtIndex(index uint64) {
 	ln.values = append(ln.values[:index+1], ln.values[index:len(ln.values)-1]...)
 }
 
-func (ln *LeafNode) Insert(key shared.KeyType) (Node, Node, shared.KeyType, error) {
-	idx, _ := ln.Scan(key)
-	ln.MakeSpaceAtIndex(idx)
+// Insert inserts the key-value pair in the leaf, or replaces the value if the key is already there (the second return value is
+// then false). When the leaf overflows it is split: the new leaf and its first key are returned.
+func (ln *LeafNode) Insert(key shared.KeyType, value shared.ValueType) (*LeafNode, bool, shared.KeyType) {
+	idx, err := ln.Scan(key)
+	if err == nil {
+		ln.values[idx] = &value
+		return nil, false, 0
+	}
+
+	slots := ln.slots()
+	slots.insert(int(idx), key, &value)
+	ln.setSlots(slots)
 
-	if ln.IsFull() {
-		ln.keys[idx] = key
-		l1, l2, _ := ln.Split()
-		return l1, l2, l2.keys[0], nil
+	if ln.count > ln.capacity {
+		_, l2, midKey := ln.Split()
+		return l2, true, midKey
 	}
 
-	ln.keys[idx] = key
-	ln.count++
+	return nil, true, 0
+}
 
-	return ln, nil, shared.KeyType(0), nil
+// RemoveAtIndex removes the key and the value at the index.
+func (ln *LeafNode) RemoveAtIndex(index uint64) {
+	slots := ln.slots()
+	slots.remove(int(index))
+	ln.setSlots(slots)
+}
+
+// minCount returns the number of keys under which a leaf other than the root has to be rebalanced.
+func (ln *LeafNode) minCount() uint64 {
+	return uint64(minLeafCount(ln.capacity))
+}
+
+// slots returns the occupied slots of the leaf, nil for a nil leaf. They stay in the arrays of the leaf, which hold one more
+// key than its capacity, until they are set back with setSlots.
+func (ln *LeafNode) slots() *leafSlots[*shared.ValueType] {
+	if ln == nil {
+		return nil
+	}
+	return &leafSlots[*shared.ValueType]{keys: ln.keys[:ln.count], values: ln.values[:ln.count]}
+}
+
+// setSlots sets the number of keys of the leaf from its slots, modified in place.
+func (ln *LeafNode) setSlots(slots *leafSlots[*shared.ValueType]) {
+	if ln != nil {
+		ln.count = uint64(len(slots.keys))
+	}
+}
+
+// unlinkNext removes the next leaf, merged into this one, from the chain of leaves.
+func (ln *LeafNode) unlinkNext() {
+	ln.next = ln.next.next
+	if ln.next != nil {
+		ln.next.prev = ln
+	}
 }
@@ -2,9 +2,12 @@ package b_plus_tree
 
 import (
 	"dmds_lab2/shared"
+	"sync"
 )
 
 type Node interface {
 	Scan(key shared.KeyType) (uint64, error)
 	IsFull() bool
+	Count() uint64 // Number of keys in the subtree of the node
+	getLatch() *sync.RWMutex
 }
@@ -0,0 +1,196 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"hash/crc32"
+	"os"
+)
+
+// PageSize is the size of the pages of a DiskBPlusTree file.
+const PageSize = 4096
+
+// PageID is the position of a page in the file. The pages 0 and 1 are the meta pages of the file, so 0 is also used as a null page.
+type PageID = uint64
+
+// metaPageCount is the number of meta pages at the start of the file, they are never allocated nor freed.
+const metaPageCount = 2
+
+// pageTypeFree is the type of the pages of the free-page list, see DiskNode.go for the types of the node pages.
+const pageTypeFree = 3
+
+// pageTypeFreeList is the type of the pages of the free list written by the commits in copy-on-write mode (see writeFreeList).
+const pageTypeFreeList = 4
+
+// The pages of a written free list store: type | transaction | next page | number of free pages | checksum of the page
+// (CRC-32, computed with a checksum of 0) | free pages.
+const (
+	freeListHeaderSize   = 5 * 8
+	freeListPageCapacity = (PageSize - freeListHeaderSize) / 8
+)
+
+// Pager reads and writes the pages of a file, and allocates them: the freed pages are linked in a list (each one stores the
+// next free page) and reused before the file is extended.
+type Pager struct {
+	file         *os.File
+	pageCount    uint64 // Number of pages of the file, including the meta pages
+	freeListHead PageID // First free page, 0 if there is none
+	// The free pages are kept in memory rather than linked in the file, so that freeing a page does not write it
+	inMemory  bool
+	freePages []PageID
+}
+
+// ReadPage reads the page into data, which is PageSize bytes long.
+func (p *Pager) ReadPage(id PageID, data []byte) error {
+	if id >= p.pageCount {
+		return errors.New("page out of range")
+	}
+	_, err := p.file.ReadAt(data[:PageSize], int64(id*PageSize))
+	return err
+}
+
+// WritePage writes the PageSize bytes of data to the page.
+func (p *Pager) WritePage(id PageID, data []byte) error {
+	if id >= p.pageCount {
+		return errors.New("page out of range")
+	}
+	_, err := p.file.WriteAt(data[:PageSize], int64(id*PageSize))
+	return err
+}
+
+// Allocate returns a page to write, the head of the free-page list or else a new page at the end of the file.
+func (p *Pager) Allocate() (PageID, error) {
+	if p.inMemory && len(p.freePages) > 0 {
+		id := p.freePages[len(p.freePages)-1]
+		p.freePages = p.freePages[:len(p.freePages)-1]
+		return id, nil
+	}
+	if p.freeListHead == 0 {
+		p.pageCount++
+		return p.pageCount - 1, nil
+	}
+
+	id := p.freeListHead
+	data := make([]byte, PageSize)
+	if err := p.ReadPage(id, data); err != nil {
+		return 0, err
+	}
+	if data[0] != pageTypeFree {
+		return 0, errors.New("corrupted free-page list")
+	}
+	p.freeListHead = shared.Endianess.Uint64(data[8:16])
+	return id, nil
+}
+
+// Free pushes the page to the free-page list.
+func (p *Pager) Free(id PageID) error {
+	if id < metaPageCount {
+		return errors.New("the meta pages cannot be freed")
+	}
+	if p.inMemory {
+		p.freePages = append(p.freePages, id)
+		return nil
+	}
+
+	data := make([]byte, PageSize)
+	data[0] = pageTypeFree
+	shared.Endianess.PutUint64(data[8:16], p.freeListHead)
+	if err := p.WritePage(id, data); err != nil {
+		return err
+	}
+	p.freeListHead = id
+	return nil
+}
+
+// KeepFreeListInMemory keeps the pages freed from now on in memory: they are not written, but they are lost when the file
+// is closed. The pages already linked in the file are still allocated.
+func (p *Pager) KeepFreeListInMemory() {
+	p.inMemory = true
+}
+
+// writeFreeList writes the free pages kept in memory, along with the pending ones, in pages allocated for them and tagged
+// with the transaction, and returns these pages. The pages are linked from the first one, which is never 0.
+func (p *Pager) writeFreeList(txID uint64, pending []PageID) ([]PageID, error) {
+	// The pages of the list are taken from the free pages, so the list may end up with fewer pages than needed at first
+	pageCount := max((len(p.freePages)+len(pending)+freeListPageCapacity-1)/freeListPageCapacity, 1)
+	pages := make([]PageID, pageCount)
+	for i := range pages {
+		id, err := p.Allocate()
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = id
+	}
+
+	free := append(append(make([]PageID, 0, len(p.freePages)+len(pending)), p.freePages...), pending...)
+	data := make([]byte, PageSize)
+	for i, id := range pages {
+		clear(data)
+		count := min(len(free), freeListPageCapacity)
+		next := PageID(0)
+		if i+1 < len(pages) {
+			next = pages[i+1]
+		}
+		data[0] = pageTypeFreeList
+		shared.Endianess.PutUint64(data[8:16], txID)
+		shared.Endianess.PutUint64(data[16:24], next)
+		shared.Endianess.PutUint64(data[24:32], uint64(count))
+		for j, freeID := range free[:count] {
+			shared.Endianess.PutUint64(data[freeListHeaderSize+j*8:], freeID)
+		}
+		shared.Endianess.PutUint64(data[32:40], uint64(crc32.ChecksumIEEE(data)))
+		if err := p.WritePage(id, data); err != nil {
+			return nil, err
+		}
+		free = free[count:]
+	}
+	return pages, nil
+}
+
+// readFreeList reads the free list written by the transaction from its first page, and returns its pages and the free pages.
+// It returns an error if the list is not the one of the transaction or is corrupted.
+func (p *Pager) readFreeList(txID uint64, head PageID) ([]PageID, []PageID, error) {
+	pages, free := make([]PageID, 0, 1), make([]PageID, 0)
+	data := make([]byte, PageSize)
+	for id := head; id != 0; id = shared.Endianess.Uint64(data[16:24]) {
+		if id < metaPageCount || uint64(len(pages)) >= p.pageCount {
+			return nil, nil, errors.New("invalid free list")
+		}
+		if err := p.ReadPage(id, data); err != nil {
+			return nil, nil, err
+		}
+
+		checksum := shared.Endianess.Uint64(data[32:40])
+		shared.Endianess.PutUint64(data[32:40], 0)
+		count := shared.Endianess.Uint64(data[24:32])
+		if data[0] != pageTypeFreeList || shared.Endianess.Uint64(data[8:16]) != txID || count > freeListPageCapacity ||
+			checksum != uint64(crc32.ChecksumIEEE(data)) {
+			return nil, nil, errors.New("free list not written by the transaction")
+		}
+		for i := uint64(0); i < count; i++ {
+			freeID := shared.Endianess.Uint64(data[freeListHeaderSize+i*8:])
+			if freeID < metaPageCount || freeID >= p.pageCount {
+				return nil, nil, errors.New("invalid free page")
+			}
+			free = append(free, freeID)
+		}
+		pages = append(pages, id)
+	}
+	if len(pages) == 0 {
+		return nil, nil, errors.New("no free list")
+	}
+	return pages, free, nil
+}
+
+// GetPageCount returns the number of pages of the file, including the meta pages and the free pages.
+func (p *Pager) GetPageCount() uint64 {
+	return p.pageCount
+}
+
+func NewPager(file *os.File, pageCount uint64, freeListHead PageID) *Pager {
+	return &Pager{
+		file:         file,
+		pageCount:    pageCount,
+		freeListHead: freeListHead,
+	}
+}
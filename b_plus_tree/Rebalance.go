@@ -0,0 +1,207 @@
+package b_plus_tree
+
+import (
+	"dmds_lab2/shared"
+	"slices"
+)
+
+// The splits, borrows and merges of the nodes are shared by BPlusTree and DiskBPlusTree: they move the occupied slots of the
+// nodes, the keys along with their values (leaf) or their children (interior). The slots are modified in place as long as
+// their capacity allows it, which is how the nodes of a BPlusTree keep their fixed-size arrays, and the freed slots are cleared.
+
+// minLeafCount returns the number of keys under which a leaf of the capacity, other than the root, has to be rebalanced.
+func minLeafCount(capacity uint64) int {
+	return int((capacity + 1) / 2)
+}
+
+// minInteriorCount returns the number of keys under which an interior node of the capacity, other than the root, has to be
+// rebalanced.
+func minInteriorCount(capacity uint64) int {
+	return int(capacity / 2)
+}
+
+// leafSlots are the occupied slots of a leaf: its keys and their values.
+type leafSlots[V any] struct {
+	keys   []shared.KeyType
+	values []V
+}
+
+// interiorSlots are the occupied slots of an interior node: its keys and its children, one more than the keys, along with the
+// number of keys in the subtree of every child (nil if the tree does not keep them).
+type interiorSlots[C any] struct {
+	keys     []shared.KeyType
+	children []C
+	counts   []uint64
+}
+
+// nodeSlots are the slots of a leaf or of an interior node. The separator is the key of the parent between the node and its
+// sibling: a leaf ignores it, an interior node moves it down.
+type nodeSlots[S any] interface {
+	keyCount() int
+	// borrowFromLeft moves the last key of the left sibling to the node and returns the new separator.
+	borrowFromLeft(left S, separator shared.KeyType) shared.KeyType
+	// borrowFromRight moves the first key of the right sibling to the node and returns the new separator.
+	borrowFromRight(right S, separator shared.KeyType) shared.KeyType
+	// merge moves the keys of the right sibling to the end of the node.
+	merge(right S, separator shared.KeyType)
+}
+
+func (s *leafSlots[V]) keyCount() int {
+	return len(s.keys)
+}
+
+// insert inserts the key and its value at the index.
+func (s *leafSlots[V]) insert(index int, key shared.KeyType, value V) {
+	s.keys = slices.Insert(s.keys, index, key)
+	s.values = slices.Insert(s.values, index, value)
+}
+
+// remove removes the key and its value at the index.
+func (s *leafSlots[V]) remove(index int) {
+	s.keys = slices.Delete(s.keys, index, index+1)
+	s.values = slices.Delete(s.values, index, index+1)
+}
+
+// split moves the upper half of the slots to the empty right slots, and returns the first key of the right slots.
+func (s *leafSlots[V]) split(right *leafSlots[V]) shared.KeyType {
+	mid := len(s.keys) / 2
+	right.keys = append(right.keys[:0], s.keys[mid:]...)
+	right.values = append(right.values[:0], s.values[mid:]...)
+	s.keys = slices.Delete(s.keys, mid, len(s.keys))
+	s.values = slices.Delete(s.values, mid, len(s.values))
+	return right.keys[0]
+}
+
+func (s *leafSlots[V]) borrowFromLeft(left *leafSlots[V], _ shared.KeyType) shared.KeyType {
+	last := len(left.keys) - 1
+	s.insert(0, left.keys[last], left.values[last])
+	left.remove(last)
+	return s.keys[0]
+}
+
+func (s *leafSlots[V]) borrowFromRight(right *leafSlots[V], _ shared.KeyType) shared.KeyType {
+	s.keys = append(s.keys, right.keys[0])
+	s.values = append(s.values, right.values[0])
+	right.remove(0)
+	return right.keys[0]
+}
+
+func (s *leafSlots[V]) merge(right *leafSlots[V], _ shared.KeyType) {
+	s.keys = append(s.keys, right.keys...)
+	s.values = append(s.values, right.values...)
+}
+
+func (s *interiorSlots[C]) keyCount() int {
+	return len(s.keys)
+}
+
+// insert inserts the key at the index and the child on its right, with the number of keys in its subtree.
+func (s *interiorSlots[C]) insert(index int, key shared.KeyType, right C, count uint64) {
+	s.keys = slices.Insert(s.keys, index, key)
+	s.children = slices.Insert(s.children, index+1, right)
+	if s.counts != nil {
+		s.counts = slices.Insert(s.counts, index+1, count)
+	}
+}
+
+// remove removes the key at the index and the child on its right.
+func (s *interiorSlots[C]) remove(index int) {
+	s.keys = slices.Delete(s.keys, index, index+1)
+	s.children = slices.Delete(s.children, index+1, index+2)
+	if s.counts != nil {
+		s.counts = slices.Delete(s.counts, index+1, index+2)
+	}
+}
+
+// split moves the keys and children after the middle key to the empty right slots, and returns the middle key, which is
+// removed to be moved up to the parent.
+func (s *interiorSlots[C]) split(right *interiorSlots[C]) shared.KeyType {
+	mid := len(s.keys) / 2
+	midKey := s.keys[mid]
+	right.keys = append(right.keys[:0], s.keys[mid+1:]...)
+	right.children = append(right.children[:0], s.children[mid+1:]...)
+	s.keys = slices.Delete(s.keys, mid, len(s.keys))
+	s.children = slices.Delete(s.children, mid+1, len(s.children))
+	if s.counts != nil {
+		right.counts = append(right.counts[:0], s.counts[mid+1:]...)
+		s.counts = slices.Delete(s.counts, mid+1, len(s.counts))
+	}
+	return midKey
+}
+
+func (s *interiorSlots[C]) borrowFromLeft(left *interiorSlots[C], separator shared.KeyType) shared.KeyType {
+	last := len(left.keys) - 1
+	s.keys = slices.Insert(s.keys, 0, separator)
+	s.children = slices.Insert(s.children, 0, left.children[last+1])
+	if s.counts != nil {
+		s.counts = slices.Insert(s.counts, 0, left.counts[last+1])
+	}
+
+	separator = left.keys[last]
+	left.keys = slices.Delete(left.keys, last, last+1)
+	left.children = slices.Delete(left.children, last+1, last+2)
+	if left.counts != nil {
+		left.counts = slices.Delete(left.counts, last+1, last+2)
+	}
+	return separator
+}
+
+func (s *interiorSlots[C]) borrowFromRight(right *interiorSlots[C], separator shared.KeyType) shared.KeyType {
+	s.keys = append(s.keys, separator)
+	s.children = append(s.children, right.children[0])
+	if s.counts != nil {
+		s.counts = append(s.counts, right.counts[0])
+	}
+
+	separator = right.keys[0]
+	right.keys = slices.Delete(right.keys, 0, 1)
+	right.children = slices.Delete(right.children, 0, 1)
+	if right.counts != nil {
+		right.counts = slices.Delete(right.counts, 0, 1)
+	}
+	return separator
+}
+
+func (s *interiorSlots[C]) merge(right *interiorSlots[C], separator shared.KeyType) {
+	s.keys = append(append(s.keys, separator), right.keys...)
+	s.children = append(s.children, right.children...)
+	if s.counts != nil {
+		s.counts = append(s.counts, right.counts...)
+	}
+}
+
+// rebalanceAction is how rebalanceSlots fixed the underflow of a child.
+type rebalanceAction int
+
+const (
+	notRebalanced rebalanceAction = iota
+	borrowedFromLeft
+	borrowedFromRight
+	mergedIntoLeft  // The child was merged into its left sibling and removed from the parent
+	mergedWithRight // The right sibling was merged into the child and removed from the parent
+)
+
+// rebalanceSlots fixes the underflow of the child at the index of the parent, by borrowing a key from a sibling that has more than
+// minCount keys, or else by merging it with a sibling, which removes their separator key and the right one of them from the
+// parent. left and right are the siblings of the child, they are only read if the parent has a child on that side.
+// The children of the parent are not changed otherwise, the caller updates them, and the counts, from the action taken.
+func rebalanceSlots[C any, S nodeSlots[S]](parent *interiorSlots[C], index int, child S, left S, right S, minCount int) rebalanceAction {
+	hasLeft, hasRight := index > 0, index < len(parent.keys)
+	switch {
+	case hasLeft && left.keyCount() > minCount:
+		parent.keys[index-1] = child.borrowFromLeft(left, parent.keys[index-1])
+		return borrowedFromLeft
+	case hasRight && right.keyCount() > minCount:
+		parent.keys[index] = child.borrowFromRight(right, parent.keys[index])
+		return borrowedFromRight
+	case hasLeft:
+		left.merge(child, parent.keys[index-1])
+		parent.remove(index - 1)
+		return mergedIntoLeft
+	case hasRight:
+		child.merge(right, parent.keys[index])
+		parent.remove(index)
+		return mergedWithRight
+	}
+	return notRebalanced
+}
@@ -0,0 +1,280 @@
+package block_cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultCapacity is the default capacity of the block cache in bytes.
+const DefaultCapacity uint64 = 8 << 20
+
+// DefaultShardCount is the default number of shards of the block cache.
+const DefaultShardCount = 16
+
+// Policy is the eviction policy of the block cache.
+type Policy int
+
+const (
+	// LRU evicts the least recently used block.
+	LRU Policy = iota
+	// CLOCK evicts the first block not accessed since the clock hand last passed over it (second chance).
+	CLOCK
+	// ARC (Adaptive Replacement Cache) balances between the blocks accessed once and the blocks accessed several times
+	// depending on the recent evictions, which makes it resistant to scans.
+	ARC
+)
+
+// BlockKey identifies a block: the file it comes from (see NewFileID) and its offset in the file.
+type BlockKey struct {
+	FileID uint64
+	Offset uint64
+}
+
+// Options are the options of a block cache.
+type Options struct {
+	Capacity   uint64 // Maximum number of bytes of the unpinned blocks
+	ShardCount int    // Number of shards, each one has its own lock and a part of the capacity
+	Policy     Policy
+}
+
+// Stats are the statistics of a block cache.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Inserts     uint64
+	Evictions   uint64
+	Usage       uint64 // Number of bytes of the blocks in the cache, including the pinned ones
+	PinnedUsage uint64 // Number of bytes of the pinned blocks
+}
+
+// HitRatio returns the ratio of the lookups that found the block in the cache.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// BlockCache is a cache of blocks read from the SSTable files, shared by all the storage levels.
+// It is split into shards, selected by the hash of the block key, so that concurrent reads do not contend on a single lock.
+// The capacity is a number of bytes, split evenly between the shards. Pinned blocks (e.g. index and filter blocks) are
+// never evicted and do not count toward the capacity.
+type BlockCache struct {
+	shards     []*shard
+	nextFileID atomic.Uint64
+}
+
+// NewFileID returns a new file identifier for the block keys, unique within the cache.
+func (c *BlockCache) NewFileID() uint64 {
+	return c.nextFileID.Add(1)
+}
+
+// Get returns the block of the key if it is in the cache.
+func (c *BlockCache) Get(key BlockKey) ([]byte, bool) {
+	return c.getShard(key).get(key)
+}
+
+// Insert inserts the block into the cache, evicting other blocks if the capacity is exceeded.
+// A block larger than the capacity of a shard is not cached.
+func (c *BlockCache) Insert(key BlockKey, data []byte) {
+	c.getShard(key).insert(key, data)
+}
+
+// Pin inserts the block into the cache, it is not evicted until it is unpinned or erased.
+func (c *BlockCache) Pin(key BlockKey, data []byte) {
+	c.getShard(key).pin(key, data)
+}
+
+// Unpin makes a pinned block evictable again.
+func (c *BlockCache) Unpin(key BlockKey) {
+	c.getShard(key).unpin(key)
+}
+
+// Erase removes the block from the cache, pinned or not.
+func (c *BlockCache) Erase(key BlockKey) {
+	c.getShard(key).erase(key)
+}
+
+// EraseFile removes all the blocks of the file from the cache, it is called when the file is deleted.
+func (c *BlockCache) EraseFile(fileID uint64) {
+	for _, s := range c.shards {
+		s.eraseFile(fileID)
+	}
+}
+
+// GetCapacity returns the capacity of the cache in bytes.
+func (c *BlockCache) GetCapacity() uint64 {
+	capacity := uint64(0)
+	for _, s := range c.shards {
+		capacity += s.capacity
+	}
+	return capacity
+}
+
+// GetStats returns the statistics of the cache, summed over the shards.
+func (c *BlockCache) GetStats() Stats {
+	stats := Stats{}
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Inserts += s.stats.Inserts
+		stats.Evictions += s.stats.Evictions
+		stats.Usage += s.usage + s.pinnedUsage
+		stats.PinnedUsage += s.pinnedUsage
+		s.mutex.Unlock()
+	}
+	return stats
+}
+
+func (c *BlockCache) getShard(key BlockKey) *shard {
+	// Fibonacci hashing of the key, the blocks of a file are spread over the shards
+	hash := (key.FileID*0x9E3779B97F4A7C15 ^ key.Offset) * 0x9E3779B97F4A7C15
+	return c.shards[(hash>>32)%uint64(len(c.shards))]
+}
+
+// shard is a part of the block cache with its own lock, capacity and eviction policy.
+type shard struct {
+	mutex       sync.Mutex
+	capacity    uint64
+	usage       uint64 // Number of bytes of the unpinned blocks
+	pinnedUsage uint64
+	pinned      map[BlockKey][]byte
+	policy      policy
+	stats       Stats
+}
+
+func (s *shard) get(key BlockKey) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if data, ok := s.pinned[key]; ok {
+		s.stats.Hits++
+		return data, true
+	}
+	if data, ok := s.policy.get(key); ok {
+		s.stats.Hits++
+		return data, true
+	}
+	s.stats.Misses++
+	return nil, false
+}
+
+func (s *shard) insert(key BlockKey, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.pinned[key]; ok || uint64(len(data)) > s.capacity {
+		return
+	}
+
+	s.usage -= s.policy.insert(key, data)
+	s.usage += uint64(len(data))
+	s.stats.Inserts++
+
+	for s.usage > s.capacity {
+		size, ok := s.policy.evict()
+		if !ok {
+			break
+		}
+		s.usage -= size
+		s.stats.Evictions++
+	}
+}
+
+func (s *shard) pin(key BlockKey, data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.usage -= s.policy.remove(key)
+	s.pinnedUsage -= uint64(len(s.pinned[key]))
+	s.pinned[key] = data
+	s.pinnedUsage += uint64(len(data))
+}
+
+func (s *shard) unpin(key BlockKey) {
+	s.mutex.Lock()
+	data, ok := s.pinned[key]
+	if ok {
+		delete(s.pinned, key)
+		s.pinnedUsage -= uint64(len(data))
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		s.insert(key, data)
+	}
+}
+
+func (s *shard) erase(key BlockKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if data, ok := s.pinned[key]; ok {
+		delete(s.pinned, key)
+		s.pinnedUsage -= uint64(len(data))
+	}
+	s.usage -= s.policy.remove(key)
+}
+
+func (s *shard) eraseFile(fileID uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, data := range s.pinned {
+		if key.FileID == fileID {
+			delete(s.pinned, key)
+			s.pinnedUsage -= uint64(len(data))
+		}
+	}
+	for _, key := range s.policy.keys() {
+		if key.FileID == fileID {
+			s.usage -= s.policy.remove(key)
+		}
+	}
+}
+
+// policy is the eviction policy of a shard, it holds the unpinned blocks. It is not safe for concurrent use.
+type policy interface {
+	// get returns the block of the key and records the access.
+	get(key BlockKey) ([]byte, bool)
+	// insert inserts the block, or replaces it, and returns the size of the replaced block (0 if none).
+	insert(key BlockKey, data []byte) uint64
+	// remove removes the block of the key and returns its size, 0 if it is not in the policy.
+	remove(key BlockKey) uint64
+	// evict removes the block chosen by the policy and returns its size, false if the policy is empty.
+	evict() (uint64, bool)
+	// keys returns the keys of the blocks in the policy.
+	keys() []BlockKey
+}
+
+func newPolicy(p Policy, capacity uint64) policy {
+	switch p {
+	case CLOCK:
+		return newClockPolicy()
+	case ARC:
+		return newARCPolicy(capacity)
+	default:
+		return newLRUPolicy()
+	}
+}
+
+// DefaultOptions returns the default options of a block cache: DefaultCapacity bytes, DefaultShardCount shards and LRU eviction.
+func DefaultOptions() Options {
+	return Options{Capacity: DefaultCapacity, ShardCount: DefaultShardCount, Policy: LRU}
+}
+
+// NewBlockCache creates a block cache with the given options.
+func NewBlockCache(options Options) *BlockCache {
+	shardCount := max(options.ShardCount, 1)
+	c := &BlockCache{shards: make([]*shard, shardCount)}
+	for i := range c.shards {
+		capacity := options.Capacity / uint64(shardCount)
+		c.shards[i] = &shard{
+			capacity: capacity,
+			pinned:   make(map[BlockKey][]byte),
+			policy:   newPolicy(options.Policy, capacity),
+		}
+	}
+	return c
+}
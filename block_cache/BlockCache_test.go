@@ -0,0 +1,142 @@
+package block_cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const testBlockSize = 100
+
+func testBlock(i uint64) []byte {
+	block := make([]byte, testBlockSize)
+	block[0] = byte(i)
+	return block
+}
+
+func key(i uint64) BlockKey {
+	return BlockKey{FileID: 1, Offset: i * testBlockSize}
+}
+
+func newTestBlockCache(policy Policy, blockCount uint64) *BlockCache {
+	return NewBlockCache(Options{Capacity: blockCount * testBlockSize, ShardCount: 1, Policy: policy})
+}
+
+func TestBlockCache_Capacity(t *testing.T) {
+	for _, policy := range []Policy{LRU, CLOCK, ARC} {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			cache := newTestBlockCache(policy, 10)
+			for i := uint64(0); i < 100; i++ {
+				cache.Insert(key(i), testBlock(i))
+				if i%3 == 0 {
+					cache.Get(key(i / 2))
+				}
+				if usage := cache.GetStats().Usage; usage > cache.GetCapacity() {
+					t.Fatalf("usage %d exceeds the capacity %d", usage, cache.GetCapacity())
+				}
+			}
+
+			stats := cache.GetStats()
+			if stats.Usage != cache.GetCapacity() || stats.Evictions != 90 || stats.Inserts != 100 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+			if block, ok := cache.Get(key(99)); !ok || block[0] != 99 {
+				t.Fatalf("expected the last block to be cached")
+			}
+
+			cache.EraseFile(1)
+			if stats := cache.GetStats(); stats.Usage != 0 {
+				t.Fatalf("expected the blocks of the file to be erased, usage %d", stats.Usage)
+			}
+		})
+	}
+}
+
+func TestBlockCache_Eviction(t *testing.T) {
+	// LRU: the block read last is kept
+	cache := newTestBlockCache(LRU, 2)
+	cache.Insert(key(0), testBlock(0))
+	cache.Insert(key(1), testBlock(1))
+	cache.Get(key(0))
+	cache.Insert(key(2), testBlock(2))
+	if _, ok := cache.Get(key(1)); ok {
+		t.Fatalf("LRU: expected the least recently used block to be evicted")
+	}
+
+	// CLOCK: a referenced block gets a second chance
+	cache = newTestBlockCache(CLOCK, 3)
+	for i := uint64(0); i < 3; i++ {
+		cache.Insert(key(i), testBlock(i))
+	}
+	cache.Get(key(0))
+	cache.Insert(key(3), testBlock(3))
+	if _, ok := cache.Get(key(0)); !ok {
+		t.Fatalf("CLOCK: expected the referenced block to be kept")
+	}
+	if _, ok := cache.Get(key(1)); ok {
+		t.Fatalf("CLOCK: expected the first unreferenced block to be evicted")
+	}
+
+	// ARC: a scan of blocks read once does not evict the blocks read several times
+	cache = newTestBlockCache(ARC, 4)
+	for i := uint64(0); i < 2; i++ {
+		cache.Insert(key(i), testBlock(i))
+		cache.Get(key(i))
+	}
+	for i := uint64(100); i < 200; i++ {
+		cache.Insert(key(i), testBlock(i))
+	}
+	for i := uint64(0); i < 2; i++ {
+		if _, ok := cache.Get(key(i)); !ok {
+			t.Fatalf("ARC: expected the frequently used block %d to survive the scan", i)
+		}
+	}
+}
+
+func TestBlockCache_Pin(t *testing.T) {
+	cache := newTestBlockCache(LRU, 2)
+	cache.Pin(key(0), testBlock(0))
+	for i := uint64(1); i < 10; i++ {
+		cache.Insert(key(i), testBlock(i))
+	}
+	if _, ok := cache.Get(key(0)); !ok {
+		t.Fatalf("expected the pinned block not to be evicted")
+	}
+	if stats := cache.GetStats(); stats.PinnedUsage != testBlockSize || stats.Usage != 3*testBlockSize {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	cache.Unpin(key(0))
+	cache.Insert(key(10), testBlock(10))
+	cache.Insert(key(11), testBlock(11))
+	if _, ok := cache.Get(key(0)); ok {
+		t.Fatalf("expected the unpinned block to be evicted")
+	}
+}
+
+func TestBlockCache_Concurrent(t *testing.T) {
+	for _, policy := range []Policy{LRU, CLOCK, ARC} {
+		cache := NewBlockCache(Options{Capacity: 50 * testBlockSize, ShardCount: 4, Policy: policy})
+		var wg sync.WaitGroup
+		for worker := uint64(0); worker < 8; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := uint64(0); i < 2_000; i++ {
+					k := BlockKey{FileID: worker % 2, Offset: (i * 7 % 100) * testBlockSize}
+					if block, ok := cache.Get(k); ok && block[0] != byte(k.Offset/testBlockSize) {
+						t.Errorf("block %v holds the wrong data", k)
+						return
+					}
+					cache.Insert(k, testBlock(k.Offset/testBlockSize))
+				}
+			}()
+		}
+		wg.Wait()
+
+		stats := cache.GetStats()
+		if stats.Usage > cache.GetCapacity() || stats.Hits+stats.Misses != 16_000 {
+			t.Fatalf("policy %d: unexpected stats %+v", policy, stats)
+		}
+	}
+}
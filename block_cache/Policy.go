@@ -0,0 +1,281 @@
+package block_cache
+
+import "container/list"
+
+type entry struct {
+	key        BlockKey
+	data       []byte
+	referenced bool   // CLOCK: accessed since the hand last passed over the entry
+	size       uint64 // ARC: size of the block, kept in the ghost lists
+}
+
+// lruPolicy keeps the blocks ordered from the most recently used (front) to the least recently used (back).
+type lruPolicy struct {
+	list    *list.List
+	entries map[BlockKey]*list.Element
+}
+
+func (p *lruPolicy) get(key BlockKey) ([]byte, bool) {
+	element, ok := p.entries[key]
+	if !ok {
+		return nil, false
+	}
+	p.list.MoveToFront(element)
+	return element.Value.(*entry).data, true
+}
+
+func (p *lruPolicy) insert(key BlockKey, data []byte) uint64 {
+	replaced := p.remove(key)
+	p.entries[key] = p.list.PushFront(&entry{key: key, data: data})
+	return replaced
+}
+
+func (p *lruPolicy) remove(key BlockKey) uint64 {
+	element, ok := p.entries[key]
+	if !ok {
+		return 0
+	}
+	p.list.Remove(element)
+	delete(p.entries, key)
+	return uint64(len(element.Value.(*entry).data))
+}
+
+func (p *lruPolicy) evict() (uint64, bool) {
+	back := p.list.Back()
+	if back == nil {
+		return 0, false
+	}
+	return p.remove(back.Value.(*entry).key), true
+}
+
+func (p *lruPolicy) keys() []BlockKey {
+	keys := make([]BlockKey, 0, len(p.entries))
+	for key := range p.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{list: list.New(), entries: make(map[BlockKey]*list.Element)}
+}
+
+// clockPolicy keeps the blocks in a circular list swept by a hand: an accessed block gets a second chance (its reference
+// bit is cleared) and the first block found without its reference bit is evicted.
+// The new blocks are inserted just behind the hand, so they are the last ones to be swept.
+type clockPolicy struct {
+	list    *list.List
+	hand    *list.Element
+	entries map[BlockKey]*list.Element
+}
+
+func (p *clockPolicy) get(key BlockKey) ([]byte, bool) {
+	element, ok := p.entries[key]
+	if !ok {
+		return nil, false
+	}
+	element.Value.(*entry).referenced = true
+	return element.Value.(*entry).data, true
+}
+
+func (p *clockPolicy) insert(key BlockKey, data []byte) uint64 {
+	replaced := p.remove(key)
+	e := &entry{key: key, data: data}
+	if p.hand == nil {
+		p.entries[key] = p.list.PushBack(e)
+		p.hand = p.entries[key]
+		return replaced
+	}
+	p.entries[key] = p.list.InsertBefore(e, p.hand)
+	return replaced
+}
+
+func (p *clockPolicy) remove(key BlockKey) uint64 {
+	element, ok := p.entries[key]
+	if !ok {
+		return 0
+	}
+	if p.hand == element {
+		p.advance()
+		if p.hand == element {
+			p.hand = nil
+		}
+	}
+	p.list.Remove(element)
+	delete(p.entries, key)
+	return uint64(len(element.Value.(*entry).data))
+}
+
+// advance moves the hand to the next block, wrapping around at the end of the list.
+func (p *clockPolicy) advance() {
+	p.hand = p.hand.Next()
+	if p.hand == nil {
+		p.hand = p.list.Front()
+	}
+}
+
+func (p *clockPolicy) evict() (uint64, bool) {
+	if p.hand == nil {
+		return 0, false
+	}
+	for {
+		e := p.hand.Value.(*entry)
+		if !e.referenced {
+			return p.remove(e.key), true
+		}
+		e.referenced = false
+		p.advance()
+	}
+}
+
+func (p *clockPolicy) keys() []BlockKey {
+	keys := make([]BlockKey, 0, len(p.entries))
+	for key := range p.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func newClockPolicy() *clockPolicy {
+	return &clockPolicy{list: list.New(), entries: make(map[BlockKey]*list.Element)}
+}
+
+// arcPolicy implements the Adaptive Replacement Cache, with sizes in bytes instead of numbers of blocks.
+// t1 holds the blocks accessed once and t2 the blocks accessed at least twice, from the most recent (front) to the oldest.
+// b1 and b2 (ghost lists) hold the keys of the blocks recently evicted from t1 and t2: a miss on a key of b1 (resp. b2)
+// means that t1 (resp. t2) was too small, so the target size of t1 is increased (resp. decreased).
+// Ref: https://www.usenix.org/legacy/events/fast03/tech/full_papers/megiddo/megiddo.pdf
+type arcPolicy struct {
+	capacity       uint64
+	target         uint64 // Target size of t1
+	t1, t2, b1, b2 *arcList
+	entries        map[BlockKey]*list.Element
+	lists          map[BlockKey]*arcList
+}
+
+// arcList is one of the four lists of ARC along with the total size of its blocks.
+type arcList struct {
+	list  *list.List
+	size  uint64
+	ghost bool
+}
+
+func (p *arcPolicy) get(key BlockKey) ([]byte, bool) {
+	element, ok := p.entries[key]
+	if !ok || p.lists[key].ghost {
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	p.remove(key)
+	p.push(p.t2, e)
+	return e.data, true
+}
+
+func (p *arcPolicy) insert(key BlockKey, data []byte) uint64 {
+	size := uint64(len(data))
+	replaced := uint64(0)
+	if element, ok := p.entries[key]; ok {
+		l := p.lists[key]
+		switch {
+		case !l.ghost:
+			replaced = element.Value.(*entry).size
+		case l == p.b1:
+			// Ghost hit: the target size of t1 adapts to the list that was too small
+			p.target = min(p.capacity, p.target+max(p.b2.size/max(p.b1.size, 1), 1)*size)
+		default:
+			p.target -= min(p.target, max(p.b1.size/max(p.b2.size, 1), 1)*size)
+		}
+
+		// A block found in any list has been accessed before, it goes to t2
+		p.remove(key)
+		p.push(p.t2, &entry{key: key, data: data, size: size})
+	} else {
+		p.push(p.t1, &entry{key: key, data: data, size: size})
+	}
+
+	p.trimGhosts()
+	return replaced
+}
+
+// push inserts the entry at the front of the list l.
+func (p *arcPolicy) push(l *arcList, e *entry) {
+	p.entries[e.key] = l.list.PushFront(e)
+	p.lists[e.key] = l
+	l.size += e.size
+}
+
+// trimGhosts drops the oldest keys of the ghost lists so that t1 and b1 hold at most the capacity, and the four lists
+// at most twice the capacity.
+func (p *arcPolicy) trimGhosts() {
+	for p.t1.size+p.b1.size > p.capacity && p.b1.list.Len() > 0 {
+		p.drop(p.b1)
+	}
+	for p.t1.size+p.t2.size+p.b1.size+p.b2.size > 2*p.capacity && p.b2.list.Len() > 0 {
+		p.drop(p.b2)
+	}
+}
+
+// drop removes the oldest entry of the list l.
+func (p *arcPolicy) drop(l *arcList) *entry {
+	e := l.list.Remove(l.list.Back()).(*entry)
+	l.size -= e.size
+	delete(p.entries, e.key)
+	delete(p.lists, e.key)
+	return e
+}
+
+func (p *arcPolicy) remove(key BlockKey) uint64 {
+	element, ok := p.entries[key]
+	if !ok {
+		return 0
+	}
+	l := p.lists[key]
+	e := l.list.Remove(element).(*entry)
+	l.size -= e.size
+	delete(p.entries, key)
+	delete(p.lists, key)
+	if l.ghost {
+		return 0
+	}
+	return e.size
+}
+
+func (p *arcPolicy) evict() (uint64, bool) {
+	var from, ghost *arcList
+	switch {
+	case p.t1.list.Len() > 0 && (p.t1.size > p.target || p.t2.list.Len() == 0):
+		from, ghost = p.t1, p.b1
+	case p.t2.list.Len() > 0:
+		from, ghost = p.t2, p.b2
+	default:
+		return 0, false
+	}
+
+	e := from.list.Back().Value.(*entry)
+	p.remove(e.key)
+	p.push(ghost, &entry{key: e.key, size: e.size})
+	p.trimGhosts()
+	return e.size, true
+}
+
+func (p *arcPolicy) keys() []BlockKey {
+	keys := make([]BlockKey, 0, len(p.entries))
+	for key, l := range p.lists {
+		if !l.ghost {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func newARCPolicy(capacity uint64) *arcPolicy {
+	return &arcPolicy{
+		capacity: capacity,
+		t1:       &arcList{list: list.New()},
+		t2:       &arcList{list: list.New()},
+		b1:       &arcList{list: list.New(), ghost: true},
+		b2:       &arcList{list: list.New(), ghost: true},
+		entries:  make(map[BlockKey]*list.Element),
+		lists:    make(map[BlockKey]*arcList),
+	}
+}
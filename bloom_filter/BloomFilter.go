@@ -47,6 +47,17 @@ func (bf *BloomFilter) Contains(key []byte) bool {
 	return true
 }
 
+// ToByte returns the bitmap of the bloom filter packed into bytes, 8 bits per byte
+func (bf *BloomFilter) ToByte() []byte {
+	data := make([]byte, (bf.capacity+7)/8)
+	for i, bit := range bf.bitmap {
+		if bit {
+			data[i/8] |= 1 << (i % 8)
+		}
+	}
+	return data
+}
+
 // GetErrorMargin returns the error margin of the bloom filter according to it's current number of element
 func (bf *BloomFilter) GetErrorMargin() float64 {
 	n := float64(bf.capacity)
@@ -0,0 +1,289 @@
+package generic
+
+import (
+	"cmp"
+	"dmds_lab2/shared"
+	"slices"
+	"sort"
+)
+
+// BPlusTree is an in-memory B+ tree mapping keys of type K to values of type V, ordered by a comparison function.
+// b_plus_tree.BPlusTree remains the specialization for shared.KeyType keys, with Rank and Select, and safe for concurrent use,
+// which this tree is not.
+type BPlusTree[K any, V any] struct {
+	root     *treeNode[K, V]
+	compare  func(a, b K) int // Negative if a < b, zero if a == b, positive if a > b, as cmp.Compare
+	count    uint64
+	capacity int
+}
+
+// treeNode is a leaf (without children) or an interior node of a BPlusTree.
+type treeNode[K any, V any] struct {
+	keys     []K
+	values   []V               // Leaf only
+	children []*treeNode[K, V] // Interior only, one more than the keys
+	next     *treeNode[K, V]   // Leaf only, nil for the last leaf
+	prev     *treeNode[K, V]   // Leaf only, nil for the first leaf
+}
+
+// minCapacity is the smallest number of keys per node: with fewer, splitting an interior node would leave one of the halves empty.
+const minCapacity = 2
+
+// NewBPlusTree creates an empty tree of ordered keys holding up to capacity keys per node, at least minCapacity.
+func NewBPlusTree[K cmp.Ordered, V any](capacity int) *BPlusTree[K, V] {
+	return NewBPlusTreeFunc[K, V](capacity, cmp.Compare[K])
+}
+
+// NewBPlusTreeFunc creates an empty tree of keys ordered by compare holding up to capacity keys per node, at least minCapacity.
+// compare returns a negative number if a < b, zero if a == b and a positive number if a > b, as cmp.Compare.
+func NewBPlusTreeFunc[K any, V any](capacity int, compare func(a, b K) int) *BPlusTree[K, V] {
+	return &BPlusTree[K, V]{
+		root:     &treeNode[K, V]{},
+		compare:  compare,
+		capacity: max(capacity, minCapacity),
+	}
+}
+
+// Count returns the number of keys in the tree.
+func (t *BPlusTree[K, V]) Count() uint64 {
+	return t.count
+}
+
+func (n *treeNode[K, V]) isLeaf() bool {
+	return n.children == nil
+}
+
+// search returns the index of the first key greater than or equal to the key (leaf), or of the child the key is routed to
+// (interior), and whether the leaf holds the key.
+func (t *BPlusTree[K, V]) search(n *treeNode[K, V], key K) (int, bool) {
+	if n.isLeaf() {
+		idx := sort.Search(len(n.keys), func(i int) bool {
+			return t.compare(n.keys[i], key) >= 0
+		})
+		return idx, idx < len(n.keys) && t.compare(n.keys[idx], key) == 0
+	}
+
+	return sort.Search(len(n.keys), func(i int) bool {
+		return t.compare(n.keys[i], key) > 0
+	}), false
+}
+
+// findLeaf returns the leaf the key belongs to.
+func (t *BPlusTree[K, V]) findLeaf(key K) *treeNode[K, V] {
+	current := t.root
+	for !current.isLeaf() {
+		idx, _ := t.search(current, key)
+		current = current.children[idx]
+	}
+	return current
+}
+
+// Get returns the value of the key, or KeyNotFoundError if the tree does not hold it.
+func (t *BPlusTree[K, V]) Get(key K) (*V, error) {
+	leaf := t.findLeaf(key)
+	idx, found := t.search(leaf, key)
+	if !found {
+		return nil, shared.KeyNotFoundError
+	}
+
+	value := leaf.values[idx]
+	return &value, nil
+}
+
+// Insert inserts the key-value pair, or replaces the value if the key is already in the tree.
+func (t *BPlusTree[K, V]) Insert(key K, value V) error {
+	right, midKey := t.insert(t.root, key, value)
+	if right != nil {
+		t.root = &treeNode[K, V]{
+			keys:     []K{midKey},
+			children: []*treeNode[K, V]{t.root, right},
+		}
+	}
+	return nil
+}
+
+// insert inserts the key-value pair in the subtree of the node. When the node overflows it is split: the new node on its right
+// and the key separating them are returned.
+func (t *BPlusTree[K, V]) insert(n *treeNode[K, V], key K, value V) (*treeNode[K, V], K) {
+	var midKey K
+	idx, found := t.search(n, key)
+
+	if n.isLeaf() {
+		if found {
+			n.values[idx] = value
+			return nil, midKey
+		}
+		n.keys = slices.Insert(n.keys, idx, key)
+		n.values = slices.Insert(n.values, idx, value)
+		t.count++
+		if len(n.keys) <= t.capacity {
+			return nil, midKey
+		}
+
+		mid := len(n.keys) / 2
+		right := &treeNode[K, V]{
+			keys:   slices.Clone(n.keys[mid:]),
+			values: slices.Clone(n.values[mid:]),
+			next:   n.next,
+			prev:   n,
+		}
+		n.keys, n.values = shrink(n.keys, mid), shrink(n.values, mid)
+		if n.next != nil {
+			n.next.prev = right
+		}
+		n.next = right
+		return right, right.keys[0]
+	}
+
+	childRight, childMidKey := t.insert(n.children[idx], key, value)
+	if childRight == nil {
+		return nil, midKey
+	}
+	n.keys = slices.Insert(n.keys, idx, childMidKey)
+	n.children = slices.Insert(n.children, idx+1, childRight)
+	if len(n.keys) <= t.capacity {
+		return nil, midKey
+	}
+
+	// The middle key moves up to the parent
+	mid := len(n.keys) / 2
+	midKey = n.keys[mid]
+	right := &treeNode[K, V]{
+		keys:     slices.Clone(n.keys[mid+1:]),
+		children: slices.Clone(n.children[mid+1:]),
+	}
+	n.keys, n.children = shrink(n.keys, mid), shrink(n.children, mid+1)
+	return right, midKey
+}
+
+// shrink truncates the slice to its first n elements, clearing the other ones so that they can be garbage collected.
+func shrink[S ~[]E, E any](s S, n int) S {
+	clear(s[n:])
+	return s[:n]
+}
+
+// Update replaces the value of the key, or returns KeyNotFoundError if the tree does not hold it.
+func (t *BPlusTree[K, V]) Update(key K, value V) error {
+	leaf := t.findLeaf(key)
+	idx, found := t.search(leaf, key)
+	if !found {
+		return shared.KeyNotFoundError
+	}
+
+	leaf.values[idx] = value
+	return nil
+}
+
+// Delete removes the key, or returns KeyNotFoundError if the tree does not hold it.
+// The nodes left with fewer keys than the minimum are rebalanced bottom-up, and the root is removed once it has a single child.
+func (t *BPlusTree[K, V]) Delete(key K) error {
+	if !t.remove(t.root, key) {
+		return shared.KeyNotFoundError
+	}
+
+	if !t.root.isLeaf() && len(t.root.keys) == 0 {
+		t.root = t.root.children[0]
+	}
+	return nil
+}
+
+// remove removes the key from the subtree of the node and returns whether it held it.
+func (t *BPlusTree[K, V]) remove(n *treeNode[K, V], key K) bool {
+	idx, found := t.search(n, key)
+
+	if n.isLeaf() {
+		if !found {
+			return false
+		}
+		n.keys = slices.Delete(n.keys, idx, idx+1)
+		n.values = slices.Delete(n.values, idx, idx+1)
+		t.count--
+		return true
+	}
+
+	if !t.remove(n.children[idx], key) {
+		return false
+	}
+	if len(n.children[idx].keys) < t.minKeys(n.children[idx]) {
+		t.rebalance(n, idx)
+	}
+	return true
+}
+
+// minKeys returns the number of keys under which a node other than the root has to be rebalanced.
+func (t *BPlusTree[K, V]) minKeys(n *treeNode[K, V]) int {
+	if n.isLeaf() {
+		return (t.capacity + 1) / 2
+	}
+	return t.capacity / 2
+}
+
+// rebalance fixes the underflow of the child at the index, by borrowing a key from a sibling that has more than the minimum,
+// or else by merging it with a sibling, which removes their separator key from the node.
+func (t *BPlusTree[K, V]) rebalance(n *treeNode[K, V], idx int) {
+	child := n.children[idx]
+	minKeys := t.minKeys(child)
+
+	if idx > 0 && len(n.children[idx-1].keys) > minKeys {
+		left := n.children[idx-1]
+		last := len(left.keys) - 1
+		if child.isLeaf() {
+			child.keys = slices.Insert(child.keys, 0, left.keys[last])
+			child.values = slices.Insert(child.values, 0, left.values[last])
+			left.keys, left.values = shrink(left.keys, last), shrink(left.values, last)
+			n.keys[idx-1] = child.keys[0]
+		} else {
+			// The separator moves down to the front of the child and the last key of the sibling moves up
+			child.keys = slices.Insert(child.keys, 0, n.keys[idx-1])
+			child.children = slices.Insert(child.children, 0, left.children[last+1])
+			n.keys[idx-1] = left.keys[last]
+			left.keys, left.children = shrink(left.keys, last), shrink(left.children, last+1)
+		}
+		return
+	}
+
+	if idx < len(n.keys) && len(n.children[idx+1].keys) > minKeys {
+		right := n.children[idx+1]
+		if child.isLeaf() {
+			child.keys = append(child.keys, right.keys[0])
+			child.values = append(child.values, right.values[0])
+			right.keys = slices.Delete(right.keys, 0, 1)
+			right.values = slices.Delete(right.values, 0, 1)
+			n.keys[idx] = right.keys[0]
+		} else {
+			// The separator moves down to the end of the child and the first key of the sibling moves up
+			child.keys = append(child.keys, n.keys[idx])
+			child.children = append(child.children, right.children[0])
+			n.keys[idx] = right.keys[0]
+			right.keys = slices.Delete(right.keys, 0, 1)
+			right.children = slices.Delete(right.children, 0, 1)
+		}
+		return
+	}
+
+	if idx > 0 {
+		t.merge(n, idx-1)
+	} else if idx < len(n.keys) {
+		t.merge(n, idx)
+	}
+}
+
+// merge moves the keys of the child on the right of the separator at the index to the child on its left, and removes the
+// separator and the right child from the node.
+func (t *BPlusTree[K, V]) merge(n *treeNode[K, V], idx int) {
+	left, right := n.children[idx], n.children[idx+1]
+	if left.isLeaf() {
+		left.keys = append(left.keys, right.keys...)
+		left.values = append(left.values, right.values...)
+		left.next = right.next
+		if right.next != nil {
+			right.next.prev = left
+		}
+	} else {
+		left.keys = append(append(left.keys, n.keys[idx]), right.keys...)
+		left.children = append(left.children, right.children...)
+	}
+
+	n.keys = slices.Delete(n.keys, idx, idx+1)
+	n.children = slices.Delete(n.children, idx+1, idx+2)
+}
@@ -0,0 +1,144 @@
+package generic
+
+import (
+	"cmp"
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// checkTree checks the occupancy of the nodes, that the leaves are all at the same depth, and that walking the tree in both
+// directions gives the keys of the model in order.
+func checkTree[K comparable, V comparable](t *testing.T, tree *BPlusTree[K, V], model map[K]V) {
+	t.Helper()
+
+	leafDepth := -1
+	var check func(n *treeNode[K, V], depth int, isRoot bool)
+	check = func(n *treeNode[K, V], depth int, isRoot bool) {
+		if !isRoot && len(n.keys) < tree.minKeys(n) || len(n.keys) > tree.capacity || !n.isLeaf() && len(n.children) != len(n.keys)+1 {
+			t.Fatalf("node holds %d keys, capacity %d", len(n.keys), tree.capacity)
+		}
+		if n.isLeaf() {
+			if leafDepth != -1 && depth != leafDepth {
+				t.Fatalf("leaves at depths %d and %d", leafDepth, depth)
+			}
+			leafDepth = depth
+			return
+		}
+		for _, child := range n.children {
+			check(child, depth+1, false)
+		}
+	}
+	check(tree.root, 0, true)
+
+	keys := make([]K, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, tree.compare)
+
+	position := 0
+	for cursor := tree.Min(); cursor.Valid(); cursor.Next() {
+		if position >= len(keys) || cursor.Key() != keys[position] || cursor.Value() != model[keys[position]] {
+			t.Fatalf("cursor on %v at position %d, expected the keys %v", cursor.Key(), position, keys)
+		}
+		position++
+	}
+	for cursor := tree.Max(); cursor.Valid(); cursor.Prev() {
+		position--
+		if cursor.Key() != keys[position] {
+			t.Fatalf("reverse cursor on %v at position %d, expected %v", cursor.Key(), position, keys[position])
+		}
+	}
+	if position != 0 || tree.Count() != uint64(len(keys)) {
+		t.Fatalf("tree holds %d keys, expected %d", tree.Count(), len(keys))
+	}
+}
+
+func TestBPlusTree_StringKeys(t *testing.T) {
+	for _, capacity := range []int{2, 3, 4, 7, 32} {
+		rng := rand.New(rand.NewSource(int64(capacity)))
+		tree := NewBPlusTree[string, int](capacity)
+		model := make(map[string]int)
+
+		for i := 0; i < 4000; i++ {
+			key := fmt.Sprintf("key-%d", rng.Intn(300))
+			_, exists := model[key]
+
+			// Insert more often than delete in the first half, then delete more often so that the tree shrinks back
+			insertRatio := 65
+			if i >= 2000 {
+				insertRatio = 35
+			}
+
+			switch operation := rng.Intn(100); {
+			case operation < insertRatio:
+				if err := tree.Insert(key, i); err != nil {
+					t.Fatalf("Insert(%q) failed: %v", key, err)
+				}
+				model[key] = i
+			case operation < insertRatio+10:
+				err := tree.Update(key, i)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("Update(%q) = %v, key exists: %v", key, err, exists)
+				}
+				if exists {
+					model[key] = i
+				}
+			default:
+				err := tree.Delete(key)
+				if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+					t.Fatalf("Delete(%q) = %v, key exists: %v", key, err, exists)
+				}
+				delete(model, key)
+			}
+
+			checkTree(t, tree, model)
+			got, err := tree.Get(key)
+			if expected, ok := model[key]; ok && (err != nil || *got != expected) || !ok && !errors.Is(err, shared.KeyNotFoundError) {
+				t.Fatalf("Get(%q) = %v, %v after operation %d", key, got, err, i)
+			}
+		}
+	}
+}
+
+// compositeKey is ordered by tenant, then by descending timestamp.
+type compositeKey struct {
+	tenant    string
+	timestamp uint64
+}
+
+func compareCompositeKeys(a, b compositeKey) int {
+	if c := cmp.Compare(a.tenant, b.tenant); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.timestamp, a.timestamp)
+}
+
+func TestBPlusTree_Comparator(t *testing.T) {
+	tree := NewBPlusTreeFunc[compositeKey, uint64](4, compareCompositeKeys)
+	model := make(map[compositeKey]uint64)
+	for i := uint64(0); i < 500; i++ {
+		key := compositeKey{tenant: fmt.Sprintf("tenant-%d", i%5), timestamp: i}
+		if err := tree.Insert(key, i); err != nil {
+			t.Fatalf("Insert(%v) failed: %v", key, err)
+		}
+		model[key] = i
+	}
+	checkTree(t, tree, model)
+
+	// The keys of a tenant, from the most recent one
+	found := 0
+	for cursor := tree.Range(compositeKey{"tenant-2", 1000}, compositeKey{"tenant-3", 1000}); cursor.Valid(); cursor.Next() {
+		if expected := uint64(497 - 5*found); cursor.Key().tenant != "tenant-2" || cursor.Value() != expected {
+			t.Fatalf("range holds %v = %d, expected %d", cursor.Key(), cursor.Value(), expected)
+		}
+		found++
+	}
+	if found != 100 {
+		t.Fatalf("range holds %d keys, expected 100", found)
+	}
+}
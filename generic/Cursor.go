@@ -0,0 +1,100 @@
+package generic
+
+// Cursor walks the keys of a BPlusTree in order, in both directions, along the chain of leaves.
+// A cursor is positioned on a key while Valid returns true. It must not be used after the tree has been modified.
+type Cursor[K any, V any] struct {
+	tree  *BPlusTree[K, V]
+	leaf  *treeNode[K, V]
+	index int
+	start K // The cursor is not valid before start when bounded
+	end   K // The cursor is not valid from end when bounded
+	bound bool
+}
+
+// Valid returns whether the cursor is positioned on a key.
+func (c *Cursor[K, V]) Valid() bool {
+	if c.leaf == nil || c.index >= len(c.leaf.keys) {
+		return false
+	}
+	key := c.leaf.keys[c.index]
+	return !c.bound || c.tree.compare(key, c.start) >= 0 && c.tree.compare(key, c.end) < 0
+}
+
+// Key returns the key the cursor is positioned on.
+func (c *Cursor[K, V]) Key() K {
+	return c.leaf.keys[c.index]
+}
+
+// Value returns the value of the key the cursor is positioned on.
+func (c *Cursor[K, V]) Value() V {
+	return c.leaf.values[c.index]
+}
+
+// Next moves the cursor to the next key and returns whether it is valid.
+func (c *Cursor[K, V]) Next() bool {
+	if c.leaf == nil {
+		return false
+	}
+
+	c.index++
+	if c.index >= len(c.leaf.keys) && c.leaf.next != nil {
+		c.leaf, c.index = c.leaf.next, 0
+	}
+	return c.Valid()
+}
+
+// Prev moves the cursor to the previous key and returns whether it is valid.
+func (c *Cursor[K, V]) Prev() bool {
+	if c.leaf == nil {
+		return false
+	}
+
+	if c.index > 0 {
+		c.index--
+	} else if c.leaf.prev != nil {
+		c.leaf, c.index = c.leaf.prev, len(c.leaf.prev.keys)-1
+	} else {
+		// Before the first key
+		c.leaf = nil
+	}
+	return c.Valid()
+}
+
+// Seek returns a cursor positioned on the first key greater than or equal to the key, not valid if there is none.
+func (t *BPlusTree[K, V]) Seek(key K) *Cursor[K, V] {
+	leaf := t.findLeaf(key)
+	idx, _ := t.search(leaf, key)
+	if idx >= len(leaf.keys) && leaf.next != nil {
+		return &Cursor[K, V]{tree: t, leaf: leaf.next}
+	}
+	return &Cursor[K, V]{tree: t, leaf: leaf, index: idx}
+}
+
+// Range returns a cursor over the keys in [start, end), positioned on the first one.
+func (t *BPlusTree[K, V]) Range(start K, end K) *Cursor[K, V] {
+	cursor := t.Seek(start)
+	cursor.start, cursor.end, cursor.bound = start, end, true
+	return cursor
+}
+
+// Min returns a cursor positioned on the smallest key, not valid if the tree is empty.
+func (t *BPlusTree[K, V]) Min() *Cursor[K, V] {
+	current := t.root
+	for !current.isLeaf() {
+		current = current.children[0]
+	}
+	return &Cursor[K, V]{tree: t, leaf: current}
+}
+
+// Max returns a cursor positioned on the greatest key, not valid if the tree is empty.
+func (t *BPlusTree[K, V]) Max() *Cursor[K, V] {
+	if t.count == 0 {
+		return &Cursor[K, V]{}
+	}
+
+	current := t.root
+	for !current.isLeaf() {
+		current = current.children[len(current.children)-1]
+	}
+	return &Cursor[K, V]{tree: t, leaf: current, index: len(current.keys) - 1}
+}
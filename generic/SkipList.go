@@ -0,0 +1,172 @@
+package generic
+
+import (
+	"cmp"
+	"dmds_lab2/shared"
+)
+
+// skipListMaxLevel is the maximum level of the nodes of a SkipList, enough for about 2^16 keys at skipListP.
+const skipListMaxLevel = 16
+
+// skipListP is the probability for a node to reach the next level.
+const skipListP float32 = 0.5
+
+// SkipList is an in-memory skip list mapping keys of type K to values of type V, ordered by a comparison function.
+// Unlike skip_list.SkipList, specialized for the records of the LSM tree, it holds a single value per key. It is not safe for
+// concurrent use.
+type SkipList[K any, V any] struct {
+	head    *SkipListNode[K, V] // Sentinel before the first node, of the maximum level
+	tail    *SkipListNode[K, V]
+	compare func(a, b K) int
+	count   uint64
+}
+
+// SkipListNode holds a key-value pair of a SkipList.
+type SkipListNode[K any, V any] struct {
+	key   K
+	value V
+	next  []*SkipListNode[K, V] // Next node at every level of the node
+}
+
+func (n *SkipListNode[K, V]) GetKey() K {
+	return n.key
+}
+
+func (n *SkipListNode[K, V]) GetValue() V {
+	return n.value
+}
+
+// GetNext returns the next node in key order, nil for the last one.
+func (n *SkipListNode[K, V]) GetNext() *SkipListNode[K, V] {
+	return n.next[0]
+}
+
+// NewSkipList returns an empty skip list of ordered keys.
+func NewSkipList[K cmp.Ordered, V any]() *SkipList[K, V] {
+	return NewSkipListFunc[K, V](cmp.Compare[K])
+}
+
+// NewSkipListFunc returns an empty skip list of keys ordered by compare, which returns a negative number if a < b, zero if
+// a == b and a positive number if a > b, as cmp.Compare.
+func NewSkipListFunc[K any, V any](compare func(a, b K) int) *SkipList[K, V] {
+	head := &SkipListNode[K, V]{next: make([]*SkipListNode[K, V], skipListMaxLevel)}
+	return &SkipList[K, V]{
+		head:    head,
+		tail:    head,
+		compare: compare,
+	}
+}
+
+// GetHead returns the first node, nil if the skip list is empty.
+func (s *SkipList[K, V]) GetHead() *SkipListNode[K, V] {
+	return s.head.next[0]
+}
+
+// GetTail returns the last node, nil if the skip list is empty.
+func (s *SkipList[K, V]) GetTail() *SkipListNode[K, V] {
+	if s.tail == s.head {
+		return nil
+	}
+	return s.tail
+}
+
+func (s *SkipList[K, V]) GetCount() uint64 {
+	return s.count
+}
+
+// findPredecessors returns, for every level, the last node with a key lower than the key.
+func (s *SkipList[K, V]) findPredecessors(key K) []*SkipListNode[K, V] {
+	predecessors := make([]*SkipListNode[K, V], skipListMaxLevel)
+	current := s.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		for current.next[level] != nil && s.compare(current.next[level].key, key) < 0 {
+			current = current.next[level]
+		}
+		predecessors[level] = current
+	}
+	return predecessors
+}
+
+// Seek returns the first node with a key greater than or equal to the key, or nil if there is none.
+func (s *SkipList[K, V]) Seek(key K) *SkipListNode[K, V] {
+	current := s.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		for current.next[level] != nil && s.compare(current.next[level].key, key) < 0 {
+			current = current.next[level]
+		}
+	}
+	return current.next[0]
+}
+
+// GetNode returns the node of the key, or KeyNotFoundError if the skip list does not hold it.
+func (s *SkipList[K, V]) GetNode(key K) (*SkipListNode[K, V], error) {
+	node := s.Seek(key)
+	if node == nil || s.compare(node.key, key) != 0 {
+		return nil, shared.KeyNotFoundError
+	}
+	return node, nil
+}
+
+// Get returns the value of the key, or KeyNotFoundError if the skip list does not hold it.
+func (s *SkipList[K, V]) Get(key K) (*V, error) {
+	node, err := s.GetNode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value := node.value
+	return &value, nil
+}
+
+// Insert inserts the key-value pair, or replaces the value if the key is already in the skip list.
+func (s *SkipList[K, V]) Insert(key K, value V) error {
+	predecessors := s.findPredecessors(key)
+	if next := predecessors[0].next[0]; next != nil && s.compare(next.key, key) == 0 {
+		next.value = value
+		return nil
+	}
+
+	level := 1
+	for level < skipListMaxLevel && shared.RandomGenerator.Float32() < skipListP {
+		level++
+	}
+	node := &SkipListNode[K, V]{key: key, value: value, next: make([]*SkipListNode[K, V], level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = predecessors[i].next[i]
+		predecessors[i].next[i] = node
+	}
+
+	if node.next[0] == nil {
+		s.tail = node
+	}
+	s.count++
+	return nil
+}
+
+// Update replaces the value of the key, or returns KeyNotFoundError if the skip list does not hold it.
+func (s *SkipList[K, V]) Update(key K, value V) error {
+	node, err := s.GetNode(key)
+	if err != nil {
+		return err
+	}
+	node.value = value
+	return nil
+}
+
+// Delete removes the key from every level, or returns KeyNotFoundError if the skip list does not hold it.
+func (s *SkipList[K, V]) Delete(key K) error {
+	predecessors := s.findPredecessors(key)
+	node := predecessors[0].next[0]
+	if node == nil || s.compare(node.key, key) != 0 {
+		return shared.KeyNotFoundError
+	}
+
+	for i := range node.next {
+		predecessors[i].next[i] = node.next[i]
+	}
+	if s.tail == node {
+		s.tail = predecessors[0]
+	}
+	s.count--
+	return nil
+}
@@ -0,0 +1,67 @@
+package generic
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSkipList_RandomOperations(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Case-insensitive keys: "Key" and "key" are the same key
+	list := NewSkipListFunc[string, int](func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	model := make(map[string]int)
+
+	for i := 0; i < 5000; i++ {
+		key := string(rune('a'+rng.Intn(26))) + string(rune('a'+rng.Intn(26)))
+		if rng.Intn(2) == 0 {
+			key = strings.ToUpper(key)
+		}
+		lower := strings.ToLower(key)
+		_, exists := model[lower]
+
+		switch rng.Intn(3) {
+		case 0, 1:
+			if err := list.Insert(key, i); err != nil {
+				t.Fatalf("Insert(%q) failed: %v", key, err)
+			}
+			model[lower] = i
+		default:
+			err := list.Delete(key)
+			if exists && err != nil || !exists && !errors.Is(err, shared.KeyNotFoundError) {
+				t.Fatalf("Delete(%q) = %v, key exists: %v", key, err, exists)
+			}
+			delete(model, lower)
+		}
+
+		got, err := list.Get(lower)
+		if expected, ok := model[lower]; ok && (err != nil || *got != expected) || !ok && !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("Get(%q) = %v, %v after operation %d", key, got, err, i)
+		}
+	}
+
+	keys := make([]string, 0, len(model))
+	for key := range model {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	position := 0
+	for node := list.GetHead(); node != nil; node = node.GetNext() {
+		if position >= len(keys) || strings.ToLower(node.GetKey()) != keys[position] || node.GetValue() != model[keys[position]] {
+			t.Fatalf("skip list holds %q at position %d, expected the keys %v", node.GetKey(), position, keys)
+		}
+		position++
+	}
+	if position != len(keys) || list.GetCount() != uint64(len(keys)) {
+		t.Fatalf("skip list holds %d keys (count %d), expected %d", position, list.GetCount(), len(keys))
+	}
+	if len(keys) > 0 && strings.ToLower(list.GetTail().GetKey()) != keys[len(keys)-1] {
+		t.Fatalf("tail %q, expected %q", list.GetTail().GetKey(), keys[len(keys)-1])
+	}
+}
@@ -0,0 +1,24 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"time"
+)
+
+// Clock gives the current time, it decides when the keys inserted with a TTL (see LSMTree.InsertWithTTL) expire.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock reading the system time.
+type SystemClock struct{}
+
+// Now returns the current system time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// toTimestamp converts the time to the timestamps stored in the records.
+func toTimestamp(t time.Time) shared.TimestampType {
+	return shared.TimestampType(t.UnixNano())
+}
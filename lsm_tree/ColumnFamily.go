@@ -0,0 +1,284 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strconv"
+)
+
+// DefaultColumnFamily is the name of the column family used by the methods of the LSM Tree, it always exists.
+const DefaultColumnFamily = "default"
+
+// ColumnFamilyOptions are the options of a column family, given to NewLSMTree.
+type ColumnFamilyOptions struct {
+	Name          string
+	MaxLevel      uint64                // Number of levels, including the memory level
+	MergeOperator MergeOperator         // Merge operator of the family, may be nil
+	ReadMode      ss_table.ReadMode     // How the records of the SSTables are read, in memory by default (see ss_table.ReadMode)
+	SearchMethod  ss_table.SearchMethod // How the records of the SSTables are searched, skip list by default (see ss_table.SearchMethod)
+	// Maximum error of the learned index written in the SSTables (see ss_table.LearnedIndex), 0 to write none unless the search
+	// method is ss_table.LearnedIndexSearch, which uses ss_table.DefaultLearnedIndexError
+	LearnedIndexError uint64
+	// Codec of the SSTables of each storage level, starting from the first one: the last codec applies to the deeper levels,
+	// which usually get a stronger one. The SSTables are not compressed by default
+	Compression []ss_table.Compression
+	KeyEncoding ss_table.KeyEncoding // Encoding of the records in the data blocks of the SSTables, none by default (see ss_table.KeyEncoding)
+}
+
+// ColumnFamily is a keyspace of the LSM Tree with its own memory level and storage levels.
+// The memory levels of all the column families share the write-ahead log of the LSM Tree, so that a WriteBatch can write to
+// several of them atomically, and they are flushed together.
+// The storage levels of the default column family are stored in the root directory, the ones of the other column families
+// in a subdirectory named after them.
+type ColumnFamily struct {
+	tree          *LSMTree
+	id            ColumnFamilyIDType // Identifier of the column family in the log, derived from its name
+	name          string
+	levels        []Level
+	maxLevel      uint64
+	mergeOperator MergeOperator
+}
+
+// GetName returns the name of the column family.
+func (cf *ColumnFamily) GetName() string {
+	return cf.name
+}
+
+// SetMergeOperator sets the merge operator used to fold the merge operands of the column family on Get and during compaction.
+func (cf *ColumnFamily) SetMergeOperator(mergeOperator MergeOperator) {
+	cf.tree.mutex.Lock()
+	defer cf.tree.mutex.Unlock()
+
+	cf.mergeOperator = mergeOperator
+}
+
+// Insert inserts the key-value pair into the column family.
+func (cf *ColumnFamily) Insert(key shared.KeyType, value shared.ValueType) error {
+	cf.tree.mutex.Lock()
+	defer cf.tree.mutex.Unlock()
+
+	return cf.tree.write(cf, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.Insert(key, value, sequenceNumber)
+	})
+}
+
+// Update replaces the value of an existing key of the column family, it returns KeyNotFoundError (or KeyTombstonedError)
+// if the key does not exist.
+func (cf *ColumnFamily) Update(key shared.KeyType, value shared.ValueType) error {
+	cf.tree.mutex.Lock()
+	defer cf.tree.mutex.Unlock()
+
+	if _, _, _, err := cf.get(key, cf.tree.sequenceNumber); err != nil {
+		return err
+	}
+
+	return cf.tree.write(cf, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.Insert(key, value, sequenceNumber)
+	})
+}
+
+// Merge inserts a merge operand for the key into the column family, it is folded into the value by the merge operator of
+// the column family when the key is read or compacted (see LSMTree.Merge).
+func (cf *ColumnFamily) Merge(key shared.KeyType, operand shared.ValueType) error {
+	cf.tree.mutex.Lock()
+	defer cf.tree.mutex.Unlock()
+
+	if cf.mergeOperator == nil {
+		return shared.MergeOperatorNotSetError
+	}
+
+	return cf.tree.write(cf, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.Merge(key, operand, sequenceNumber)
+	})
+}
+
+// Get returns the value of the key in the column family.
+func (cf *ColumnFamily) Get(key shared.KeyType) (*shared.ValueType, error) {
+	cf.tree.mutex.RLock()
+	defer cf.tree.mutex.RUnlock()
+
+	value, _, _, err := cf.get(key, cf.tree.sequenceNumber)
+	return value, err
+}
+
+// Delete inserts a tombstone value for the key into the column family.
+func (cf *ColumnFamily) Delete(key shared.KeyType) error {
+	return cf.Insert(key, shared.TombstoneValue)
+}
+
+// memoryLevel returns the memory level of the column family.
+func (cf *ColumnFamily) memoryLevel() *MemoryLevel {
+	return cf.levels[0].(*MemoryLevel)
+}
+
+// get returns the value of the key as seen by the snapshot.
+// The merge operands found on the way are folded into the value with the merge operator.
+// An expired key is not found, the merge operands written after it apply to a missing value.
+func (cf *ColumnFamily) get(key shared.KeyType, snapshot shared.SequenceNumberType) (*shared.ValueType, Level, string, error) {
+	result, level, err := lookup(cf.levels, key, snapshot)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if result.Value != nil && shared.IsExpired(result.ExpiresAt, toTimestamp(cf.tree.clock.Now())) {
+		if len(result.Operands) == 0 {
+			return nil, nil, "", shared.KeyNotFoundError
+		}
+		result.Value = nil
+	}
+
+	if len(result.Operands) > 0 {
+		merged, err := fullMerge(cf.mergeOperator, key, result.Value, result.Operands)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return &merged, level, result.Source, nil
+	}
+
+	if shared.IsTombstone(*result.Value) {
+		return nil, nil, "", shared.KeyTombstonedError
+	}
+	return result.Value, level, result.Source, nil
+}
+
+// latestSequenceNumber returns the sequence number of the most recent write of the key, 0 if it has never been written.
+func (cf *ColumnFamily) latestSequenceNumber(key shared.KeyType) (shared.SequenceNumberType, error) {
+	result, _, err := lookup(cf.levels, key, cf.tree.sequenceNumber)
+	if errors.Is(err, shared.KeyNotFoundError) {
+		return 0, nil
+	}
+	return result.SequenceNumber, err
+}
+
+// compact flushes the memory level (if it holds any record) to the first storage level, and then the first component of every
+// full storage level to the next level.
+func (cf *ColumnFamily) compact(oldestSnapshot shared.SequenceNumberType, now shared.TimestampType) error {
+	for levelIndex := 0; levelIndex < int(cf.maxLevel-1); levelIndex++ {
+		level := cf.levels[levelIndex]
+		if levelIndex == 0 && level.GetCount() == 0 || levelIndex > 0 && !level.IsFull() {
+			continue
+		}
+
+		nextLevel := cf.levels[levelIndex+1].(*StorageLevel)
+		flushedData, minKey, mayKey, err := level.FlushFirstComponent()
+		if err != nil {
+			return err
+		}
+		options := CompactionOptions{
+			OlderLevels:    cf.storageLevelsFrom(levelIndex + 2),
+			MergeOperator:  cf.mergeOperator,
+			OldestSnapshot: oldestSnapshot,
+			Now:            now,
+		}
+		if err = nextLevel.InsertFlushedData(flushedData, minKey, mayKey, options); err != nil {
+			return err
+		}
+		if err = level.RemoveFlushedComponent(); err != nil {
+			return err
+		}
+		if err = nextLevel.RemoveFlushedComponent(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storageLevelsFrom returns the storage levels starting from the given level index up to the bottommost level.
+func (cf *ColumnFamily) storageLevelsFrom(levelIndex int) []*StorageLevel {
+	levels := make([]*StorageLevel, 0)
+	for i := levelIndex; i < len(cf.levels); i++ {
+		levels = append(levels, cf.levels[i].(*StorageLevel))
+	}
+	return levels
+}
+
+// load creates the directories of the storage levels and loads their SSTables.
+func (cf *ColumnFamily) load() error {
+	for _, level := range cf.levels {
+		if err := level.InitializeStorage(); err != nil {
+			return err
+		}
+		if err := level.Load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the levels of the column family.
+func (cf *ColumnFamily) close() error {
+	for _, level := range cf.levels {
+		if err := level.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMaxSequenceNumber returns the greatest sequence number written to the column family.
+func (cf *ColumnFamily) getMaxSequenceNumber() shared.SequenceNumberType {
+	sequenceNumber := shared.SequenceNumberType(0)
+	for _, level := range cf.levels {
+		sequenceNumber = max(sequenceNumber, level.GetMaxSequenceNumber())
+	}
+	return sequenceNumber
+}
+
+// columnFamilyID returns the identifier of the column family in the log: the 32-bit FNV-1a hash of its name.
+func columnFamilyID(name string) ColumnFamilyIDType {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	return hash.Sum32()
+}
+
+// validateColumnFamilyOptions checks that the name of the column family does not clash with the files of the default one
+// (level directories and log directory).
+func validateColumnFamilyOptions(options ColumnFamilyOptions) error {
+	if options.Name == "" || options.Name != path.Base(options.Name) || options.Name == logDirectory {
+		return fmt.Errorf("invalid column family name %q", options.Name)
+	}
+	if _, err := strconv.ParseUint(options.Name, 10, 64); err == nil {
+		return fmt.Errorf("invalid column family name %q: it must not be a number", options.Name)
+	}
+	if options.MaxLevel < 2 {
+		return fmt.Errorf("invalid max level %d for column family %q: at least one storage level is required", options.MaxLevel, options.Name)
+	}
+	return nil
+}
+
+// newColumnFamily creates the column family, its storage levels are stored in directory.
+func newColumnFamily(tree *LSMTree, directory string, options ColumnFamilyOptions) *ColumnFamily {
+	cf := &ColumnFamily{
+		tree:          tree,
+		id:            columnFamilyID(options.Name),
+		name:          options.Name,
+		levels:        make([]Level, options.MaxLevel),
+		maxLevel:      options.MaxLevel,
+		mergeOperator: options.MergeOperator,
+	}
+
+	learnedIndexError := options.LearnedIndexError
+	if learnedIndexError == 0 && options.SearchMethod == ss_table.LearnedIndexSearch {
+		learnedIndexError = ss_table.DefaultLearnedIndexError
+	}
+
+	cf.levels[0] = NewMemoryLevel(tree.log, cf.id, 0)
+	for i := uint64(1); i < options.MaxLevel; i++ {
+		storageLevel := NewStorageLevel(directory, i, tree.tableCache)
+		storageLevel.SetBlockCache(tree.blockCache)
+		storageLevel.SetReadMode(options.ReadMode)
+		storageLevel.SetSearchMethod(options.SearchMethod)
+		storageLevel.SetLearnedIndexError(learnedIndexError)
+		storageLevel.SetKeyEncoding(options.KeyEncoding)
+		if len(options.Compression) > 0 {
+			storageLevel.SetCompression(options.Compression[min(int(i)-1, len(options.Compression)-1)])
+		}
+		cf.levels[i] = storageLevel
+	}
+	return cf
+}
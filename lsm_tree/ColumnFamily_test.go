@@ -0,0 +1,318 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/key_value"
+	"dmds_lab2/shared"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"testing"
+)
+
+var testColumnFamilies = []ColumnFamilyOptions{
+	{Name: "users", MaxLevel: testMaxLevel},
+	{Name: "sessions", MaxLevel: 3},
+}
+
+func newTestLSMTreeWithFamilies(t *testing.T, rootDirectory string) (*LSMTree, key_value.KeyValueStore, key_value.KeyValueStore) {
+	lsmTree, err := NewLSMTree(rootDirectory, testMaxLevel, testColumnFamilies...)
+	if err != nil {
+		t.Fatalf("NewLSMTree failed: %v", err)
+	}
+	return lsmTree, lsmTree.GetColumnFamily("users"), lsmTree.GetColumnFamily("sessions")
+}
+
+// checkColumnFamily checks that every key of the model has the expected value in the column family.
+func checkColumnFamily(t *testing.T, family key_value.KeyValueStore, model map[shared.KeyType]shared.ValueType) {
+	t.Helper()
+
+	for key, expectedValue := range model {
+		value, err := family.Get(key)
+		if err != nil || *value != expectedValue {
+			t.Fatalf("Get(%d) = %v, %v, expected %d", key, value, err, expectedValue)
+		}
+	}
+}
+
+func TestColumnFamily_SharedLog(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree, users, sessions := newTestLSMTreeWithFamilies(t, rootDirectory)
+
+	// The same key is independent in each column family
+	if err := users.Insert(1, 100); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := sessions.Insert(1, 200); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := sessions.Update(2, 1); !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("expected Update of a missing key to fail, got %v", err)
+	}
+	if _, _, _, err := lsmTree.Get(1); !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("expected key 1 not to be found in the default column family, got %v", err)
+	}
+
+	// A batch spanning the column families
+	batch := &WriteBatch{}
+	batch.Put(lsmTree.GetColumnFamily("users"), 2, 102)
+	batch.Put(lsmTree.GetColumnFamily("sessions"), 2, 202)
+	batch.Delete(lsmTree.GetColumnFamily("users"), 1)
+	if err := lsmTree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := users.Get(1); !errors.Is(err, shared.KeyTombstonedError) && !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("expected key 1 to be deleted, got %v", err)
+	}
+
+	// Enough writes to the default column family to flush the memory levels of all the column families
+	model := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 3*shared.FirstLevelMaxSize; key++ {
+		if err := lsmTree.Insert(key, key+1); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = key + 1
+	}
+	if err := sessions.Insert(3, 203); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The flushed log files have been removed, only the log file written since the last flush is left
+	files, err := os.ReadDir(path.Join(rootDirectory, logDirectory))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected a single log file, got %v, %v", files, err)
+	}
+
+	lsmTree, users, sessions = newTestLSMTreeWithFamilies(t, rootDirectory)
+	checkLSMTree(t, lsmTree, model, nil)
+	checkColumnFamily(t, users, map[shared.KeyType]shared.ValueType{2: 102})
+	checkColumnFamily(t, sessions, map[shared.KeyType]shared.ValueType{1: 200, 2: 202, 3: 203})
+
+	// A batch cut by a crash is not replayed in any of the column families
+	batch = &WriteBatch{}
+	batch.Put(lsmTree.GetColumnFamily("users"), 10, 110)
+	batch.Put(lsmTree.GetColumnFamily("sessions"), 10, 210)
+	if err := lsmTree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	logPath := lsmTree.log.GetPath()
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := os.Truncate(logPath, info.Size()-int64(LogEntrySize)); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	lsmTree, users, sessions = newTestLSMTreeWithFamilies(t, rootDirectory)
+	for _, family := range []key_value.KeyValueStore{users, sessions} {
+		if _, err := family.Get(10); !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("expected key 10 of the cut batch not to be found, got %v", err)
+		}
+	}
+	checkColumnFamily(t, sessions, map[shared.KeyType]shared.ValueType{3: 203})
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The log holds records of the column families, they cannot be dropped
+	if _, err := NewLSMTree(rootDirectory, testMaxLevel); !errors.Is(err, shared.ColumnFamilyNotFoundError) {
+		t.Fatalf("expected opening without the column families to fail, got %v", err)
+	}
+}
+
+func TestColumnFamily_Merge(t *testing.T) {
+	rootDirectory := t.TempDir()
+	families := []ColumnFamilyOptions{
+		{Name: "counters", MaxLevel: testMaxLevel, MergeOperator: NewAddMergeOperator()},
+		{Name: "users", MaxLevel: testMaxLevel},
+	}
+	lsmTree, err := NewLSMTree(rootDirectory, testMaxLevel, families...)
+	if err != nil {
+		t.Fatalf("NewLSMTree failed: %v", err)
+	}
+	counters, users := lsmTree.GetColumnFamily("counters"), lsmTree.GetColumnFamily("users")
+
+	// The merge operands of a column family are folded by its own merge operator
+	if err := users.Merge(1, 1); !errors.Is(err, shared.MergeOperatorNotSetError) {
+		t.Fatalf("expected Merge without merge operator to fail, got %v", err)
+	}
+	batch := &WriteBatch{}
+	batch.Put(counters, 3, 30)
+	batch.Merge(users, 1, 1)
+	if err := lsmTree.Write(batch); !errors.Is(err, shared.MergeOperatorNotSetError) {
+		t.Fatalf("expected Write of a merge without merge operator to fail, got %v", err)
+	}
+	if _, err := counters.Get(3); !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("expected the failed batch not to be written, got %v", err)
+	}
+
+	if err := counters.Insert(1, 10); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := counters.Merge(1, 5); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	batch = &WriteBatch{}
+	batch.Merge(counters, 1, 2)
+	batch.Merge(counters, 2, 7)
+	batch.Put(users, 1, 100)
+	if err := lsmTree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	model := map[shared.KeyType]shared.ValueType{1: 17, 2: 7}
+	checkColumnFamily(t, counters, model)
+	checkColumnFamily(t, users, map[shared.KeyType]shared.ValueType{1: 100})
+
+	// The operands are folded by the compactions, and the ones written since the last flush are replayed from the log
+	for key := shared.KeyType(0); key < 3*shared.FirstLevelMaxSize; key++ {
+		if err := lsmTree.Insert(key, key); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	if err := counters.Merge(2, 1); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	model[2] = 8
+	checkColumnFamily(t, counters, model)
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	lsmTree, err = NewLSMTree(rootDirectory, testMaxLevel, families...)
+	if err != nil {
+		t.Fatalf("NewLSMTree failed: %v", err)
+	}
+	checkColumnFamily(t, lsmTree.GetColumnFamily("counters"), model)
+	checkColumnFamily(t, lsmTree.GetColumnFamily("users"), map[shared.KeyType]shared.ValueType{1: 100})
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestLSMTree_FailedFlushKeepsLogFiles(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree, users, _ := newTestLSMTreeWithFamilies(t, rootDirectory)
+	if err := users.Insert(100, 1_000); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// The first storage level of the default column family cannot be written
+	levelPath := path.Join(rootDirectory, "1")
+	if err := os.Remove(levelPath); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := os.WriteFile(levelPath, nil, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	failed := false
+	for key := shared.KeyType(0); key < shared.FirstLevelMaxSize; key++ {
+		err := lsmTree.Insert(key, key+1)
+		failed = failed || err != nil
+		model[key] = key + 1
+	}
+	if !failed {
+		t.Fatalf("expected the flush to fail")
+	}
+	logFiles, err := os.ReadDir(path.Join(rootDirectory, logDirectory))
+	if err != nil || len(logFiles) < 2 {
+		t.Fatalf("expected the rotated log files to be kept, got %v, %v", logFiles, err)
+	}
+	checkLSMTree(t, lsmTree, model, nil)
+	checkColumnFamily(t, users, map[shared.KeyType]shared.ValueType{100: 1_000})
+
+	// Once the level can be written again, the next flush removes the rotated log files
+	if err := os.Remove(levelPath); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := os.Mkdir(levelPath, 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if err := lsmTree.Insert(10, 11); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	model[10] = 11
+	logFiles, err = os.ReadDir(path.Join(rootDirectory, logDirectory))
+	if err != nil || len(logFiles) != 1 {
+		t.Fatalf("expected a single log file, got %v, %v", logFiles, err)
+	}
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	lsmTree, users, _ = newTestLSMTreeWithFamilies(t, rootDirectory)
+	checkLSMTree(t, lsmTree, model, nil)
+	checkColumnFamily(t, users, map[shared.KeyType]shared.ValueType{100: 1_000})
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// openTestLog opens the write-ahead log stored in directory and returns the keys of the replayed entries.
+func openTestLog(t *testing.T, directory string) (*WriteAheadLog, []shared.KeyType) {
+	t.Helper()
+
+	log := NewWriteAheadLog(directory)
+	keys := make([]shared.KeyType, 0)
+	if err := log.Open(func(entries []LogEntry) error {
+		for _, entry := range entries {
+			keys = append(keys, entry.Record.Key)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return log, keys
+}
+
+func TestWriteAheadLog_AppendsAfterCutBatch(t *testing.T) {
+	directory := t.TempDir()
+	log, _ := openTestLog(t, directory)
+	for _, keys := range [][]shared.KeyType{{1}, {2, 3}} {
+		entries := make([]LogEntry, 0, len(keys))
+		for _, key := range keys {
+			entries = append(entries, LogEntry{Record: shared.Record{Key: key, SequenceNumber: key}})
+		}
+		if err := log.Append(entries); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The batch is cut by a crash, the entries appended after the restart must not be read as its missing entries
+	info, err := os.Stat(log.GetPath())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := os.Truncate(log.GetPath(), info.Size()-int64(LogEntrySize)); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	log, keys := openTestLog(t, directory)
+	if !slices.Equal(keys, []shared.KeyType{1}) {
+		t.Fatalf("replayed %v, expected [1]", keys)
+	}
+	if err := log.Append([]LogEntry{{Record: shared.Record{Key: 4, SequenceNumber: 4}}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	log, keys = openTestLog(t, directory)
+	if !slices.Equal(keys, []shared.KeyType{1, 4}) {
+		t.Fatalf("replayed %v, expected [1 4]", keys)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
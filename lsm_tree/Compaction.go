@@ -3,13 +3,19 @@ package lsm_tree
 import (
 	"container/heap"
 	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
+	"errors"
+	"math"
 )
 
 // An Item is something we manage in a priority queue.
 type Item struct {
-	value    []byte
-	arrIndex int // The index of the array from which the item was taken.
-	valIndex int // The index of the item within its array.
+	key            shared.KeyType
+	sequenceNumber shared.SequenceNumberType
+	record         []byte
+	iterator       ss_table.Iterator // The iterator from which the record was taken.
+	source         int               // The index of the iterator, lower means more recent.
+	position       uint64            // The position of the record within its iterator.
 }
 
 // A PriorityQueue implements heap.Interface and holds Items.
@@ -18,18 +24,19 @@ type PriorityQueue []*Item
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
-	// We want Pop to give us the smallest, not largest, value so we use less than here.
-	k1, err := shared.ByteToKey(pq[i].value[:shared.KeySize])
-	if err != nil {
-		panic(err)
+	// We want Pop to give us the smallest, not largest, key so we use less than here.
+	// On equal keys, the most recent record comes first: the one with the greatest sequence number and, on equal
+	// sequence numbers, the one from the most recent source and, within a source, the one that comes first.
+	if pq[i].key != pq[j].key {
+		return pq[i].key < pq[j].key
 	}
-
-	k2, err := shared.ByteToKey(pq[j].value[:shared.KeySize])
-	if err != nil {
-		panic(err)
+	if pq[i].sequenceNumber != pq[j].sequenceNumber {
+		return pq[i].sequenceNumber > pq[j].sequenceNumber
 	}
-
-	return k1 < k2
+	if pq[i].source != pq[j].source {
+		return pq[i].source < pq[j].source
+	}
+	return pq[i].position < pq[j].position
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
@@ -49,90 +56,273 @@ func (pq *PriorityQueue) Pop() interface{} {
 	return item
 }
 
-func RemoveTombstones(arrays [][]byte) ([][]byte, error) {
-	result := make([][]byte, len(arrays))
+// MergeIterator merges K sorted iterators into a single sorted stream using a K-way merge.
+// Only the most recent record of each key is returned, older duplicates are skipped, and so are the records deleted by a more
+// recent range tombstone. The range tombstones of all the iterators are returned by RangeTombstones.
+// The versions written after the oldest snapshot still in use (see SetOldestSnapshot) are all kept, along with the most recent
+// version visible to that snapshot, so that every snapshot still reads the same values.
+// A tombstone (point or range) is only removed if canDropTombstone returns true for its key range, i.e. when no older level may
+// still hold a value for a key of the range: dropping it otherwise would resurrect that older value.
+// The merge operands of a key are folded with the merge operator (see SetMergeOperator): into a value if an older value (or
+// tombstone) is found in the iterators or if no older level may hold the key, otherwise into a single merge operand.
+// A value expired at the time given to SetExpiryTime is deleted like a tombstone.
+// At most one record per iterator is held in memory (plus the versions of the current key), so the memory used does not depend on the size of the inputs.
+// Ref: https://en.wikipedia.org/wiki/K-way_merge_algorithm
+type MergeIterator struct {
+	iterators        []ss_table.Iterator
+	pq               PriorityQueue
+	record           []byte
+	pending          [][]byte // Records of the current key that are still to be returned
+	err              error
+	rangeTombstones  []shared.RangeTombstone // Range tombstones of all the iterators
+	canDropTombstone func(minKey shared.KeyType, maxKey shared.KeyType) bool
+	oldestSnapshot   shared.SequenceNumberType
+	mergeOperator    MergeOperator
+	getOlder         func(key shared.KeyType) (*shared.ValueType, []shared.ValueType, error)
+	operands         []shared.ValueType
+	versions         []shared.Record // Records of the current key visible to the oldest snapshot, from the most recent to the oldest
+	now              shared.TimestampType
+}
 
-	for arrIndex, arr := range arrays {
-		for startIndex := uint64(0); startIndex < uint64(len(arr)); startIndex += shared.BlockSize {
-			endIndex := startIndex + shared.BlockSize
-			_, value, err := shared.ByteToKeyValue(arr[startIndex:endIndex])
-			if err != nil {
-				return nil, err
-			}
+// SetMergeOperator sets the merge operator used to fold the merge operands.
+// getOlder returns the value and merge operands of a key in the older levels, it is used when two operands cannot be
+// combined by a partial merge and must therefore be applied to the older value of the key.
+func (m *MergeIterator) SetMergeOperator(mergeOperator MergeOperator, getOlder func(key shared.KeyType) (*shared.ValueType, []shared.ValueType, error)) {
+	m.mergeOperator = mergeOperator
+	m.getOlder = getOlder
+}
 
-			if !shared.IsTombstone(value) {
-				result[arrIndex] = append(result[arrIndex], arr[startIndex:endIndex]...)
-			}
-		}
-	}
+// SetOldestSnapshot sets the sequence number of the oldest snapshot still in use, the versions written after it are kept.
+func (m *MergeIterator) SetOldestSnapshot(oldestSnapshot shared.SequenceNumberType) {
+	m.oldestSnapshot = oldestSnapshot
+}
 
-	return result, nil
+// SetExpiryTime sets the current time, the values expired at that time are deleted.
+func (m *MergeIterator) SetExpiryTime(now shared.TimestampType) {
+	m.now = now
 }
 
-// MergeSortedArray merges K sorted arrays of byte slices into a single sorted array.
-// Ref: https://en.wikipedia.org/wiki/K-way_merge_algorithm
-func MergeSortedArray(arrays [][]byte) ([]byte, error) {
-	if len(arrays) < 1 {
-		return []byte{}, nil
+// push reads the next record of the item's iterator and pushes it back to the priority queue.
+func (m *MergeIterator) push(item *Item) {
+	if !item.iterator.Next() {
+		if err := item.iterator.Error(); err != nil && m.err == nil {
+			m.err = err
+		}
+		return
 	}
-	if len(arrays) == 1 {
-		return arrays[0], nil
+
+	record, err := shared.ByteToRecord(item.iterator.Record())
+	if err != nil {
+		m.err = err
+		return
 	}
 
-	pq := make(PriorityQueue, 0)
-	heap.Init(&pq)
-	result := []byte{}
+	// The record is copied because the iterator may reuse its buffer
+	item.record = append(item.record[:0], item.iterator.Record()...)
+	item.key = record.Key
+	item.sequenceNumber = record.SequenceNumber
+	item.position++
+	heap.Push(&m.pq, item)
+}
 
-	// Initialize the priority queue with the first element of each array.
-	for i, arr := range arrays {
-		if len(arr) > 0 {
-			heap.Push(&pq, &Item{value: arr[:shared.BlockSize], arrIndex: i, valIndex: 0})
+// Next advances to the next record in key order (the versions of a key from the most recent to the oldest),
+// skipping the tombstones that can be dropped.
+func (m *MergeIterator) Next() bool {
+	for m.err == nil {
+		if len(m.pending) > 0 {
+			m.record = m.pending[0]
+			m.pending = m.pending[1:]
+			return true
+		}
+		if m.pq.Len() == 0 {
+			return false
+		}
+		// nextKey may have recorded the error of an iterator, it must not be overwritten
+		if err := m.nextKey(); err != nil && m.err == nil {
+			m.err = err
 		}
 	}
 
-	// While the priority queue is not empty, extract the minimum element and add the next element of that array to the heap.
-	for pq.Len() > 0 {
-		// Extract the minimum element and add the next element of that array to the heap.
-		item := heap.Pop(&pq).(*Item)
+	return false
+}
+
+// nextKey goes through the records of the minimum key from the most recent to the oldest and adds the ones to keep to the pending records.
+// The records written after the oldest snapshot are kept as they are, then the merge operands are collected until a value
+// (or tombstone) is found, the older records are skipped.
+func (m *MergeIterator) nextKey() error {
+	item := heap.Pop(&m.pq).(*Item)
+	key := item.key
+	rangeTombstoneSequenceNumber, covered := shared.GetCoveringSequenceNumber(m.rangeTombstones, key, m.oldestSnapshot)
 
-		result = append(result, item.value...)
+	m.operands = m.operands[:0]
+	m.versions = m.versions[:0]
+	var value *shared.ValueType
+	var sequenceNumber shared.SequenceNumberType
+	var expiresAt shared.TimestampType
+	found, isCovered := false, false
+	for {
+		record, err := shared.ByteToRecord(item.record)
+		if err != nil {
+			return err
+		}
 
-		// If there are more elements in the array, add the next element to the heap.
-		if uint64(item.valIndex)+1 < uint64(len(arrays[item.arrIndex]))/shared.BlockSize {
-			start := int(shared.BlockSize) * (item.valIndex + 1)
-			end := int(shared.BlockSize) * (item.valIndex + 2)
-			nextItem := &Item{
-				value:    arrays[item.arrIndex][start:end],
-				arrIndex: item.arrIndex,
-				valIndex: item.valIndex + 1,
+		switch {
+		case record.SequenceNumber > m.oldestSnapshot:
+			m.pending = append(m.pending, append([]byte(nil), item.record...))
+		case value != nil || isCovered:
+			// Shadowed by a more recent record for all the snapshots
+		case covered && record.SequenceNumber < rangeTombstoneSequenceNumber:
+			isCovered = true
+		default:
+			if !found {
+				sequenceNumber, found = record.SequenceNumber, true
+			}
+			m.versions = append(m.versions, record)
+			if record.Kind == shared.KindMerge {
+				m.operands = append(m.operands, record.Value)
+			} else if record.IsExpired(m.now) {
+				// An expired value deletes the older records of the key, like a tombstone
+				tombstone := shared.TombstoneValue
+				value = &tombstone
+			} else {
+				value, expiresAt = &record.Value, record.ExpiresAt
 			}
-			heap.Push(&pq, nextItem)
 		}
+
+		m.push(item)
+		if m.pq.Len() == 0 || m.pq[0].key != key {
+			break
+		}
+		item = heap.Pop(&m.pq).(*Item)
 	}
 
-	return result, nil
+	if !found {
+		return nil
+	}
+	// The range tombstone is more recent than the older records of the key, even the ones that are not in the iterators
+	if covered && value == nil {
+		isCovered = true
+	}
+	return m.fold(key, value, isCovered, sequenceNumber, expiresAt)
 }
 
-func MergeDuplicatedKeys(arrays [][]byte) [][]byte {
-	existingKeys := make(map[shared.KeyType]struct{})
-	result := make([][]byte, len(arrays))
+// fold computes the record of the key from its most recent value (nil if none has been found) and the merge operands
+// collected by nextKey, and adds it to the pending records unless the key has to be skipped.
+// isCovered is true if the older records of the key are deleted by a range tombstone, expiresAt is the expiry of the value.
+func (m *MergeIterator) fold(key shared.KeyType, value *shared.ValueType, isCovered bool, sequenceNumber shared.SequenceNumberType, expiresAt shared.TimestampType) error {
+	if len(m.operands) == 0 {
+		if shared.IsTombstone(*value) && m.canDrop(key, key) {
+			return nil
+		}
+		m.emit(shared.Record{Key: key, Value: *value, Kind: shared.KindValue, SequenceNumber: sequenceNumber, ExpiresAt: expiresAt})
+		return nil
+	}
 
-	for arrIndex, arr := range arrays {
-		for startIndex := uint64(0); startIndex < uint64(len(arr)); startIndex += shared.BlockSize {
-			endIndex := startIndex + shared.BlockSize
-			key, _, err := shared.ByteToKeyValue(arr[startIndex:endIndex])
-			if err != nil {
-				panic(err)
-			}
+	// The operands only apply to a value that has not expired yet, so they are kept apart from it until it expires
+	if value != nil && expiresAt != 0 {
+		for _, version := range m.versions {
+			m.emit(version)
+		}
+		return nil
+	}
 
-			if _, ok := existingKeys[key]; ok {
-				existingKeys[key] = struct{}{}
-			} else {
-				existingKeys[key] = struct{}{}
-				result[arrIndex] = append(result[arrIndex], arr[startIndex:endIndex]...)
-			}
+	// The older records of the key are known: either a value or tombstone has been found, or they are deleted, or there is no older level
+	if value != nil || isCovered || m.canDrop(key, key) {
+		merged, err := fullMerge(m.mergeOperator, key, value, m.operands)
+		if err != nil {
+			return err
 		}
+		m.emit(shared.Record{Key: key, Value: merged, Kind: shared.KindValue, SequenceNumber: sequenceNumber})
+		return nil
+	}
+
+	// An older level may hold the value: the operands are combined into a single one if possible
+	if operand, ok := partialMerge(m.mergeOperator, key, m.operands); ok {
+		m.emit(shared.Record{Key: key, Value: operand, Kind: shared.KindMerge, SequenceNumber: sequenceNumber})
+		return nil
 	}
 
+	// Otherwise, the operands are applied to the value of the older levels
+	if m.getOlder == nil {
+		return shared.MergeOperatorNotSetError
+	}
+	olderValue, olderOperands, err := m.getOlder(key)
+	if err != nil && !errors.Is(err, shared.KeyNotFoundError) {
+		return err
+	}
+	merged, err := fullMerge(m.mergeOperator, key, olderValue, append(m.operands, olderOperands...))
+	if err != nil {
+		return err
+	}
+	m.emit(shared.Record{Key: key, Value: merged, Kind: shared.KindValue, SequenceNumber: sequenceNumber})
+	return nil
+}
+
+// emit adds the record to the pending records.
+func (m *MergeIterator) emit(record shared.Record) {
+	m.pending = append(m.pending, record.ToByte())
+}
+
+// canDrop returns true if a tombstone for the key range [minKey, maxKey] can be removed.
+func (m *MergeIterator) canDrop(minKey shared.KeyType, maxKey shared.KeyType) bool {
+	return m.canDropTombstone != nil && m.canDropTombstone(minKey, maxKey)
+}
+
+// Record returns the current record.
+func (m *MergeIterator) Record() []byte {
+	return m.record
+}
+
+// RangeTombstones returns the range tombstones of all the iterators sorted by start, excluding the ones that can be removed:
+// the ones visible to all the snapshots for which canDropTombstone returns true.
+func (m *MergeIterator) RangeTombstones() []shared.RangeTombstone {
+	result := make([]shared.RangeTombstone, 0)
+	for _, rangeTombstone := range shared.SortRangeTombstones(m.rangeTombstones) {
+		if rangeTombstone.SequenceNumber > m.oldestSnapshot || !m.canDrop(rangeTombstone.Start, rangeTombstone.End-1) {
+			result = append(result, rangeTombstone)
+		}
+	}
 	return result
 }
+
+// Error returns the first error encountered by the merge or by any of the merged iterators.
+func (m *MergeIterator) Error() error {
+	return m.err
+}
+
+// Close closes all the merged iterators.
+func (m *MergeIterator) Close() error {
+	return closeIterators(m.iterators)
+}
+
+// closeIterators closes all the iterators and returns the first error encountered.
+func closeIterators(iterators []ss_table.Iterator) error {
+	var firstErr error
+	for _, it := range iterators {
+		if err := it.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewMergeIterator creates a merge iterator, the iterators must be ordered from the most recent to the oldest.
+// If canDropTombstone is nil, all the tombstones are kept.
+func NewMergeIterator(iterators []ss_table.Iterator, canDropTombstone func(minKey shared.KeyType, maxKey shared.KeyType) bool) *MergeIterator {
+	m := &MergeIterator{
+		iterators:        iterators,
+		pq:               make(PriorityQueue, 0, len(iterators)),
+		rangeTombstones:  make([]shared.RangeTombstone, 0),
+		canDropTombstone: canDropTombstone,
+		oldestSnapshot:   math.MaxUint64,
+	}
+	heap.Init(&m.pq)
+
+	// Initialize the priority queue with the first record of each iterator.
+	for i, it := range iterators {
+		m.rangeTombstones = append(m.rangeTombstones, it.RangeTombstones()...)
+		m.push(&Item{iterator: it, source: i, position: 0})
+	}
+
+	return m
+}
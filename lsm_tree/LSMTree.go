@@ -1,20 +1,205 @@
 package lsm_tree
 
 import (
+	"dmds_lab2/block_cache"
 	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
 	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
 )
 
+// logDirectory is the subdirectory of the root directory where the write-ahead log is stored.
+const logDirectory = "wal"
+
+// LSMTree is safe for concurrent use: the writes are serialized and the reads can run concurrently with each other.
+// Every write is given a sequence number, greater than the ones of the previous writes, which is used to read the
+// LSM Tree as it was at a given point in time (snapshot, see Begin).
+// The keys are stored in column families (see ColumnFamily) sharing a single write-ahead log, the methods of the LSM Tree
+// use the default column family.
 type LSMTree struct {
-	levels        []Level
-	rootDirectory string
-	maxLevel      uint64
+	rootDirectory     string
+	families          []*ColumnFamily
+	familiesByName    map[string]*ColumnFamily
+	defaultFamily     *ColumnFamily
+	log               *WriteAheadLog
+	pendingLogFiles   []string                // Rotated log files, removed once the memory levels of all the column families have been flushed
+	blockCache        *block_cache.BlockCache // Cache of the blocks read from the SSTables, shared by all the storage levels
+	tableCache        *ss_table.TableCache    // Cache of the open SSTable files, shared by all the storage levels
+	mutex             sync.RWMutex
+	sequenceNumber    shared.SequenceNumberType         // Sequence number of the last write
+	snapshots         map[shared.SequenceNumberType]int // Number of transactions using each snapshot
+	locks             *lockManager                      // Locks of the keys written by the pessimistic transactions
+	lastTransactionID uint64
+	clock             Clock
+}
+
+// SetMergeOperator sets the merge operator used to fold the merge operands (see Merge) on Get and during compaction.
+func (L *LSMTree) SetMergeOperator(mergeOperator MergeOperator) {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	L.defaultFamily.mergeOperator = mergeOperator
+}
+
+// GetColumnFamily returns the column family with the given name, or nil if it does not exist.
+func (L *LSMTree) GetColumnFamily(name string) *ColumnFamily {
+	return L.familiesByName[name]
 }
 
 // Insert inserts the key-value pair into the LSM Tree, it first inserts the key-value pair into the SkipList
 // and once the SkipList reaches the limit it compacts the level and moves the data to the next level.
 func (L *LSMTree) Insert(key shared.KeyType, value shared.ValueType) error {
-	err := L.levels[0].(*MemoryLevel).Insert(key, value)
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	return L.insert(key, value)
+}
+
+func (L *LSMTree) insert(key shared.KeyType, value shared.ValueType) error {
+	return L.write(L.defaultFamily, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.Insert(key, value, sequenceNumber)
+	})
+}
+
+// SetClock sets the clock deciding when the keys inserted with a TTL expire, the system clock is used by default.
+func (L *LSMTree) SetClock(clock Clock) {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	L.clock = clock
+}
+
+// SetBlockCache replaces the block cache shared by the storage levels of all the column families, a cache of
+// block_cache.DefaultCapacity bytes with LRU eviction is used by default.
+func (L *LSMTree) SetBlockCache(blockCache *block_cache.BlockCache) {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	L.blockCache = blockCache
+	for _, family := range L.families {
+		for _, level := range family.storageLevelsFrom(1) {
+			level.SetBlockCache(blockCache)
+		}
+	}
+}
+
+// GetBlockCache returns the block cache shared by the storage levels, e.g. to read its statistics.
+func (L *LSMTree) GetBlockCache() *block_cache.BlockCache {
+	L.mutex.RLock()
+	defer L.mutex.RUnlock()
+
+	return L.blockCache
+}
+
+// GetTableCache returns the table cache shared by the storage levels, e.g. to change the maximum number of open SSTable files
+// (ss_table.DefaultTableCacheCapacity by default).
+func (L *LSMTree) GetTableCache() *ss_table.TableCache {
+	return L.tableCache
+}
+
+// InsertWithTTL inserts the key-value pair into the LSM Tree like Insert, the key expires once ttl has elapsed:
+// it is then not found by Get anymore and it is removed by the compactions.
+func (L *LSMTree) InsertWithTTL(key shared.KeyType, value shared.ValueType, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("invalid ttl: it must be positive")
+	}
+
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	expiresAt := toTimestamp(L.clock.Now().Add(ttl))
+	return L.write(L.defaultFamily, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.InsertWithExpiry(key, value, expiresAt, sequenceNumber)
+	})
+}
+
+// Merge inserts a merge operand for the key into the LSM Tree without reading the current value of the key.
+// The operand is folded into the value by the merge operator when the key is read (Get) or compacted.
+func (L *LSMTree) Merge(key shared.KeyType, operand shared.ValueType) error {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	if L.defaultFamily.mergeOperator == nil {
+		return shared.MergeOperatorNotSetError
+	}
+
+	return L.write(L.defaultFamily, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.Merge(key, operand, sequenceNumber)
+	})
+}
+
+// DeleteRange deletes all the keys in [start, end) by inserting a single range tombstone into the LSM Tree.
+// Like Insert, once the SkipList reaches the limit it compacts the level and moves the data to the next level.
+func (L *LSMTree) DeleteRange(start shared.KeyType, end shared.KeyType) error {
+	if start >= end {
+		return errors.New("invalid range: start must be lower than end")
+	}
+
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	return L.write(L.defaultFamily, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		return memoryLevel.DeleteRange(start, end, sequenceNumber)
+	})
+}
+
+// CompareAndSwap atomically replaces the value of the key by newValue if its current value is expected.
+// If the key does not exist or holds another value, a ConditionError holding the current value is returned.
+func (L *LSMTree) CompareAndSwap(key shared.KeyType, expected shared.ValueType, newValue shared.ValueType) error {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	if err := L.checkValue(key, &expected); err != nil {
+		return err
+	}
+	return L.insert(key, newValue)
+}
+
+// PutIfAbsent atomically inserts the key-value pair if the key does not exist (or has been deleted).
+// If the key exists, a ConditionError holding the current value is returned.
+func (L *LSMTree) PutIfAbsent(key shared.KeyType, value shared.ValueType) error {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	if err := L.checkValue(key, nil); err != nil {
+		return err
+	}
+	return L.insert(key, value)
+}
+
+// DeleteIfEquals atomically deletes the key if its current value is expected.
+// If the key does not exist or holds another value, a ConditionError holding the current value is returned.
+func (L *LSMTree) DeleteIfEquals(key shared.KeyType, expected shared.ValueType) error {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	if err := L.checkValue(key, &expected); err != nil {
+		return err
+	}
+	return L.insert(key, shared.TombstoneValue)
+}
+
+// checkValue returns a ConditionError if the current value of the key is not the expected one (nil meaning the key does not exist).
+func (L *LSMTree) checkValue(key shared.KeyType, expected *shared.ValueType) error {
+	current, _, _, err := L.defaultFamily.get(key, L.sequenceNumber)
+	if err != nil && !errors.Is(err, shared.KeyNotFoundError) && !errors.Is(err, shared.KeyTombstonedError) {
+		return err
+	}
+
+	if (current == nil) != (expected == nil) || (current != nil && *current != *expected) {
+		return &shared.ConditionError{Key: key, Actual: current}
+	}
+	return nil
+}
+
+// write applies the write to the memory level of the column family with the next sequence number and compacts the levels
+// once the memory level is full.
+func (L *LSMTree) write(family *ColumnFamily, apply func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error) error {
+	L.sequenceNumber++
+	err := apply(family.memoryLevel(), L.sequenceNumber)
 	if err == nil {
 		return nil
 	}
@@ -23,43 +208,97 @@ func (L *LSMTree) Insert(key shared.KeyType, value shared.ValueType) error {
 		return err
 	}
 
-	for levelIndex := 0; levelIndex < int(L.maxLevel-1); levelIndex++ {
-		level := L.levels[levelIndex]
-		if !level.IsFull() {
-			continue
-		}
+	return L.compact()
+}
 
-		nextLevel := L.levels[levelIndex+1].(*StorageLevel)
-		flushedData, minKey, mayKey, err := level.FlushFirstComponent()
-		if err != nil {
-			return err
-		}
-		if err = nextLevel.InsertFlushedData(flushedData, minKey, mayKey); err != nil {
-			return err
-		}
-		if err = level.RemoveFlushedComponent(); err != nil {
-			return err
-		}
-		if err = nextLevel.RemoveFlushedComponent(); err != nil {
+// compact flushes the memory levels of all the column families together, so that the log files holding their records
+// can be removed, and then compacts the full storage levels of each column family.
+// If a compaction fails, the rotated log files are kept until a later compaction has flushed all the memory levels.
+func (L *LSMTree) compact() error {
+	logFiles, err := L.log.Rotate()
+	if err != nil {
+		return err
+	}
+	L.pendingLogFiles = append(L.pendingLogFiles, logFiles...)
+
+	oldestSnapshot, now := L.oldestSnapshot(), toTimestamp(L.clock.Now())
+	for _, family := range L.families {
+		if err := family.compact(oldestSnapshot, now); err != nil {
 			return err
 		}
 	}
 
+	if err := L.log.Remove(L.pendingLogFiles); err != nil {
+		return err
+	}
+	L.pendingLogFiles = nil
 	return nil
 }
 
-// Get returns the value of the key, it iterates through the levels and calls the Get() method on each level.
+// Get returns the value of the key in the default column family, it iterates through the levels and calls the Get() method on each level.
+// The merge operands found on the way are folded into the value with the merge operator.
+// An expired key is not found, the merge operands written after it apply to a missing value.
 func (L *LSMTree) Get(key shared.KeyType) (*shared.ValueType, Level, string, error) {
-	for _, level := range L.levels {
-		value, source, err := level.Get(key)
-		if err == nil {
-			if shared.IsTombstone(*value) {
-				return nil, nil, "", shared.KeyTombstonedError
-			}
-			return value, level, source, nil
+	L.mutex.RLock()
+	defer L.mutex.RUnlock()
+
+	return L.defaultFamily.get(key, L.sequenceNumber)
+}
+
+// lookup iterates through the levels until it finds a value (or tombstone) for the key as seen by the snapshot.
+// It returns the value (nil if only merge operands have been found) and the merge operands found on the way,
+// from the most recent to the oldest, along with the level and source where the last record has been found.
+// The sequence number is the one of the most recent record of the key.
+func lookup(levels []Level, key shared.KeyType, snapshot shared.SequenceNumberType) (Lookup, Level, error) {
+	result := Lookup{Operands: make([]shared.ValueType, 0)}
+	var lastLevel Level
+	found := false
+
+	for _, level := range levels {
+		levelResult, err := level.Get(key, snapshot)
+		if err != nil {
+			continue
+		}
+
+		if !found {
+			result.SequenceNumber, found = levelResult.SequenceNumber, true
+		}
+		result.Operands = append(result.Operands, levelResult.Operands...)
+		result.Source, lastLevel = levelResult.Source, level
+		if levelResult.Value != nil {
+			result.Value, result.ExpiresAt = levelResult.Value, levelResult.ExpiresAt
+			return result, level, nil
 		}
 	}
-	return nil, nil, "", shared.KeyNotFoundError
+
+	if found {
+		return result, lastLevel, nil
+	}
+	return Lookup{}, nil, shared.KeyNotFoundError
+}
+
+// acquireSnapshot returns a snapshot of the LSM Tree as of the last write, the versions it reads are kept by the
+// compactions until it is released.
+func (L *LSMTree) acquireSnapshot() shared.SequenceNumberType {
+	L.snapshots[L.sequenceNumber]++
+	return L.sequenceNumber
+}
+
+// releaseSnapshot releases a snapshot returned by acquireSnapshot.
+func (L *LSMTree) releaseSnapshot(snapshot shared.SequenceNumberType) {
+	L.snapshots[snapshot]--
+	if L.snapshots[snapshot] <= 0 {
+		delete(L.snapshots, snapshot)
+	}
+}
+
+// oldestSnapshot returns the oldest snapshot still in use, or the last sequence number if there is none.
+func (L *LSMTree) oldestSnapshot() shared.SequenceNumberType {
+	oldest := L.sequenceNumber
+	for snapshot := range L.snapshots {
+		oldest = min(oldest, snapshot)
+	}
+	return oldest
 }
 
 // Delete inserts a tombstone value for the key, the key will be marked as deleted.
@@ -67,38 +306,99 @@ func (L *LSMTree) Delete(key shared.KeyType) error {
 	return L.Insert(key, shared.TombstoneValue)
 }
 
-// Close closes the LSM Tree, it closes all the levels (either MemoryLevel or StorageLevel)
+// Close closes the LSM Tree, it closes all the levels (either MemoryLevel or StorageLevel) of the column families and the log
 func (L *LSMTree) Close() error {
-	for _, level := range L.levels {
-		if err := level.Close(); err != nil {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	for _, family := range L.families {
+		if err := family.close(); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return L.log.Close()
 }
 
-// NewLSMTree creates a new LSM Tree, it initializes the SkipList
-// It also creates the cache file if it does not exist.
-func NewLSMTree(rootDirectory string, maxLevel uint64) (*LSMTree, error) {
+// NewLSMTree opens the LSM Tree stored in rootDirectory with the given column families, in addition to the default one whose
+// number of levels is maxLevel. The options of the default column family may also be given to set its merge operator and the way its SSTables are stored and read.
+// The records of the write-ahead log are replayed into the memory levels of the column families, the log is created if it does not exist.
+// The logs of the memory level written before the log was shared by the column families (<root>/0) are moved to the log first.
+func NewLSMTree(rootDirectory string, maxLevel uint64, families ...ColumnFamilyOptions) (*LSMTree, error) {
 	lsmTree := &LSMTree{
-		rootDirectory: rootDirectory,
-		levels:        make([]Level, maxLevel),
-		maxLevel:      maxLevel,
+		rootDirectory:  rootDirectory,
+		familiesByName: make(map[string]*ColumnFamily),
+		log:            NewWriteAheadLog(path.Join(rootDirectory, logDirectory)),
+		blockCache:     block_cache.NewBlockCache(block_cache.DefaultOptions()),
+		tableCache:     ss_table.NewTableCache(ss_table.DefaultTableCacheCapacity),
+		snapshots:      make(map[shared.SequenceNumberType]int),
+		locks:          newLockManager(),
+		clock:          SystemClock{},
 	}
 
-	lsmTree.levels[0] = NewMemoryLevel(0)
-	for i := uint64(1); i < maxLevel; i++ {
-		lsmTree.levels[i] = NewStorageLevel(i)
+	allOptions := []ColumnFamilyOptions{{Name: DefaultColumnFamily, MaxLevel: maxLevel}}
+	for _, options := range families {
+		if options.Name == DefaultColumnFamily {
+			allOptions[0].MergeOperator = options.MergeOperator
+			allOptions[0].ReadMode = options.ReadMode
+			allOptions[0].SearchMethod = options.SearchMethod
+			allOptions[0].LearnedIndexError = options.LearnedIndexError
+			allOptions[0].Compression = options.Compression
+			allOptions[0].KeyEncoding = options.KeyEncoding
+			continue
+		}
+		allOptions = append(allOptions, options)
 	}
 
-	for _, level := range lsmTree.levels {
-		if err := level.InitializeStorage(); err != nil {
+	familiesByID := make(map[ColumnFamilyIDType]*ColumnFamily)
+	for _, options := range allOptions {
+		if err := validateColumnFamilyOptions(options); err != nil {
 			return nil, err
 		}
-		if err := level.Load(); err != nil {
+
+		directory := rootDirectory
+		if options.Name != DefaultColumnFamily {
+			directory = path.Join(rootDirectory, options.Name)
+		}
+		family := newColumnFamily(lsmTree, directory, options)
+		if other, ok := familiesByID[family.id]; ok {
+			return nil, fmt.Errorf("column families %q and %q have the same identifier, one of them must be renamed", other.name, family.name)
+		}
+		if _, ok := lsmTree.familiesByName[family.name]; ok {
+			return nil, fmt.Errorf("duplicate column family %q", family.name)
+		}
+
+		if err := family.load(); err != nil {
 			return nil, err
 		}
+		lsmTree.families = append(lsmTree.families, family)
+		lsmTree.familiesByName[family.name] = family
+		familiesByID[family.id] = family
+	}
+	lsmTree.defaultFamily = lsmTree.families[0]
+
+	// The records logged by the memory level before the log was shared by the column families belong to the default one
+	if err := lsmTree.log.migrateLegacyLogs(path.Join(rootDirectory, legacyLogDirectory), lsmTree.defaultFamily.id); err != nil {
+		return nil, err
+	}
+	err := lsmTree.log.Open(func(entries []LogEntry) error {
+		for _, entry := range entries {
+			family, ok := familiesByID[entry.FamilyID]
+			if !ok {
+				return fmt.Errorf("%w: the log holds records of an unknown column family (%d)", shared.ColumnFamilyNotFoundError, entry.FamilyID)
+			}
+			if err := family.memoryLevel().apply([]shared.Record{entry.Record}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, family := range lsmTree.families {
+		lsmTree.sequenceNumber = max(lsmTree.sequenceNumber, family.getMaxSequenceNumber())
 	}
 
 	return lsmTree, nil
@@ -0,0 +1,873 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+const testMaxLevel = 5
+
+func newTestLSMTree(t *testing.T, rootDirectory string) *LSMTree {
+	lsmTree, err := NewLSMTree(rootDirectory, testMaxLevel)
+	if err != nil {
+		t.Fatalf("NewLSMTree failed: %v", err)
+	}
+	return lsmTree
+}
+
+// checkLSMTree checks that every key of the model has the expected value and that every deleted key is not found.
+func checkLSMTree(t *testing.T, lsmTree *LSMTree, model map[shared.KeyType]shared.ValueType, deleted map[shared.KeyType]struct{}) {
+	t.Helper()
+
+	for key, expectedValue := range model {
+		value, _, _, err := lsmTree.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%d) failed: %v", key, err)
+		}
+		if *value != expectedValue {
+			t.Fatalf("Get(%d) = %d, expected %d", key, *value, expectedValue)
+		}
+	}
+
+	for key := range deleted {
+		value, _, source, err := lsmTree.Get(key)
+		if !errors.Is(err, shared.KeyTombstonedError) && !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("deleted key %d has been resurrected with value %d from %s", key, *value, source)
+		}
+	}
+}
+
+func TestLSMTree_DeleteThenCompactDoesNotResurrect(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree := newTestLSMTree(t, rootDirectory)
+	rng := rand.New(rand.NewSource(27))
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	deleted := make(map[shared.KeyType]struct{})
+
+	// Fill the deeper levels
+	for i := 0; i < 200; i++ {
+		key := shared.KeyType(rng.Intn(1_000))
+		if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = shared.ValueType(i)
+		delete(deleted, key)
+	}
+
+	// Delete keys while new inserts keep compacting the levels
+	for i := 0; i < 400; i++ {
+		key := shared.KeyType(rng.Intn(1_000))
+		if rng.Intn(2) == 0 {
+			if err := lsmTree.Delete(key); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			delete(model, key)
+			deleted[key] = struct{}{}
+		} else {
+			if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[key] = shared.ValueType(i)
+			delete(deleted, key)
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+	}
+
+	// The tombstones must survive a reload as well
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	checkLSMTree(t, lsmTree, model, deleted)
+
+	for i := 0; i < 100; i++ {
+		key := shared.KeyType(1_000 + i)
+		if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = shared.ValueType(i)
+	}
+	checkLSMTree(t, lsmTree, model, deleted)
+
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestMergeIterator_TombstoneRetention(t *testing.T) {
+	newer := append(shared.KeyValueToByte(1, shared.TombstoneValue), shared.KeyValueToByte(3, 30)...)
+	older := append(shared.KeyValueToByte(1, 10), shared.KeyValueToByte(2, 20)...)
+
+	collect := func(canDropTombstone func(minKey shared.KeyType, maxKey shared.KeyType) bool) map[shared.KeyType]shared.ValueType {
+		merged := NewMergeIterator([]ss_table.Iterator{ss_table.NewSliceIterator(newer, nil), ss_table.NewSliceIterator(older, nil)}, canDropTombstone)
+		defer merged.Close()
+
+		result := make(map[shared.KeyType]shared.ValueType)
+		for merged.Next() {
+			key, value, err := shared.ByteToKeyValue(merged.Record())
+			if err != nil {
+				t.Fatalf("ByteToKeyValue failed: %v", err)
+			}
+			result[key] = value
+		}
+		return result
+	}
+
+	// An older level overlaps: the tombstone must be kept and must shadow the older value
+	kept := collect(func(minKey shared.KeyType, maxKey shared.KeyType) bool { return false })
+	if value, ok := kept[1]; !ok || !shared.IsTombstone(value) {
+		t.Errorf("expected the tombstone of key 1 to be kept, got %v", kept)
+	}
+	if len(kept) != 3 {
+		t.Errorf("expected 3 records, got %v", kept)
+	}
+
+	// Bottommost level: the tombstone and the older value are both dropped
+	dropped := collect(func(minKey shared.KeyType, maxKey shared.KeyType) bool { return true })
+	if _, ok := dropped[1]; ok {
+		t.Errorf("expected key 1 to be dropped, got %v", dropped)
+	}
+	if len(dropped) != 2 {
+		t.Errorf("expected 2 records, got %v", dropped)
+	}
+}
+
+// failingIterator returns the records of a slice iterator then fails instead of reaching the end.
+type failingIterator struct {
+	*ss_table.SliceIterator
+	err error
+}
+
+func (it *failingIterator) Next() bool {
+	if it.SliceIterator.Next() {
+		return true
+	}
+	it.err = errors.New("read failed")
+	return false
+}
+
+func (it *failingIterator) Error() error {
+	return it.err
+}
+
+func TestMergeIterator_FailedCompactionLeavesNoFile(t *testing.T) {
+	level := NewStorageLevel(t.TempDir(), 1, ss_table.NewTableCache(ss_table.DefaultTableCacheCapacity))
+	if err := level.InitializeStorage(); err != nil {
+		t.Fatalf("InitializeStorage failed: %v", err)
+	}
+
+	// Enough records for the writer to finish several SSTables before the input fails
+	data := make([]byte, 0)
+	for key := shared.KeyType(0); key < 4*shared.FirstLevelMaxSize; key++ {
+		data = append(data, shared.KeyValueToByte(key, key)...)
+	}
+	input := &failingIterator{SliceIterator: ss_table.NewSliceIterator(data, nil)}
+	if err := level.InsertFlushedData(input, 0, 4*shared.FirstLevelMaxSize, CompactionOptions{}); err == nil {
+		t.Fatalf("expected the compaction to fail")
+	}
+
+	entries, err := os.ReadDir(level.GetPath())
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected no file left by the failed compaction, got %v, %v", entries, err)
+	}
+	if len(level.ssTablesOrdered) != 0 {
+		t.Fatalf("expected no SSTable in the level, got %v", level.ssTablesOrdered)
+	}
+}
+
+func TestLSMTree_DeleteRange(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree := newTestLSMTree(t, rootDirectory)
+	rng := rand.New(rand.NewSource(28))
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	deleted := make(map[shared.KeyType]struct{})
+
+	for i := 0; i < 600; i++ {
+		switch operation := rng.Intn(10); {
+		case operation == 0:
+			start := shared.KeyType(rng.Intn(1_000))
+			end := start + shared.KeyType(1+rng.Intn(100))
+			if err := lsmTree.DeleteRange(start, end); err != nil {
+				t.Fatalf("DeleteRange failed: %v", err)
+			}
+			for key := start; key < end; key++ {
+				delete(model, key)
+				deleted[key] = struct{}{}
+			}
+		default:
+			key := shared.KeyType(rng.Intn(1_000))
+			if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[key] = shared.ValueType(i)
+			delete(deleted, key)
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+	}
+
+	// The range tombstones must be replayed from the log and loaded from the SSTables
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	checkLSMTree(t, lsmTree, model, deleted)
+
+	if err := lsmTree.DeleteRange(0, 1_100); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	for key := range model {
+		deleted[key] = struct{}{}
+	}
+	model = make(map[shared.KeyType]shared.ValueType)
+	for i := 0; i < 50; i++ {
+		key := shared.KeyType(rng.Intn(1_000))
+		if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = shared.ValueType(i)
+		delete(deleted, key)
+	}
+	checkLSMTree(t, lsmTree, model, deleted)
+
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// writeLegacySSTable writes an SSTable file of the format written before its header started with ss_table.FormatMagic:
+// minKey | maxKey followed by the key-value pairs sorted by key.
+func writeLegacySSTable(t *testing.T, directory string, keys []shared.KeyType, values []shared.ValueType) {
+	t.Helper()
+
+	data := append(shared.KeyToByte(keys[0]), shared.KeyToByte(keys[len(keys)-1])...)
+	for i, key := range keys {
+		data = append(append(data, shared.KeyToByte(key)...), shared.ValueToByte(values[i])...)
+	}
+	fileName := fmt.Sprintf("%d_%d_%s%s", keys[0], keys[len(keys)-1], shared.RandomString(32), shared.SSTableExtension)
+	if err := os.WriteFile(path.Join(directory, fileName), data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestLSMTree_OpensLegacySSTables(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree := newTestLSMTree(t, rootDirectory)
+	directory := lsmTree.defaultFamily.storageLevelsFrom(1)[0].GetPath()
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Key 0, small values and a tombstone, in the storage level the memory level was flushed to
+	writeLegacySSTable(t, directory, []shared.KeyType{0, 1, 2}, []shared.ValueType{3, 0, shared.TombstoneValue})
+	writeLegacySSTable(t, directory, []shared.KeyType{4, 5, 10}, []shared.ValueType{0, 3, 68})
+	model := map[shared.KeyType]shared.ValueType{0: 3, 1: 0, 4: 0, 5: 3, 10: 68}
+	deleted := map[shared.KeyType]struct{}{2: {}}
+
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	checkLSMTree(t, lsmTree, model, deleted)
+	for i := 0; i < 20; i++ {
+		key := shared.KeyType(i * 7 % 20)
+		if err := lsmTree.Insert(key, shared.ValueType(100+i)); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = shared.ValueType(100 + i)
+		delete(deleted, key)
+	}
+	checkLSMTree(t, lsmTree, model, deleted)
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+// writeLegacyLog writes a log of the memory level as written before the log was shared by the column families: the key-value
+// pairs one after the other, followed by the extra bytes. The logs are ordered by their modification time.
+func writeLegacyLog(t *testing.T, rootDirectory string, name string, modTime time.Time, keys []shared.KeyType, values []shared.ValueType,
+	extra []byte) {
+	t.Helper()
+
+	data := make([]byte, 0)
+	for i, key := range keys {
+		data = append(append(data, shared.KeyToByte(key)...), shared.ValueToByte(values[i])...)
+	}
+	filePath := path.Join(rootDirectory, legacyLogDirectory, name+shared.SkipListExtension)
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(filePath, append(data, extra...), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+}
+
+func TestLSMTree_ReplaysLegacyLogs(t *testing.T) {
+	keys := make([]shared.KeyType, 0)
+	values := make([]shared.ValueType, 0)
+	for i := 0; i < 10; i++ {
+		keys, values = append(keys, shared.KeyType(i)), append(values, shared.ValueType(100+i))
+	}
+	keys, values = append(keys, 3, 4), append(values, 1_000, shared.TombstoneValue)
+
+	// Tiny logs, key 0 and small keys and values are decoded as any other key-value pair
+	cases := []struct {
+		keys   []shared.KeyType
+		values []shared.ValueType
+	}{
+		{[]shared.KeyType{0}, []shared.ValueType{3}},
+		{[]shared.KeyType{1, 2}, []shared.ValueType{0, 1}},
+		{[]shared.KeyType{1, 2, 3}, []shared.ValueType{2, 2, 2}},
+		{[]shared.KeyType{0, 256, 512}, []shared.ValueType{1, 2, 3}},
+		{keys, values},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.keys), func(t *testing.T) {
+			rootDirectory := t.TempDir()
+			model := make(map[shared.KeyType]shared.ValueType)
+			deleted := make(map[shared.KeyType]struct{})
+			for i, key := range c.keys {
+				if shared.IsTombstone(c.values[i]) {
+					delete(model, key)
+					deleted[key] = struct{}{}
+				} else {
+					model[key] = c.values[i]
+				}
+			}
+
+			// The log of the memory level being flushed is older than the new one, whose records are more recent
+			now := time.Now()
+			writeLegacyLog(t, rootDirectory, "old", now.Add(-time.Minute), c.keys, c.values, nil)
+			writeLegacyLog(t, rootDirectory, "new", now, c.keys[:1], []shared.ValueType{5_000}, nil)
+			model[c.keys[0]] = 5_000
+
+			lsmTree := newTestLSMTree(t, rootDirectory)
+			checkLSMTree(t, lsmTree, model, deleted)
+			if err := lsmTree.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			if _, err := os.Stat(path.Join(rootDirectory, legacyLogDirectory)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected the legacy log directory to be removed, got %v", err)
+			}
+
+			// The records are now replayed from the log, a legacy log left by an interrupted migration is not replayed again
+			writeLegacyLog(t, rootDirectory, "old", now, c.keys[:1], []shared.ValueType{6_000}, nil)
+			lsmTree = newTestLSMTree(t, rootDirectory)
+			checkLSMTree(t, lsmTree, model, deleted)
+			if err := lsmTree.Insert(30, 300); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[30] = 300
+			checkLSMTree(t, lsmTree, model, deleted)
+			if err := lsmTree.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+			if _, err := os.Stat(path.Join(rootDirectory, legacyLogDirectory)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected the legacy log directory to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLSMTree_KeepsIncompleteLegacyLogs(t *testing.T) {
+	rootDirectory := t.TempDir()
+	now := time.Now()
+	writeLegacyLog(t, rootDirectory, "old", now.Add(-time.Minute), []shared.KeyType{1, 2}, []shared.ValueType{10, 20}, nil)
+	writeLegacyLog(t, rootDirectory, "new", now, []shared.KeyType{3}, []shared.ValueType{30}, []byte{1, 2, 3})
+
+	// The legacy logs are kept, along with the records that could not be decoded
+	if _, err := NewLSMTree(rootDirectory, testMaxLevel); !errors.Is(err, invalidLegacyLogError) {
+		t.Fatalf("NewLSMTree = %v, expected invalidLegacyLogError", err)
+	}
+	for _, name := range []string{"old", "new"} {
+		if _, err := os.Stat(path.Join(rootDirectory, legacyLogDirectory, name+shared.SkipListExtension)); err != nil {
+			t.Fatalf("expected the legacy log %s to be kept, got %v", name, err)
+		}
+	}
+}
+
+// shiftMergeOperator is a non-associative merge operator: each operand computes value*2 + operand.
+type shiftMergeOperator struct{}
+
+func (op shiftMergeOperator) FullMerge(key shared.KeyType, existingValue *shared.ValueType, operands []shared.ValueType) (shared.ValueType, error) {
+	value := shared.ValueType(0)
+	if existingValue != nil {
+		value = *existingValue
+	}
+	for _, operand := range operands {
+		value = value*2 + operand
+	}
+	return value, nil
+}
+
+func (op shiftMergeOperator) PartialMerge(key shared.KeyType, leftOperand shared.ValueType, rightOperand shared.ValueType) (shared.ValueType, bool) {
+	return 0, false
+}
+
+func testMerge(t *testing.T, mergeOperator MergeOperator, apply func(value shared.ValueType, operand shared.ValueType) shared.ValueType) {
+	rootDirectory := t.TempDir()
+	lsmTree := newTestLSMTree(t, rootDirectory)
+	lsmTree.SetMergeOperator(mergeOperator)
+	rng := rand.New(rand.NewSource(29))
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	deleted := make(map[shared.KeyType]struct{})
+
+	for i := 0; i < 600; i++ {
+		key := shared.KeyType(rng.Intn(50))
+		switch operation := rng.Intn(20); {
+		case operation == 0:
+			if err := lsmTree.Delete(key); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			delete(model, key)
+			deleted[key] = struct{}{}
+		case operation == 1:
+			if err := lsmTree.DeleteRange(key, key+5); err != nil {
+				t.Fatalf("DeleteRange failed: %v", err)
+			}
+			for k := key; k < key+5; k++ {
+				delete(model, k)
+				deleted[k] = struct{}{}
+			}
+		case operation < 5:
+			if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[key] = shared.ValueType(i)
+			delete(deleted, key)
+		default:
+			operand := shared.ValueType(rng.Intn(10))
+			if err := lsmTree.Merge(key, operand); err != nil {
+				t.Fatalf("Merge failed: %v", err)
+			}
+			model[key] = apply(model[key], operand)
+			delete(deleted, key)
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+	}
+
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	lsmTree.SetMergeOperator(mergeOperator)
+	checkLSMTree(t, lsmTree, model, deleted)
+
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestLSMTree_MergeAssociative(t *testing.T) {
+	testMerge(t, NewAddMergeOperator(), func(value shared.ValueType, operand shared.ValueType) shared.ValueType {
+		return value + operand
+	})
+}
+
+func TestLSMTree_MergeNonAssociative(t *testing.T) {
+	testMerge(t, shiftMergeOperator{}, func(value shared.ValueType, operand shared.ValueType) shared.ValueType {
+		return value*2 + operand
+	})
+}
+
+func TestLSMTree_MergeWithoutOperator(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+	if err := lsmTree.Merge(1, 1); !errors.Is(err, shared.MergeOperatorNotSetError) {
+		t.Errorf("expected MergeOperatorNotSetError, got %v", err)
+	}
+}
+
+func TestLSMTree_ConditionalWrites(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+
+	if err := lsmTree.PutIfAbsent(1, 10); err != nil {
+		t.Fatalf("PutIfAbsent failed: %v", err)
+	}
+
+	var conditionError *shared.ConditionError
+	err := lsmTree.PutIfAbsent(1, 20)
+	if !errors.As(err, &conditionError) || conditionError.Actual == nil || *conditionError.Actual != 10 {
+		t.Fatalf("expected a ConditionError holding 10, got %v", err)
+	}
+
+	if err := lsmTree.CompareAndSwap(1, 20, 30); !errors.Is(err, shared.ConditionFailedError) {
+		t.Fatalf("expected ConditionFailedError, got %v", err)
+	}
+	if err := lsmTree.CompareAndSwap(1, 10, 30); err != nil {
+		t.Fatalf("CompareAndSwap failed: %v", err)
+	}
+
+	if err := lsmTree.DeleteIfEquals(1, 10); !errors.Is(err, shared.ConditionFailedError) {
+		t.Fatalf("expected ConditionFailedError, got %v", err)
+	}
+	if err := lsmTree.DeleteIfEquals(1, 30); err != nil {
+		t.Fatalf("DeleteIfEquals failed: %v", err)
+	}
+
+	err = lsmTree.CompareAndSwap(1, 30, 40)
+	if !errors.As(err, &conditionError) || conditionError.Actual != nil {
+		t.Fatalf("expected a ConditionError without value, got %v", err)
+	}
+	if err := lsmTree.PutIfAbsent(1, 50); err != nil {
+		t.Fatalf("PutIfAbsent after delete failed: %v", err)
+	}
+}
+
+func TestLSMTree_CompareAndSwapConcurrent(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+	const nWorkers = 8
+	const nIncrements = 50
+
+	if err := lsmTree.Insert(0, 0); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// Each worker increments the counter with a compare-and-swap loop, while also writing other keys to trigger compactions
+	errs := make(chan error, nWorkers)
+	for w := 0; w < nWorkers; w++ {
+		go func(w int) {
+			for i := 0; i < nIncrements; i++ {
+				for {
+					value, _, _, err := lsmTree.Get(0)
+					if err != nil {
+						errs <- err
+						return
+					}
+					err = lsmTree.CompareAndSwap(0, *value, *value+1)
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, shared.ConditionFailedError) {
+						errs <- err
+						return
+					}
+				}
+				if err := lsmTree.Insert(shared.KeyType(1+w*nIncrements+i), 1); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+
+	for w := 0; w < nWorkers; w++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("worker failed: %v", err)
+		}
+	}
+
+	value, _, _, err := lsmTree.Get(0)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if *value != nWorkers*nIncrements {
+		t.Errorf("expected %d, got %d", nWorkers*nIncrements, *value)
+	}
+}
+
+// manualClock is a Clock whose time only changes when advanced by the test.
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func TestLSMTree_InsertWithTTL(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+	clock := &manualClock{now: time.Unix(1_000, 0)}
+	lsmTree.SetClock(clock)
+
+	if err := lsmTree.Insert(3, 30); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := lsmTree.InsertWithTTL(1, 10, 10*time.Second); err != nil {
+		t.Fatalf("InsertWithTTL failed: %v", err)
+	}
+	if err := lsmTree.InsertWithTTL(3, 31, 10*time.Second); err != nil {
+		t.Fatalf("InsertWithTTL failed: %v", err)
+	}
+	if err := lsmTree.InsertWithTTL(4, 40, time.Hour); err != nil {
+		t.Fatalf("InsertWithTTL failed: %v", err)
+	}
+	if err := lsmTree.InsertWithTTL(5, 50, 0); err == nil {
+		t.Fatalf("expected InsertWithTTL to reject a zero ttl")
+	}
+
+	model := map[shared.KeyType]shared.ValueType{1: 10, 3: 31, 4: 40}
+	checkLSMTree(t, lsmTree, model, map[shared.KeyType]struct{}{})
+
+	// The expired keys are not found, and the older value of key 3 must not be visible again
+	clock.now = clock.now.Add(10 * time.Second)
+	delete(model, 1)
+	delete(model, 3)
+	checkLSMTree(t, lsmTree, model, map[shared.KeyType]struct{}{1: {}, 3: {}})
+
+	// The compactions physically remove the expired values
+	for i := 0; i < 3; i++ {
+		for key := shared.KeyType(0); key < 100; key += 2 {
+			if err := lsmTree.Insert(key+shared.KeyType(i%2)*1_000, key); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+			model[key+shared.KeyType(i%2)*1_000] = key
+		}
+	}
+	checkLSMTree(t, lsmTree, model, map[shared.KeyType]struct{}{1: {}, 3: {}})
+
+	for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+		for _, ssTable := range level.ssTables {
+			for _, key := range []shared.KeyType{1, 3} {
+				versions, err := ssTable.GetVersions(key)
+				if err != nil {
+					t.Fatalf("GetVersions failed: %v", err)
+				}
+				for _, version := range versions {
+					if !shared.IsTombstone(version.Value) {
+						t.Errorf("expired record %v of key %d has not been removed from %s", version, key, ssTable.GetPath())
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLSMTree_ReadBlockUsesBlockCache(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+	defer lsmTree.Close()
+
+	for key := shared.KeyType(0); key < 50; key++ {
+		if err := lsmTree.Insert(key, key); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// The bloom filter of every SSTable is pinned
+	cache := lsmTree.GetBlockCache()
+	if stats := cache.GetStats(); stats.PinnedUsage == 0 {
+		t.Fatalf("expected the filter blocks to be pinned, got %+v", stats)
+	}
+
+	// The compactions have already read blocks through the cache, they are dropped
+	for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+		for _, ssTable := range level.ssTables {
+			ssTable.SetBlockCache(cache)
+		}
+	}
+	before := cache.GetStats()
+	for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+		for _, ssTable := range level.ssTables {
+			if err := level.tableCache.Acquire(ssTable); err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+			for i := 0; i < 2; i++ {
+				block, err := ssTable.ReadBlock(0)
+				if err != nil {
+					t.Fatalf("ReadBlock failed: %v", err)
+				}
+				if string(block) != string(ssTable.GetData()[:len(block)]) {
+					t.Fatalf("block of %s does not match its data", ssTable.GetPath())
+				}
+			}
+			if err := level.tableCache.Release(ssTable); err != nil {
+				t.Fatalf("Release failed: %v", err)
+			}
+		}
+	}
+
+	stats := cache.GetStats()
+	if stats.Hits == before.Hits || stats.Hits-before.Hits != stats.Misses-before.Misses {
+		t.Fatalf("expected every block to be read once from the file and once from the cache, got %+v", stats)
+	}
+}
+
+func TestLSMTree_ReadsThroughTableCache(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+	defer lsmTree.Close()
+
+	model := make(map[shared.KeyType]shared.ValueType)
+	for key := shared.KeyType(0); key < 300; key++ {
+		if err := lsmTree.Insert(key, key+1); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		model[key] = key + 1
+	}
+
+	// The SSTables are read through the table cache, every one of them is released after the read
+	if err := lsmTree.GetTableCache().SetCapacity(1); err != nil {
+		t.Fatalf("SetCapacity failed: %v", err)
+	}
+	checkLSMTree(t, lsmTree, model, nil)
+	if count := lsmTree.GetTableCache().GetOpenCount(); count > 1 {
+		t.Fatalf("%d SSTable files open, expected at most 1", count)
+	}
+
+	// The second scans only read blocks already cached by the first ones
+	cache := lsmTree.GetBlockCache()
+	for round := 0; round < 2; round++ {
+		misses := cache.GetStats().Misses
+		for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+			for _, ssTable := range level.ssTables {
+				it, err := level.tableCache.NewIterator(ssTable)
+				if err != nil {
+					t.Fatalf("NewIterator failed: %v", err)
+				}
+				for it.Next() {
+					key, value, err := shared.ByteToKeyValue(it.Record())
+					if err != nil || model[key] != value {
+						t.Fatalf("iterated over %d: %d, %v, expected %d", key, value, err, model[key])
+					}
+				}
+				if err := it.Error(); err != nil {
+					t.Fatalf("iterator failed: %v", err)
+				}
+				if err := it.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+			}
+		}
+		if stats := cache.GetStats(); round == 1 && stats.Misses != misses {
+			t.Fatalf("expected the blocks to be read from the cache, got %d misses", stats.Misses-misses)
+		}
+	}
+}
+
+func TestLSMTree_ReadsThroughBlockCache(t *testing.T) {
+	options := ColumnFamilyOptions{Name: DefaultColumnFamily, ReadMode: ss_table.PreadReadMode,
+		SearchMethod: ss_table.LearnedIndexSearch, Compression: []ss_table.Compression{ss_table.LZCompression}}
+	lsmTree, err := NewLSMTree(t.TempDir(), testMaxLevel, options)
+	if err != nil {
+		t.Fatalf("NewLSMTree failed: %v", err)
+	}
+	defer lsmTree.Close()
+
+	for key := shared.KeyType(0); key < 300; key++ {
+		if err := lsmTree.Insert(key, key); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	// The learned index and the block index of every SSTable are pinned
+	cache := lsmTree.GetBlockCache()
+	if stats := cache.GetStats(); stats.PinnedUsage == 0 {
+		t.Fatalf("expected the index blocks to be pinned, got %+v", stats)
+	}
+
+	// The second lookups and scans only read blocks already cached by the first ones
+	for round := 0; round < 2; round++ {
+		misses := cache.GetStats().Misses
+		for key := shared.KeyType(0); key < 300; key++ {
+			if _, _, _, err := lsmTree.Get(key); err != nil {
+				t.Fatalf("Get(%d) failed: %v", key, err)
+			}
+		}
+		for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+			for _, ssTable := range level.ssTables {
+				it, err := level.tableCache.NewIterator(ssTable)
+				if err != nil {
+					t.Fatalf("NewIterator failed: %v", err)
+				}
+				count := uint64(0)
+				for it.Next() {
+					count++
+				}
+				if err := it.Error(); err != nil {
+					t.Fatalf("iterator failed: %v", err)
+				}
+				if err := it.Close(); err != nil {
+					t.Fatalf("Close failed: %v", err)
+				}
+				if count != ssTable.GetRecordCount() {
+					t.Fatalf("iterated over %d records of %s, expected %d", count, ssTable.GetPath(), ssTable.GetRecordCount())
+				}
+			}
+		}
+		if stats := cache.GetStats(); round == 1 && stats.Misses != misses {
+			t.Fatalf("expected the blocks to be read from the cache, got %d misses", stats.Misses-misses)
+		}
+	}
+}
+
+func TestLSMTree_ReadModes(t *testing.T) {
+	for _, options := range []ColumnFamilyOptions{
+		{Name: DefaultColumnFamily, ReadMode: ss_table.InMemoryReadMode, SearchMethod: ss_table.SkipListSearch},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.PreadReadMode, SearchMethod: ss_table.InterpolationSearch},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.MmapReadMode, SearchMethod: ss_table.LearnedIndexSearch},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.InMemoryReadMode, Compression: []ss_table.Compression{ss_table.NoCompression, ss_table.LZCompression}},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.PreadReadMode, Compression: []ss_table.Compression{ss_table.LZCompression, ss_table.DeflateCompression}},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.MmapReadMode, SearchMethod: ss_table.LearnedIndexSearch, Compression: []ss_table.Compression{ss_table.DeflateCompression}},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.PreadReadMode, KeyEncoding: ss_table.DeltaKeyEncoding},
+		{Name: DefaultColumnFamily, ReadMode: ss_table.MmapReadMode, KeyEncoding: ss_table.PrefixKeyEncoding, Compression: []ss_table.Compression{ss_table.LZCompression}},
+	} {
+		rootDirectory := t.TempDir()
+		readMode := options.ReadMode
+		lsmTree, err := NewLSMTree(rootDirectory, testMaxLevel, options)
+		if err != nil {
+			t.Fatalf("NewLSMTree failed: %v", err)
+		}
+		rng := rand.New(rand.NewSource(36))
+
+		model := make(map[shared.KeyType]shared.ValueType)
+		deleted := make(map[shared.KeyType]struct{})
+		for i := 0; i < 300; i++ {
+			key := shared.KeyType(rng.Intn(200))
+			if rng.Intn(4) == 0 {
+				if err := lsmTree.Delete(key); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				delete(model, key)
+				deleted[key] = struct{}{}
+			} else {
+				if err := lsmTree.Insert(key, shared.ValueType(i)); err != nil {
+					t.Fatalf("Insert failed: %v", err)
+				}
+				model[key] = shared.ValueType(i)
+				delete(deleted, key)
+			}
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+
+		if err := lsmTree.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		lsmTree, err = NewLSMTree(rootDirectory, testMaxLevel, options)
+		if err != nil {
+			t.Fatalf("NewLSMTree failed: %v", err)
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+
+		// The SSTables are read through the table cache, every one of them is released after the read
+		if err := lsmTree.GetTableCache().SetCapacity(1); err != nil {
+			t.Fatalf("SetCapacity failed: %v", err)
+		}
+		checkLSMTree(t, lsmTree, model, deleted)
+		if count := lsmTree.GetTableCache().GetOpenCount(); count > 1 {
+			t.Fatalf("read mode %d: %d SSTable files open, expected at most 1", readMode, count)
+		}
+
+		// Only the pread mode reads the records from the files, the other modes hold them in memory or mapped
+		for _, level := range lsmTree.defaultFamily.storageLevelsFrom(1) {
+			for _, ssTable := range level.ssTables {
+				if ssTable.ReadsFromFile() != (readMode == ss_table.PreadReadMode) {
+					t.Fatalf("read mode %d: unexpected reads from %s", readMode, ssTable.GetPath())
+				}
+				if (ssTable.GetLearnedIndex() != nil) != (options.SearchMethod == ss_table.LearnedIndexSearch) {
+					t.Fatalf("search method %d: unexpected learned index in %s", options.SearchMethod, ssTable.GetPath())
+				}
+			}
+		}
+		if err := lsmTree.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
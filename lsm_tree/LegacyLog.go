@@ -0,0 +1,126 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// legacyLogDirectory is the subdirectory of the root directory where the memory level logged its records before the
+// write-ahead log was shared by the column families: <root>/0/<name>.sl, one record after the other.
+const legacyLogDirectory = "0"
+
+// legacyRecordSize is the size of the records of the legacy logs: key | value.
+const legacyRecordSize = shared.KeySize + shared.ValueSize
+
+// invalidLegacyLogError is the error returned when a legacy log does not end with a complete record.
+var invalidLegacyLogError = errors.New("legacy log ending with an incomplete record")
+
+// migrateLegacyLogs converts the legacy logs of the memory level stored in legacyDirectory to files of the log, holding the
+// records of the column family familyID, and removes them. It must be called before Open so that the records are replayed.
+// Moving the converted file into the log directory commits the migration: if the log already has files, the legacy logs
+// left by an interrupted migration have already been converted and are only removed.
+// The legacy logs are only removed once all of them have been decoded: if one of them does not end with a complete record,
+// invalidLegacyLogError is returned and they are all kept.
+func (w *WriteAheadLog) migrateLegacyLogs(legacyDirectory string, familyID ColumnFamilyIDType) error {
+	files, err := os.ReadDir(legacyDirectory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	legacyFiles := make([]os.FileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != shared.SkipListExtension {
+			continue
+		}
+		fileInfo, err := file.Info()
+		if err != nil {
+			return err
+		}
+		legacyFiles = append(legacyFiles, fileInfo)
+	}
+	// The names of the legacy logs are random: the log of a memory level being flushed is older than the new one
+	sort.Slice(legacyFiles, func(i, j int) bool {
+		if !legacyFiles[i].ModTime().Equal(legacyFiles[j].ModTime()) {
+			return legacyFiles[i].ModTime().Before(legacyFiles[j].ModTime())
+		}
+		return legacyFiles[i].Name() < legacyFiles[j].Name()
+	})
+
+	// The records are numbered in the order of the logs, the order in which the memory level applied them
+	buf := logHeader()
+	sequenceNumber := shared.SequenceNumberType(1)
+	for _, fileInfo := range legacyFiles {
+		data, err := os.ReadFile(path.Join(legacyDirectory, fileInfo.Name()))
+		if err != nil {
+			return err
+		}
+		records, err := decodeLegacyLog(data, sequenceNumber)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileInfo.Name(), err)
+		}
+		for _, record := range records {
+			buf = append(buf, encodeLogEntries([]LogEntry{{FamilyID: familyID, Record: record}})...)
+		}
+		sequenceNumber += shared.SequenceNumberType(len(records))
+	}
+
+	if err := os.MkdirAll(w.directory, 0755); err != nil {
+		return err
+	}
+	logFiles, err := os.ReadDir(w.directory)
+	if err != nil {
+		return err
+	}
+	migrated := false
+	for _, file := range logFiles {
+		migrated = migrated || path.Ext(file.Name()) == shared.SkipListExtension
+	}
+
+	if !migrated && len(legacyFiles) > 0 {
+		temporaryPath := path.Join(w.directory, w.fileName(0)+shared.TemporaryExtension)
+		if err := shared.WriteFileSync(temporaryPath, buf); err != nil {
+			_ = os.Remove(temporaryPath)
+			return err
+		}
+		if err := os.Rename(temporaryPath, path.Join(w.directory, w.fileName(0))); err != nil {
+			_ = os.Remove(temporaryPath)
+			return err
+		}
+	}
+
+	for _, fileInfo := range legacyFiles {
+		if err := os.Remove(path.Join(legacyDirectory, fileInfo.Name())); err != nil {
+			return err
+		}
+	}
+	// The directory is kept if it holds other files
+	_ = os.Remove(legacyDirectory)
+	return nil
+}
+
+// decodeLegacyLog decodes the records of a legacy log, key-value pairs of legacyRecordSize bytes one after the other, to
+// records of kind shared.KindValue (a tombstone keeps shared.TombstoneValue) numbered from firstSequenceNumber.
+// It returns invalidLegacyLogError if the log does not end with a complete record.
+func decodeLegacyLog(data []byte, firstSequenceNumber shared.SequenceNumberType) ([]shared.Record, error) {
+	if uint64(len(data))%legacyRecordSize != 0 {
+		return nil, invalidLegacyLogError
+	}
+
+	records := make([]shared.Record, 0, uint64(len(data))/legacyRecordSize)
+	for offset := uint64(0); offset < uint64(len(data)); offset += legacyRecordSize {
+		key, value, err := shared.ByteToKeyValue(data[offset : offset+legacyRecordSize])
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, shared.Record{Key: key, Value: value, Kind: shared.KindValue,
+			SequenceNumber: firstSequenceNumber + shared.SequenceNumberType(len(records))})
+	}
+	return records, nil
+}
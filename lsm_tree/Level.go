@@ -1,6 +1,9 @@
 package lsm_tree
 
-import "dmds_lab2/shared"
+import (
+	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
+)
 
 // Level represents a level in the LSM Tree
 type Level interface {
@@ -8,13 +11,64 @@ type Level interface {
 	GetCount() uint64
 	GetIndex() uint64
 	GetMaxCount() uint64
+	GetMaxSequenceNumber() shared.SequenceNumberType
 	Add(structure interface{}) error
 	IsFull() bool
 	AsArray() []byte
 	Close() error
 	Load() error
 	InitializeStorage() error
-	Get(key shared.KeyType) (*shared.ValueType, string, error)
-	FlushFirstComponent() ([]byte, shared.KeyType, shared.KeyType, error)
+	Get(key shared.KeyType, snapshot shared.SequenceNumberType) (Lookup, error)
+	FlushFirstComponent() (ss_table.Iterator, shared.KeyType, shared.KeyType, error)
 	RemoveFlushedComponent() error
 }
+
+// Lookup is the state of a key in the LSM Tree (or in one of its levels) as seen by a snapshot.
+type Lookup struct {
+	Value          *shared.ValueType         // Most recent value (or tombstone), nil if only merge operands have been found
+	Operands       []shared.ValueType        // Merge operands written after Value, from the most recent to the oldest
+	SequenceNumber shared.SequenceNumberType // Sequence number of the most recent record (or range tombstone) of the key
+	ExpiresAt      shared.TimestampType      // Time at which Value expires, 0 if it never expires
+	Source         string                    // File where the last record has been found
+}
+
+// resolve computes the state of the key at the snapshot from its versions (from the most recent to the oldest) and the
+// range tombstones of a component. The versions written after the snapshot are ignored, and so are the versions written
+// before a range tombstone covering the key: they are deleted, which is returned as a tombstone value.
+// It returns false if the component holds nothing for the key at the snapshot.
+func resolve(versions []shared.Record, rangeTombstones []shared.RangeTombstone, key shared.KeyType, snapshot shared.SequenceNumberType, source string) (Lookup, bool) {
+	rangeTombstoneSequenceNumber, covered := shared.GetCoveringSequenceNumber(rangeTombstones, key, snapshot)
+	lookup := Lookup{Operands: make([]shared.ValueType, 0), Source: source}
+	found := false
+
+	for _, record := range versions {
+		if record.SequenceNumber > snapshot {
+			continue
+		}
+		if covered && record.SequenceNumber < rangeTombstoneSequenceNumber {
+			break
+		}
+
+		if !found {
+			lookup.SequenceNumber, found = record.SequenceNumber, true
+		}
+		value := record.Value
+		if record.Kind != shared.KindMerge {
+			lookup.Value = &value
+			lookup.ExpiresAt = record.ExpiresAt
+			return lookup, true
+		}
+		lookup.Operands = append(lookup.Operands, value)
+	}
+
+	if covered {
+		tombstone := shared.TombstoneValue
+		lookup.Value = &tombstone
+		if !found {
+			lookup.SequenceNumber = rangeTombstoneSequenceNumber
+		}
+		return lookup, true
+	}
+
+	return lookup, found
+}
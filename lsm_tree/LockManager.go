@@ -0,0 +1,86 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"sync"
+)
+
+// lockManager holds the locks of the keys written by the pessimistic transactions.
+// A transaction waits for at most one lock at a time, so the wait-for graph is made of chains: waiting for a lock
+// creates a deadlock if the owner of the lock is (transitively) waiting for the transaction itself.
+type lockManager struct {
+	mutex    sync.Mutex
+	released *sync.Cond
+	owners   map[shared.KeyType]uint64 // Transaction holding the lock of each key
+	waitsFor map[uint64]uint64         // Transaction holding the lock each transaction is waiting for
+}
+
+// lock acquires the lock of the key for the transaction, waiting for it to be released if another transaction holds it.
+// It returns DeadlockError instead of waiting if that would create a deadlock.
+func (lm *lockManager) lock(transactionID uint64, key shared.KeyType) error {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	for {
+		owner, locked := lm.owners[key]
+		if !locked || owner == transactionID {
+			lm.owners[key] = transactionID
+			delete(lm.waitsFor, transactionID)
+			return nil
+		}
+
+		if lm.isWaitingFor(owner, transactionID) {
+			delete(lm.waitsFor, transactionID)
+			return shared.DeadlockError
+		}
+
+		lm.waitsFor[transactionID] = owner
+		lm.released.Wait()
+	}
+}
+
+// isWaitingFor returns true if the transaction is the target or is (transitively) waiting for a lock held by the target.
+func (lm *lockManager) isWaitingFor(transactionID uint64, target uint64) bool {
+	for {
+		if transactionID == target {
+			return true
+		}
+		next, waiting := lm.waitsFor[transactionID]
+		if !waiting {
+			return false
+		}
+		transactionID = next
+	}
+}
+
+// owner returns the transaction holding the lock of the key, false if the key is not locked.
+func (lm *lockManager) owner(key shared.KeyType) (uint64, bool) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	owner, locked := lm.owners[key]
+	return owner, locked
+}
+
+// unlockAll releases all the locks held by the transaction and wakes up the transactions waiting for them.
+func (lm *lockManager) unlockAll(transactionID uint64) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+
+	for key, owner := range lm.owners {
+		if owner == transactionID {
+			delete(lm.owners, key)
+		}
+	}
+	delete(lm.waitsFor, transactionID)
+	lm.released.Broadcast()
+}
+
+func newLockManager() *lockManager {
+	lm := &lockManager{
+		owners:   make(map[shared.KeyType]uint64),
+		waitsFor: make(map[uint64]uint64),
+	}
+	lm.released = sync.NewCond(&lm.mutex)
+	return lm
+}
@@ -3,32 +3,33 @@ package lsm_tree
 import (
 	"dmds_lab2/shared"
 	"dmds_lab2/skip_list"
+	"dmds_lab2/ss_table"
 	"errors"
-	"os"
-	"path"
-	"strconv"
+	"math"
 )
 
 // MemoryLevel represents a memory level in the LSM Tree, it contains a SkipList
+// Its records are logged to the write-ahead log shared with the memory levels of the other column families.
 type MemoryLevel struct {
-	index            uint64              // Index of the memory level should always be 0
-	skipList         *skip_list.SkipList // SkipList in the memory level
-	osFile           *os.File            // File to store the key-value pairs
-	currentFileName  string              // Name of the file that is currently being used to store the key-value pairs
-	fileNameToDelete string              // Name of the file that is going to be deleted
+	index             uint64                    // Index of the memory level should always be 0
+	skipList          *skip_list.SkipList       // SkipList in the memory level
+	rangeTombstones   []shared.RangeTombstone   // Range tombstones of the memory level, in insertion order
+	maxSequenceNumber shared.SequenceNumberType // Greatest sequence number written to the level
+	log               *WriteAheadLog            // Log where the records are written before being inserted into the SkipList
+	familyID          ColumnFamilyIDType        // Identifier of the column family of the level in the log
 }
 
-// GetPath returns the path of the storage level where the logs are stored
+// GetPath returns the path of the log file where the records are currently written
 func (L *MemoryLevel) GetPath() string {
-	return path.Join(shared.SSTablesRootDirectory, strconv.FormatUint(L.index, 10))
+	return L.log.GetPath()
 }
 
-// GetCount returns the number of key-value pairs in the level (SkipList)
+// GetCount returns the number of key-value pairs (SkipList) and range tombstones in the level
 func (L *MemoryLevel) GetCount() uint64 {
 	if L.skipList == nil {
 		return 0
 	}
-	return L.skipList.GetCount()
+	return L.skipList.GetCount() + uint64(len(L.rangeTombstones))
 }
 
 // GetIndex returns the index of the level, should always be 0
@@ -41,6 +42,11 @@ func (L *MemoryLevel) GetMaxCount() uint64 {
 	return shared.FirstLevelMaxSize
 }
 
+// GetMaxSequenceNumber returns the greatest sequence number written to the level, including the ones replayed from the log file
+func (L *MemoryLevel) GetMaxSequenceNumber() shared.SequenceNumberType {
+	return L.maxSequenceNumber
+}
+
 // Add adds a new SkipList to the level
 func (L *MemoryLevel) Add(slInt interface{}) error {
 	sl, ok := slInt.(*skip_list.SkipList)
@@ -50,45 +56,46 @@ func (L *MemoryLevel) Add(slInt interface{}) error {
 
 	L.skipList = sl
 
-	if err := L.InitializeStorage(); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-// RemoveFlushedComponent removes the old SkipList from the level
+// RemoveFlushedComponent starts a new SkipList once the flushed one has been written to the next level.
+// The log files holding the flushed records are shared by all the column families, they are removed by the LSM Tree once the
+// memory levels of all of them have been flushed
 func (L *MemoryLevel) RemoveFlushedComponent() error {
-	if L.fileNameToDelete == "" {
-		return errors.New("no file to delete")
-	}
-
-	if err := os.Remove(path.Join(L.GetPath(), L.fileNameToDelete) + shared.SkipListExtension); err != nil {
-		return err
-	}
-
-	L.fileNameToDelete = ""
-
+	L.skipList = skip_list.NewSkipList()
+	L.rangeTombstones = make([]shared.RangeTombstone, 0)
 	return nil
 }
 
 // AsArray returns the key-value pairs in the level as a byte slice
-// This will concatenate all the key-value pairs in the SkipList calling the KeyValueToByte() method on each key-value pair
+// This will concatenate all the records (key-value pairs and merge operands) in the SkipList calling the ToByte() method on each record
 func (L *MemoryLevel) AsArray() []byte {
 	buf := make([]byte, 0)
 	current := L.skipList.GetHead()
 	for current != nil {
-		buf = append(buf, shared.KeyValueToByte(current.GetKey(), current.GetValue())...)
+		buf = append(buf, current.GetRecord().ToByte()...)
 		current = current.GetNext()[0]
 	}
 
 	return buf
 }
 
-// Get returns the value of the key
-func (L *MemoryLevel) Get(key shared.KeyType) (*shared.ValueType, string, error) {
-	value, err := L.skipList.Get(key)
-	return value, path.Join(L.GetPath(), L.currentFileName), err
+// Get returns the value of the key and the merge operands (from the most recent to the oldest) inserted after it, as seen by the snapshot
+// If the level only holds merge operands for the key, the value is nil and the operands have to be applied to the value of an older level.
+// If the key is deleted by a range tombstone of the level, a tombstone value is returned.
+func (L *MemoryLevel) Get(key shared.KeyType, snapshot shared.SequenceNumberType) (Lookup, error) {
+	nodes := L.skipList.GetVersions(key)
+	versions := make([]shared.Record, 0, len(nodes))
+	for _, node := range nodes {
+		versions = append(versions, node.GetRecord())
+	}
+
+	lookup, found := resolve(versions, L.rangeTombstones, key, snapshot, L.GetPath())
+	if !found {
+		return Lookup{}, shared.KeyNotFoundError
+	}
+	return lookup, nil
 }
 
 // IsFull returns true if the level is full
@@ -96,88 +103,69 @@ func (L *MemoryLevel) IsFull() bool {
 	return L.GetCount() >= L.GetMaxCount()
 }
 
-// Close closes the log file
+// Close does nothing, the log is closed by the LSM Tree
 func (L *MemoryLevel) Close() error {
-	if L.osFile != nil {
-		if err := L.osFile.Close(); err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
-// Load loads the key-value pairs from the log file into the SkipList
-// If the log file does not exist, it creates a new one.
-// If the log file exists, it reads the key-value pairs from the file and inserts them into the SkipList that is stored in the MemoryLevel
+// Load does nothing, the records of the log are replayed into the memory levels of all the column families by the LSM Tree
+// (see WriteAheadLog.Open)
 func (L *MemoryLevel) Load() error {
-	// Scan the directory for the cache file
-	files, err := os.ReadDir(L.GetPath())
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	// If there is no cache file, create one
-	if len(files) == 0 {
-		L.currentFileName = shared.RandomString(32) // To change to more reliable name
-		osFile, err := os.Create(path.Join(L.GetPath(), L.currentFileName) + shared.SkipListExtension)
-		if err != nil {
-			return err
-		}
-		L.osFile = osFile
-		L.skipList = skip_list.NewSkipList()
-		return nil
-	}
+// InitializeStorage does nothing, the memory level is only stored in the log
+func (L *MemoryLevel) InitializeStorage() error {
+	return nil
+}
+
+// Insert inserts the key-value pair into the SkipList and the log file
+func (L *MemoryLevel) Insert(key shared.KeyType, value shared.ValueType, sequenceNumber shared.SequenceNumberType) error {
+	return L.write([]shared.Record{{Key: key, Value: value, Kind: shared.KindValue, SequenceNumber: sequenceNumber}})
+}
 
-	// Load from the cache file
-	osFile, err := os.Open(path.Join(L.GetPath(), files[0].Name()))
+// InsertWithExpiry inserts the key-value pair, which expires at expiresAt, into the SkipList and the log file
+func (L *MemoryLevel) InsertWithExpiry(key shared.KeyType, value shared.ValueType, expiresAt shared.TimestampType, sequenceNumber shared.SequenceNumberType) error {
+	return L.write([]shared.Record{{Key: key, Value: value, Kind: shared.KindValue, SequenceNumber: sequenceNumber, ExpiresAt: expiresAt}})
+}
+
+// Merge inserts a merge operand for the key into the SkipList and the log file
+func (L *MemoryLevel) Merge(key shared.KeyType, operand shared.ValueType, sequenceNumber shared.SequenceNumberType) error {
+	return L.write([]shared.Record{{Key: key, Value: operand, Kind: shared.KindMerge, SequenceNumber: sequenceNumber}})
+}
+
+// DeleteRange inserts a range tombstone deleting the keys in [start, end) into the level and the log file
+func (L *MemoryLevel) DeleteRange(start shared.KeyType, end shared.KeyType, sequenceNumber shared.SequenceNumberType) error {
+	rangeTombstone := shared.RangeTombstone{Start: start, End: end, SequenceNumber: sequenceNumber}
+	record, err := shared.ByteToRecord(rangeTombstone.ToByte())
 	if err != nil {
 		return err
 	}
-	L.osFile = osFile
-
-	// Read the cache file
-	buf := make([]byte, shared.BlockSize)
-	for {
-		n, err := L.osFile.Read(buf)
-		if err != nil {
-			break
-		}
+	return L.write([]shared.Record{record})
+}
 
-		for i := uint64(0); i < uint64(n); i += shared.BlockSize {
-			key, value, err := shared.ByteToKeyValue(buf[i : i+shared.BlockSize])
-			if err != nil {
-				return err
-			}
-			err = L.skipList.Insert(key, value)
-			if err != nil {
-				return err
-			}
-		}
+// WriteBatch inserts the records into the level and the log as a single atomic batch:
+// after a crash, either all of them or none of them are replayed from the log.
+func (L *MemoryLevel) WriteBatch(records []shared.Record) error {
+	if len(records) == 0 {
+		return nil
 	}
 
-	return nil
+	return L.write(records)
 }
 
-// InitializeStorage creates the directory for the memory level
-func (L *MemoryLevel) InitializeStorage() error {
-	if err := os.MkdirAll(path.Join(L.GetPath()), 0755); err != nil {
-		return err
+// write writes the records to the log in a single write and then applies them to the level
+func (L *MemoryLevel) write(records []shared.Record) error {
+	entries := make([]LogEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, LogEntry{FamilyID: L.familyID, Record: record})
 	}
 
-	return nil
-}
-
-// Insert inserts the key-value pair into the SkipList and the log file
-func (L *MemoryLevel) Insert(key shared.KeyType, value shared.ValueType) error {
-	err := L.skipList.Insert(key, value)
-	if err != nil {
+	if err := L.log.Append(entries); err != nil {
 		return err
 	}
 
-	// Write the key-value pair to the cache file
-	_, err = L.osFile.Write(shared.KeyValueToByte(key, value))
-	if err != nil {
+	if err := L.apply(records); err != nil {
 		return err
 	}
 
@@ -188,36 +176,54 @@ func (L *MemoryLevel) Insert(key shared.KeyType, value shared.ValueType) error {
 	return nil
 }
 
-// FlushFirstComponent flushes the first component of the level
-// 1. It closes the log file
-// 2. It creates a new logs file for the new SkipList that will be created
-// 3. It returns the key-value pairs in the old SkipList, the minKey and maxKey of the old SkipList
-func (L *MemoryLevel) FlushFirstComponent() ([]byte, uint64, uint64, error) {
-	data := L.AsArray()
-	oldSkipList := L.skipList
-	L.fileNameToDelete = L.currentFileName
+// apply inserts the records into the SkipList, or into the range tombstones of the level
+func (L *MemoryLevel) apply(records []shared.Record) error {
+	for _, record := range records {
+		L.maxSequenceNumber = max(L.maxSequenceNumber, record.SequenceNumber)
 
-	// Close the cache file
-	if err := L.osFile.Close(); err != nil {
-		return nil, 0, 0, err
+		if record.Kind == shared.KindRangeTombstone {
+			L.rangeTombstones = append(L.rangeTombstones, shared.RangeTombstone{Start: record.Key, End: record.Value, SequenceNumber: record.SequenceNumber})
+			continue
+		}
+		if err := L.skipList.InsertRecord(record); err != nil {
+			return err
+		}
 	}
 
-	// Create the cache file
-	L.currentFileName = shared.RandomString(32) // To change to more reliable name
-	osFile, err := os.Create(path.Join(L.GetPath(), L.currentFileName) + shared.SkipListExtension)
-	if err != nil {
-		return nil, 0, 0, err
-	}
-	L.osFile = osFile
+	return nil
+}
 
-	L.skipList = skip_list.NewSkipList()
+// FlushFirstComponent flushes the first component of the level
+// It returns an iterator over the key-value pairs and range tombstones in the SkipList and the minKey and maxKey of the SkipList,
+// which is kept until RemoveFlushedComponent so that its records are not lost if the flush fails. The log is rotated by the LSM Tree.
+func (L *MemoryLevel) FlushFirstComponent() (ss_table.Iterator, uint64, uint64, error) {
+	data := L.AsArray()
+	rangeTombstones := shared.SortRangeTombstones(L.rangeTombstones)
+	minKey, maxKey := L.getKeyRange()
 
-	return data, oldSkipList.GetHead().GetKey(), oldSkipList.GetTail().GetKey(), nil
+	return ss_table.NewSliceIterator(data, rangeTombstones), minKey, maxKey, nil
+}
+
+// getKeyRange returns the minimum and maximum keys of the level, including the keys covered by the range tombstones
+func (L *MemoryLevel) getKeyRange() (shared.KeyType, shared.KeyType) {
+	minKey, maxKey := shared.KeyType(math.MaxUint64), shared.KeyType(0)
+	if L.skipList.GetCount() > 0 {
+		minKey, maxKey = L.skipList.GetHead().GetKey(), L.skipList.GetTail().GetKey()
+	}
+	for _, rangeTombstone := range L.rangeTombstones {
+		minKey = min(minKey, rangeTombstone.Start)
+		maxKey = max(maxKey, rangeTombstone.End-1)
+	}
+	return minKey, maxKey
 }
 
-func NewMemoryLevel(index uint64) *MemoryLevel {
+// NewMemoryLevel creates the memory level of the column family familyID, its records are written to log
+func NewMemoryLevel(log *WriteAheadLog, familyID ColumnFamilyIDType, index uint64) *MemoryLevel {
 	return &MemoryLevel{
-		index:    index,
-		skipList: skip_list.NewSkipList(),
+		log:             log,
+		familyID:        familyID,
+		index:           index,
+		skipList:        skip_list.NewSkipList(),
+		rangeTombstones: make([]shared.RangeTombstone, 0),
 	}
 }
@@ -0,0 +1,91 @@
+package lsm_tree
+
+import "dmds_lab2/shared"
+
+// MergeOperator folds the merge operands written with LSMTree.Merge, ColumnFamily.Merge or WriteBatch.Merge into the value of a key.
+// The operands are stored as merge records and are only folded when the key is read (Get) or compacted.
+type MergeOperator interface {
+	// FullMerge applies the operands, ordered from the oldest to the most recent, to the existing value of the key.
+	// existingValue is nil if the key does not exist or has been deleted.
+	FullMerge(key shared.KeyType, existingValue *shared.ValueType, operands []shared.ValueType) (shared.ValueType, error)
+
+	// PartialMerge combines two operands, leftOperand being the oldest, into a single operand.
+	// It returns false if the operands cannot be combined without knowing the existing value of the key.
+	PartialMerge(key shared.KeyType, leftOperand shared.ValueType, rightOperand shared.ValueType) (shared.ValueType, bool)
+}
+
+// AssociativeMergeOperator is a MergeOperator built from an associative merge function:
+// merging two operands gives an operand that has the same effect as applying both of them.
+type AssociativeMergeOperator struct {
+	merge func(key shared.KeyType, existingValue *shared.ValueType, operand shared.ValueType) shared.ValueType
+}
+
+// FullMerge applies the merge function to the existing value and each operand in order.
+func (op *AssociativeMergeOperator) FullMerge(key shared.KeyType, existingValue *shared.ValueType, operands []shared.ValueType) (shared.ValueType, error) {
+	for _, operand := range operands {
+		value := op.merge(key, existingValue, operand)
+		existingValue = &value
+	}
+
+	if existingValue == nil {
+		return 0, shared.KeyNotFoundError
+	}
+	return *existingValue, nil
+}
+
+// PartialMerge always succeeds since the merge function is associative.
+func (op *AssociativeMergeOperator) PartialMerge(key shared.KeyType, leftOperand shared.ValueType, rightOperand shared.ValueType) (shared.ValueType, bool) {
+	return op.merge(key, &leftOperand, rightOperand), true
+}
+
+// NewAssociativeMergeOperator creates a MergeOperator from an associative merge function.
+func NewAssociativeMergeOperator(merge func(key shared.KeyType, existingValue *shared.ValueType, operand shared.ValueType) shared.ValueType) *AssociativeMergeOperator {
+	return &AssociativeMergeOperator{merge: merge}
+}
+
+// NewAddMergeOperator creates a merge operator for counters: the operands are added to the value (0 if the key does not exist).
+func NewAddMergeOperator() *AssociativeMergeOperator {
+	return NewAssociativeMergeOperator(func(key shared.KeyType, existingValue *shared.ValueType, operand shared.ValueType) shared.ValueType {
+		if existingValue == nil {
+			return operand
+		}
+		return *existingValue + operand
+	})
+}
+
+// fullMerge applies the operands, ordered from the most recent to the oldest as they are found when going through
+// the levels, to the existing value. A tombstone is treated as a missing value.
+func fullMerge(mergeOperator MergeOperator, key shared.KeyType, existingValue *shared.ValueType, operands []shared.ValueType) (shared.ValueType, error) {
+	if mergeOperator == nil {
+		return 0, shared.MergeOperatorNotSetError
+	}
+
+	if existingValue != nil && shared.IsTombstone(*existingValue) {
+		existingValue = nil
+	}
+
+	ordered := make([]shared.ValueType, len(operands))
+	for i, operand := range operands {
+		ordered[len(operands)-1-i] = operand
+	}
+
+	return mergeOperator.FullMerge(key, existingValue, ordered)
+}
+
+// partialMerge combines the operands, ordered from the most recent to the oldest, into a single operand.
+// It returns false if two of the operands cannot be combined.
+func partialMerge(mergeOperator MergeOperator, key shared.KeyType, operands []shared.ValueType) (shared.ValueType, bool) {
+	if mergeOperator == nil || len(operands) == 0 {
+		return 0, false
+	}
+
+	result := operands[len(operands)-1]
+	for i := len(operands) - 2; i >= 0; i-- {
+		var ok bool
+		result, ok = mergeOperator.PartialMerge(key, result, operands[i])
+		if !ok {
+			return 0, false
+		}
+	}
+	return result, true
+}
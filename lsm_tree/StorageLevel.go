@@ -1,6 +1,7 @@
 package lsm_tree
 
 import (
+	"dmds_lab2/block_cache"
 	"dmds_lab2/shared"
 	"dmds_lab2/ss_table"
 	"errors"
@@ -12,17 +13,60 @@ import (
 
 // StorageLevel represents a storage level in the LSM Tree, it contains a list of SSTables
 type StorageLevel struct {
-	index            uint64                       // Index of the storage level
-	count            uint64                       // Number of key-value pairs in the storage level
-	ssTables         map[string]*ss_table.SSTable // List of SSTables in the storage level
-	ssTablesOrdered  []string
-	ssTablesToRemove []string
+	rootDirectory     string                       // Directory where the levels of the LSM Tree are stored
+	index             uint64                       // Index of the storage level
+	count             uint64                       // Number of key-value pairs and range tombstones in the storage level
+	ssTables          map[string]*ss_table.SSTable // List of SSTables in the storage level
+	ssTablesOrdered   []string
+	ssTablesToRemove  []string
+	blockCache        *block_cache.BlockCache // Block cache shared by the SSTables of all the storage levels
+	tableCache        *ss_table.TableCache    // Table cache opening the SSTable files, shared by all the storage levels
+	readMode          ss_table.ReadMode       // How the records of the SSTables are read (see ss_table.ReadMode)
+	searchMethod      ss_table.SearchMethod   // How the records of the SSTables are searched (see ss_table.SearchMethod)
+	learnedIndexError uint64                  // Maximum error of the learned index written in the SSTables, 0 to write none
+	compression       ss_table.Compression    // Codec of the data blocks of the SSTables written
+	keyEncoding       ss_table.KeyEncoding    // Encoding of the records in the data blocks of the SSTables written
+}
+
+// SetKeyEncoding sets the encoding of the records in the data blocks of the new SSTables of the storage level (see ss_table.KeyEncoding)
+func (L *StorageLevel) SetKeyEncoding(keyEncoding ss_table.KeyEncoding) {
+	L.keyEncoding = keyEncoding
+}
+
+// SetCompression sets the codec of the data blocks of the new SSTables of the storage level (see ss_table.Compression)
+func (L *StorageLevel) SetCompression(compression ss_table.Compression) {
+	L.compression = compression
+}
+
+// SetLearnedIndexError sets the maximum error of the learned index written in the new SSTables of the storage level (see ss_table.LearnedIndex),
+// 0 to write none
+func (L *StorageLevel) SetLearnedIndexError(epsilon uint64) {
+	L.learnedIndexError = epsilon
+}
+
+// SetSearchMethod sets how the records of the SSTables of the storage level are searched, it must be called before Load
+func (L *StorageLevel) SetSearchMethod(searchMethod ss_table.SearchMethod) {
+	L.searchMethod = searchMethod
+}
+
+// SetReadMode sets how the records of the SSTables of the storage level are read, it must be called before Load
+func (L *StorageLevel) SetReadMode(readMode ss_table.ReadMode) {
+	L.readMode = readMode
+}
+
+// SetBlockCache sets the block cache of the SSTables of the storage level, including the ones added later
+func (L *StorageLevel) SetBlockCache(blockCache *block_cache.BlockCache) {
+	L.blockCache = blockCache
+	for _, ssTable := range L.ssTables {
+		ssTable.SetBlockCache(blockCache)
+	}
 }
 
 func (L *StorageLevel) addSSTable(sst *ss_table.SSTable, filename string) {
+	sst.SetBlockCache(L.blockCache)
 	L.ssTables[filename] = sst
 	L.ssTablesOrdered = append(L.ssTablesOrdered, filename)
-	L.count += uint64(len(sst.GetData())) / shared.BlockSize
+	L.count += sst.GetRecordCount() + uint64(len(sst.GetRangeTombstones()))
 }
 
 func (L *StorageLevel) removeSSTable(filename string) error {
@@ -31,15 +75,12 @@ func (L *StorageLevel) removeSSTable(filename string) error {
 		return errors.New("SSTable not found")
 	}
 
-	if err := ssTable.Close(); err != nil {
-		return err
-	}
-
-	if err := ssTable.Delete(); err != nil {
+	// The file is only deleted once the SSTable is not read anymore
+	if err := L.tableCache.Delete(ssTable); err != nil {
 		return err
 	}
 
-	L.count -= uint64(len(L.ssTables[filename].GetData())) / shared.BlockSize
+	L.count -= ssTable.GetRecordCount() + uint64(len(ssTable.GetRangeTombstones()))
 
 	delete(L.ssTables, filename)
 
@@ -56,7 +97,7 @@ func (L *StorageLevel) removeSSTable(filename string) error {
 
 // GetPath returns the path of the storage level where the SSTables are stored
 func (L *StorageLevel) GetPath() string {
-	return path.Join(shared.SSTablesRootDirectory, strconv.Itoa(int(L.GetIndex())))
+	return path.Join(L.rootDirectory, strconv.Itoa(int(L.GetIndex())))
 }
 
 // GetCount returns the number of key-value pairs in the storage level
@@ -103,7 +144,7 @@ func (L *StorageLevel) Add(sstInt interface{}) error {
 		return err
 	}
 
-	fileName := strconv.FormatUint(meta.GetMinKey(), 10) + "_" + strconv.FormatUint(meta.GetMaxKey(), 10) + "_" + shared.RandomString(32) + shared.SSTableExtension
+	fileName := meta.GetFileName()
 	sst.SetPath(path.Join(L.GetPath(), fileName))
 
 	L.addSSTable(sst, fileName)
@@ -113,10 +154,12 @@ func (L *StorageLevel) Add(sstInt interface{}) error {
 	}
 
 	if err := sst.Write(); err != nil {
+		_ = sst.Close()
 		return err
 	}
 
-	return nil
+	// The file is opened again through the table cache when it is read
+	return sst.Close()
 }
 
 // Remove removes an SSTable from the storage level
@@ -144,11 +187,13 @@ func (L *StorageLevel) AsArray() []byte {
 	return buf
 }
 
-// Close closes all the SSTables in the storage level
+// Close closes the files of the SSTables in the storage level that are open in the table cache, and unmaps the mapped ones
 func (L *StorageLevel) Close() error {
 	for _, ssTable := range L.ssTables {
-		err := ssTable.Close()
-		if err != nil {
+		if err := L.tableCache.Evict(ssTable); err != nil {
+			return err
+		}
+		if err := ssTable.Unmap(); err != nil {
 			return err
 		}
 	}
@@ -157,8 +202,9 @@ func (L *StorageLevel) Close() error {
 
 // Load loads all the SSTables in the storage level
 // Since the SSTables files are prefixed with the minKey and maxKey, we can load them in order of the file names (TODO: make this more reliable).
-// This will load the metadata (minKey, maxKey), data and create the index (SkipList for each SSTable).
-// The SSTables files are closed after loading because we only need the metadata and index in memory.
+// This will load the metadata (minKey, maxKey) and, depending on the read mode, the data and create the index (SkipList for each SSTable).
+// Files that are not SSTables (e.g. temporary files left by an interrupted compaction) are ignored, and the SSTables written in a
+// legacy format are migrated to the current one first (see ss_table.Migrate).
 func (L *StorageLevel) Load() error {
 	// Scan the directory for SSTables
 	files, err := os.ReadDir(L.GetPath())
@@ -167,82 +213,144 @@ func (L *StorageLevel) Load() error {
 	}
 
 	for _, file := range files {
-		if file.IsDir() {
+		if file.IsDir() || path.Ext(file.Name()) != shared.SSTableExtension {
 			continue
 		}
 
-		ssTable := ss_table.NewSSTable()
-		ssTable.SetPath(path.Join(L.GetPath(), file.Name()))
-		if err := ssTable.Open(); err != nil {
+		// The files written in a legacy format are rewritten in the current one
+		if _, err := ss_table.Migrate(path.Join(L.GetPath(), file.Name())); err != nil {
 			return err
 		}
-
-		if err = ssTable.LoadMetadata(); err != nil {
+		if err := L.loadSSTable(file.Name()); err != nil {
 			return err
 		}
+	}
 
-		if err = ssTable.LoadDataToMemory(); err != nil {
-			return err
-		}
+	return nil
+}
 
-		if err = ssTable.CreateIndex(); err != nil {
-			return err
-		}
+// loadSSTable loads an SSTable file of the storage level according to the read mode (see SSTable.Load) and adds it to the level.
+// The SSTable file is opened through the table cache, which may close it after loading: the records are either in memory, mapped,
+// or read by opening the file again.
+func (L *StorageLevel) loadSSTable(fileName string) error {
+	ssTable := ss_table.NewSSTable()
+	ssTable.SetPath(path.Join(L.GetPath(), fileName))
+	ssTable.SetReadMode(L.readMode)
+	ssTable.SetSearchMethod(L.searchMethod)
+	if err := L.tableCache.Acquire(ssTable); err != nil {
+		return err
+	}
+	if err := ssTable.Load(); err != nil {
+		_ = L.tableCache.Release(ssTable)
+		return err
+	}
+	if err := L.tableCache.Release(ssTable); err != nil {
+		return err
+	}
 
-		if err = ssTable.CreateBloomFilter(shared.HashFunctions); err != nil {
-			return err
-		}
+	L.addSSTable(ssTable, fileName)
+	return nil
+}
 
-		if err = ssTable.Close(); err != nil {
-			return err
+// Overlaps returns true if the key range [minKey, maxKey] overlaps the key range of one of the SSTables of the storage level
+// i.e. the storage level may hold a value (or tombstone) for a key of the range.
+func (L *StorageLevel) Overlaps(minKey shared.KeyType, maxKey shared.KeyType) bool {
+	for _, ssTable := range L.ssTables {
+		metadata, err := ssTable.GetMetadata()
+		if err != nil {
+			return true
+		}
+		if minKey <= metadata.GetMaxKey() && metadata.GetMinKey() <= maxKey {
+			return true
 		}
-
-		L.addSSTable(ssTable, file.Name())
 	}
-
-	return nil
+	return false
 }
 
-// Get returns the value of the key
-// This will iterate through the SSTables in the storage level and call the Get() method on each if the key is within the range of the SSTable
-func (L *StorageLevel) Get(key shared.KeyType) (*shared.ValueType, string, error) {
+// Get returns the value of the key as seen by the snapshot
+// This will iterate through the SSTables in the storage level and resolve the versions of the key (see SSTable.GetVersions) in the SSTable
+// whose range holds the key, along with its range tombstones
+// The SSTable is acquired through the table cache while it is read, so that its file is open if needed and not deleted meanwhile
+// If the SSTable only holds merge operands for the key, the value is nil and the operands are returned to be applied to the value of an older level.
+func (L *StorageLevel) Get(key shared.KeyType, snapshot shared.SequenceNumberType) (Lookup, error) {
 	for _, ssTable := range L.ssTables {
 		metadata, err := ssTable.GetMetadata()
 		if err != nil {
-			return nil, "", err
+			return Lookup{}, err
 		}
 		if metadata.GetMinKey() <= key && metadata.GetMaxKey() >= key {
-			value, err := ssTable.Get(key)
-			return value, ssTable.GetPath(), err
+			if err := L.tableCache.Acquire(ssTable); err != nil {
+				return Lookup{}, err
+			}
+			versions, err := ssTable.GetVersions(key)
+			if releaseErr := L.tableCache.Release(ssTable); err == nil {
+				err = releaseErr
+			}
+			if err != nil {
+				return Lookup{}, err
+			}
+			if lookup, found := resolve(versions, ssTable.GetRangeTombstones(), key, snapshot, ssTable.GetPath()); found {
+				return lookup, nil
+			}
 		}
 	}
-	return nil, "", shared.KeyNotFoundError
+	return Lookup{}, shared.KeyNotFoundError
+}
+
+// GetMaxSequenceNumber returns the greatest sequence number of the SSTables in the storage level
+func (L *StorageLevel) GetMaxSequenceNumber() shared.SequenceNumberType {
+	maxSequenceNumber := shared.SequenceNumberType(0)
+	for _, ssTable := range L.ssTables {
+		if metadata, err := ssTable.GetMetadata(); err == nil {
+			maxSequenceNumber = max(maxSequenceNumber, metadata.GetMaxSequenceNumber())
+		}
+	}
+	return maxSequenceNumber
 }
 
 // FlushFirstComponent flushes the first component of the storage level
-// This will return the data of the first SSTable in the storage level and the minKey and maxKey of the SSTable
-func (L *StorageLevel) FlushFirstComponent() ([]byte, shared.KeyType, shared.KeyType, error) {
+// This will return an iterator over the data of the first SSTable in the storage level and the minKey and maxKey of the SSTable
+func (L *StorageLevel) FlushFirstComponent() (ss_table.Iterator, shared.KeyType, shared.KeyType, error) {
 	ssTable := L.ssTables[L.ssTablesOrdered[0]]
 	meta, err := ssTable.GetMetadata()
 	if err != nil {
 		return nil, 0, 0, err
 	}
+	iterator, err := L.tableCache.NewIterator(ssTable)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 	L.ssTablesToRemove = append(L.ssTablesToRemove, L.ssTablesOrdered[0])
-	return ssTable.GetData(), meta.GetMinKey(), meta.GetMaxKey(), nil
+	return iterator, meta.GetMinKey(), meta.GetMaxKey(), nil
+}
+
+// CompactionOptions is what a compaction needs to know about the rest of the LSM Tree.
+type CompactionOptions struct {
+	OlderLevels    []*StorageLevel           // Levels below the one the data is inserted into, from the most recent to the oldest
+	MergeOperator  MergeOperator             // Merge operator folding the merge operands, may be nil
+	OldestSnapshot shared.SequenceNumberType // Oldest snapshot still in use, the versions written after it are kept
+	Now            shared.TimestampType      // Current time, the values expired at that time are deleted
 }
 
 // InsertFlushedData inserts the flushed data to the storage level
-// The data, minKey and maxKey parameter is coming from a higher level (memory or storage) that has been flushed
+// The data iterator, minKey and maxKey parameter is coming from a higher level (memory or storage) that has been flushed
 // 1. Find the SSTables in the current storage level that overlap with the flushed data
-// 2. Merge the flushed data with the SSTables that overlap with the flushed data according to the minKey and maxKey using K-way merge algorithm
-// 3. Create new SSTables with the merged data
+// 2. Merge the flushed data with the SSTables that overlap with the flushed data according to the minKey and maxKey
+// using a streaming K-way merge (see MergeIterator) which keeps the most recent value of each key and folds the merge operands
+// The versions written after the oldest snapshot still in use are kept so that the snapshots can still read them
+// The values expired at options.Now are deleted like tombstones
+// The keys covered by a range tombstone of the flushed data are removed from the SSTables of the current level
+// A tombstone (point or range) is only removed if none of the older levels (the levels below this one) has an SSTable whose key range
+// overlaps the tombstoned keys, so this is always the case at the bottommost level (options.OlderLevels is empty)
+// 3. Stream the merged data to new SSTables, a new SSTable is started every time the component size (FirstLevelMaxSize) is reached
+// and the range tombstones are fragmented at the boundaries of the new SSTables
 // 4. Remove the old SSTables in the current storage level that has been merged
-// 5. Add N (Replace the old SSTables) new SSTables to the current storage level, where N slices of the merged data are created to fit the component size (FirstLevelMaxSize)
+// Only one record per merged SSTable is held in memory, so the memory used does not depend on the size of the level.
 // Since we are using the Partitioning Policy, the higher level loop will check if the storage level is full and flush the first component to the next level etc.
-func (L *StorageLevel) InsertFlushedData(data []byte, minKey shared.KeyType, maxKey shared.KeyType) error {
-	// Take 2 first parts and merge them
-	dataToMerge := make([][]byte, 0)
-	dataToMerge = append(dataToMerge, data)
+func (L *StorageLevel) InsertFlushedData(data ss_table.Iterator, minKey shared.KeyType, maxKey shared.KeyType, options CompactionOptions) error {
+	// The flushed data is more recent than the data of the current level, so it comes first
+	iterators := make([]ss_table.Iterator, 0)
+	iterators = append(iterators, data)
 
 	// Find the SSTables that overlap with the flushed data
 	for _, ssTableFileName := range L.ssTablesOrdered {
@@ -251,56 +359,76 @@ func (L *StorageLevel) InsertFlushedData(data []byte, minKey shared.KeyType, max
 		// Check the overlap
 		metadata, err := ssTable.GetMetadata()
 		if err != nil {
+			_ = closeIterators(iterators)
 			return err
 		}
 
 		// If there is an overlap, merge the data
 		if minKey <= metadata.GetMaxKey() && metadata.GetMinKey() <= maxKey {
+			iterator, err := L.tableCache.NewIterator(ssTable)
+			if err != nil {
+				_ = closeIterators(iterators)
+				return err
+			}
 			L.ssTablesToRemove = append(L.ssTablesToRemove, ssTableFileName)
-			dataToMerge = append(dataToMerge, ssTable.GetData())
+			iterators = append(iterators, iterator)
 		}
 	}
 
-	// Remove duplicates
-	dataToMerge = MergeDuplicatedKeys(dataToMerge)
-
-	// Remove the tombstones from the data
-	dataWithoutTombstones, err := RemoveTombstones(dataToMerge)
-	if err != nil {
-		return err
+	canDropTombstone := func(minKey shared.KeyType, maxKey shared.KeyType) bool {
+		for _, level := range options.OlderLevels {
+			if level.Overlaps(minKey, maxKey) {
+				return false
+			}
+		}
+		return true
 	}
 
-	// Merge the data
-	data, err = MergeSortedArray(dataWithoutTombstones)
-	if err != nil {
-		return err
+	getOlder := func(key shared.KeyType) (*shared.ValueType, []shared.ValueType, error) {
+		levels := make([]Level, 0, len(options.OlderLevels))
+		for _, level := range options.OlderLevels {
+			levels = append(levels, level)
+		}
+		result, _, err := lookup(levels, key, options.OldestSnapshot)
+		if err == nil && result.Value != nil && shared.IsExpired(result.ExpiresAt, options.Now) {
+			result.Value = nil
+		}
+		return result.Value, result.Operands, err
 	}
 
-	// Create new SSTables with the merged data, since we are using the Partitioning Policy, we will create N new SSTables
+	merged := NewMergeIterator(iterators, canDropTombstone)
+	merged.SetMergeOperator(options.MergeOperator, getOlder)
+	merged.SetOldestSnapshot(options.OldestSnapshot)
+	merged.SetExpiryTime(options.Now)
+	defer merged.Close()
+
+	// Stream the merged data to new SSTables, since we are using the Partitioning Policy, we will create N new SSTables
 	// which fits the component size (FirstLevelMaxSize).
 	// The higher level loop will check is the storage level is full and flush the first component to the next level etc.
-	for startIndex := uint64(0); startIndex < uint64(len(data)); startIndex += shared.FirstLevelMaxSize * shared.BlockSize {
-		endIndex := min(startIndex+shared.FirstLevelMaxSize*shared.BlockSize, uint64(len(data)))
-		currentMinKey, err := shared.ByteToKey(data[startIndex : startIndex+shared.KeySize])
-		if err != nil {
-			return err
-		}
-		currentMaxKey, err := shared.ByteToKey(data[endIndex-shared.BlockSize : endIndex-shared.BlockSize+shared.KeySize])
-		if err != nil {
+	writer := ss_table.NewWriter(L.GetPath(), shared.FirstLevelMaxSize*shared.BlockSize)
+	writer.SetRangeTombstones(merged.RangeTombstones())
+	writer.SetLearnedIndexError(L.learnedIndexError)
+	writer.SetCompression(L.compression)
+	writer.SetKeyEncoding(L.keyEncoding)
+	for merged.Next() {
+		if err := writer.Write(merged.Record()); err != nil {
+			_ = writer.Abort()
 			return err
 		}
+	}
+	if err := merged.Error(); err != nil {
+		_ = writer.Abort()
+		return err
+	}
 
-		meta := ss_table.NewMetadata(currentMinKey, currentMaxKey)
-		ssTable := ss_table.NewSSTable()
-		ssTable.SetData(data[startIndex:endIndex])
-		ssTable.SetMetadata(meta)
-		if err := ssTable.CreateIndex(); err != nil {
-			return err
-		}
-		if err := ssTable.CreateBloomFilter(shared.HashFunctions); err != nil {
-			return err
-		}
-		if err := L.Add(ssTable); err != nil {
+	paths, err := writer.Close()
+	if err != nil {
+		_ = writer.Abort()
+		return err
+	}
+
+	for _, filePath := range paths {
+		if err := L.loadSSTable(path.Base(filePath)); err != nil {
 			return err
 		}
 	}
@@ -316,11 +444,14 @@ func (L *StorageLevel) InitializeStorage() error {
 	return nil
 }
 
-func NewStorageLevel(index uint64) *StorageLevel {
+// NewStorageLevel creates the storage level index stored in rootDirectory, its SSTable files are opened through tableCache
+func NewStorageLevel(rootDirectory string, index uint64, tableCache *ss_table.TableCache) *StorageLevel {
 	return &StorageLevel{
+		rootDirectory:   rootDirectory,
 		index:           index,
 		count:           0,
 		ssTables:        make(map[string]*ss_table.SSTable),
 		ssTablesOrdered: make([]string, 0),
+		tableCache:      tableCache,
 	}
 }
@@ -0,0 +1,175 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"sort"
+)
+
+// TransactionMode is the concurrency control used by a transaction.
+type TransactionMode int
+
+const (
+	// OptimisticTransaction detects the conflicts when committing: the commit fails if one of the keys written by
+	// the transaction has been written by someone else after its snapshot.
+	OptimisticTransaction TransactionMode = iota
+	// PessimisticTransaction locks the keys when writing them, so two transactions never write the same key at the same
+	// time. Waiting for a lock fails with DeadlockError if it would create a deadlock.
+	PessimisticTransaction
+)
+
+// Transaction groups reads and writes of several keys. The reads see the LSM Tree as it was when the transaction began
+// (snapshot isolation) along with the writes of the transaction, and the writes are applied atomically on Commit.
+// A transaction must not be used by several goroutines at the same time.
+type Transaction struct {
+	tree     *LSMTree
+	id       uint64
+	mode     TransactionMode
+	snapshot shared.SequenceNumberType
+	writes   map[shared.KeyType]shared.ValueType // Values written by the transaction, TombstoneValue for the deleted keys
+	closed   bool
+}
+
+// Begin starts a transaction reading the LSM Tree as of the last write.
+func (L *LSMTree) Begin(mode TransactionMode) *Transaction {
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	L.lastTransactionID++
+	return &Transaction{
+		tree:     L,
+		id:       L.lastTransactionID,
+		mode:     mode,
+		snapshot: L.acquireSnapshot(),
+		writes:   make(map[shared.KeyType]shared.ValueType),
+	}
+}
+
+// Get returns the value of the key written by the transaction or, if it has not written it, the value of the key in its snapshot.
+func (tx *Transaction) Get(key shared.KeyType) (*shared.ValueType, error) {
+	if tx.closed {
+		return nil, shared.TransactionClosedError
+	}
+
+	if value, ok := tx.writes[key]; ok {
+		if shared.IsTombstone(value) {
+			return nil, shared.KeyTombstonedError
+		}
+		return &value, nil
+	}
+
+	tx.tree.mutex.RLock()
+	defer tx.tree.mutex.RUnlock()
+
+	value, _, _, err := tx.tree.defaultFamily.get(key, tx.snapshot)
+	return value, err
+}
+
+// Put writes the key-value pair in the transaction, it is only visible to the other readers once committed.
+func (tx *Transaction) Put(key shared.KeyType, value shared.ValueType) error {
+	return tx.write(key, value)
+}
+
+// Delete deletes the key in the transaction, it is only visible to the other readers once committed.
+func (tx *Transaction) Delete(key shared.KeyType) error {
+	return tx.write(key, shared.TombstoneValue)
+}
+
+// write buffers the write of the key, a pessimistic transaction locks the key first and checks that it has not been
+// written after its snapshot. If an error is returned, the transaction should be rolled back.
+func (tx *Transaction) write(key shared.KeyType, value shared.ValueType) error {
+	if tx.closed {
+		return shared.TransactionClosedError
+	}
+
+	if tx.mode == PessimisticTransaction {
+		if err := tx.tree.locks.lock(tx.id, key); err != nil {
+			return err
+		}
+
+		tx.tree.mutex.RLock()
+		err := tx.checkConflict(key)
+		tx.tree.mutex.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.writes[key] = value
+	return nil
+}
+
+// checkConflict returns TransactionConflictError if the key has been written after the snapshot of the transaction
+// or if it is locked by another transaction.
+func (tx *Transaction) checkConflict(key shared.KeyType) error {
+	if owner, locked := tx.tree.locks.owner(key); locked && owner != tx.id {
+		return shared.TransactionConflictError
+	}
+
+	sequenceNumber, err := tx.tree.defaultFamily.latestSequenceNumber(key)
+	if err != nil {
+		return err
+	}
+	if sequenceNumber > tx.snapshot {
+		return shared.TransactionConflictError
+	}
+	return nil
+}
+
+// Commit writes all the writes of the transaction to the LSM Tree as a single atomic batch, unless one of the keys has
+// been written by someone else after the snapshot of the transaction, in which case TransactionConflictError is returned.
+// The transaction is closed in both cases.
+func (tx *Transaction) Commit() error {
+	if tx.closed {
+		return shared.TransactionClosedError
+	}
+	defer tx.close()
+
+	L := tx.tree
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	keys := make([]shared.KeyType, 0, len(tx.writes))
+	for key := range tx.writes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	for _, key := range keys {
+		if err := tx.checkConflict(key); err != nil {
+			return err
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return L.write(L.defaultFamily, func(memoryLevel *MemoryLevel, sequenceNumber shared.SequenceNumberType) error {
+		records := make([]shared.Record, 0, len(keys))
+		for _, key := range keys {
+			records = append(records, shared.Record{Key: key, Value: tx.writes[key], Kind: shared.KindValue, SequenceNumber: sequenceNumber})
+		}
+		return memoryLevel.WriteBatch(records)
+	})
+}
+
+// Rollback discards the writes of the transaction and closes it.
+func (tx *Transaction) Rollback() error {
+	if tx.closed {
+		return shared.TransactionClosedError
+	}
+
+	tx.close()
+	return nil
+}
+
+// close releases the snapshot and the locks of the transaction.
+func (tx *Transaction) close() {
+	tx.tree.mutex.Lock()
+	tx.tree.releaseSnapshot(tx.snapshot)
+	tx.tree.mutex.Unlock()
+
+	tx.tree.locks.unlockAll(tx.id)
+	tx.writes = nil
+	tx.closed = true
+}
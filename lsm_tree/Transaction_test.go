@@ -0,0 +1,276 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestTransaction_SnapshotIsolation(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+
+	for key := shared.KeyType(0); key < 100; key++ {
+		if err := lsmTree.Insert(key, key); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	tx := lsmTree.Begin(OptimisticTransaction)
+
+	// Writes made after the snapshot, with enough data to compact the versions read by the transaction to the storage levels
+	if err := lsmTree.Delete(10); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := lsmTree.DeleteRange(20, 30); err != nil {
+		t.Fatalf("DeleteRange failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		for key := shared.KeyType(0); key < 100; key++ {
+			if key != 50 {
+				if err := lsmTree.Insert(key+shared.KeyType(i%2)*1_000, 1_000+key); err != nil {
+					t.Fatalf("Insert failed: %v", err)
+				}
+			}
+		}
+	}
+
+	for key := shared.KeyType(0); key < 100; key++ {
+		value, err := tx.Get(key)
+		if err != nil || *value != key {
+			t.Fatalf("transaction Get(%d) = %v, %v, expected %d", key, value, err, key)
+		}
+	}
+	if _, err := tx.Get(1_000); !errors.Is(err, shared.KeyNotFoundError) {
+		t.Fatalf("expected key 1000 not to be visible to the transaction, got %v", err)
+	}
+
+	// Read your own writes
+	if err := tx.Put(50, 5_000); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tx.Delete(51); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if value, err := tx.Get(50); err != nil || *value != 5_000 {
+		t.Fatalf("transaction Get(50) = %v, %v, expected 5000", value, err)
+	}
+	if _, err := tx.Get(51); !errors.Is(err, shared.KeyTombstonedError) {
+		t.Fatalf("expected key 51 to be deleted in the transaction, got %v", err)
+	}
+	if value, _, _, err := lsmTree.Get(50); err != nil || *value != 50 {
+		t.Fatalf("uncommitted write is visible: Get(50) = %v, %v", value, err)
+	}
+
+	// Key 51 has been written after the snapshot
+	if err := tx.Commit(); !errors.Is(err, shared.TransactionConflictError) {
+		t.Fatalf("expected TransactionConflictError, got %v", err)
+	}
+	if value, _, _, err := lsmTree.Get(50); err != nil || *value != 50 {
+		t.Fatalf("write of a conflicting transaction is visible: Get(50) = %v, %v", value, err)
+	}
+	if err := tx.Put(1, 1); !errors.Is(err, shared.TransactionClosedError) {
+		t.Fatalf("expected TransactionClosedError, got %v", err)
+	}
+
+	tx = lsmTree.Begin(OptimisticTransaction)
+	if err := tx.Put(50, 5_000); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tx.Delete(51); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if value, _, _, err := lsmTree.Get(50); err != nil || *value != 5_000 {
+		t.Fatalf("Get(50) = %v, %v, expected 5000", value, err)
+	}
+	if _, _, _, err := lsmTree.Get(51); !errors.Is(err, shared.KeyTombstonedError) {
+		t.Fatalf("expected key 51 to be deleted, got %v", err)
+	}
+}
+
+func TestTransaction_PessimisticDeadlock(t *testing.T) {
+	lsmTree := newTestLSMTree(t, t.TempDir())
+
+	tx1 := lsmTree.Begin(PessimisticTransaction)
+	tx2 := lsmTree.Begin(PessimisticTransaction)
+	if err := tx1.Put(1, 10); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tx2.Put(2, 20); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// tx2 waits for the lock of key 1 held by tx1
+	done := make(chan error)
+	go func() {
+		if err := tx2.Put(1, 21); err != nil {
+			done <- err
+			return
+		}
+		done <- tx2.Commit()
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("tx2 did not wait for the lock of key 1: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// tx1 waiting for the lock of key 2 held by tx2 would be a deadlock
+	if err := tx1.Put(2, 11); !errors.Is(err, shared.DeadlockError) {
+		t.Fatalf("expected DeadlockError, got %v", err)
+	}
+	if err := tx1.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("tx2 failed: %v", err)
+	}
+	for key, expected := range map[shared.KeyType]shared.ValueType{1: 21, 2: 20} {
+		if value, _, _, err := lsmTree.Get(key); err != nil || *value != expected {
+			t.Fatalf("Get(%d) = %v, %v, expected %d", key, value, err, expected)
+		}
+	}
+
+	// A key written after the snapshot cannot be locked
+	tx3 := lsmTree.Begin(PessimisticTransaction)
+	if err := lsmTree.Insert(3, 30); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := tx3.Put(3, 31); !errors.Is(err, shared.TransactionConflictError) {
+		t.Fatalf("expected TransactionConflictError, got %v", err)
+	}
+	if err := tx3.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+}
+
+func TestTransaction_ConcurrentTransfers(t *testing.T) {
+	for _, mode := range []TransactionMode{OptimisticTransaction, PessimisticTransaction} {
+		lsmTree := newTestLSMTree(t, t.TempDir())
+		const nAccounts = 10
+		const nWorkers = 8
+		const nTransfers = 30
+
+		for key := shared.KeyType(0); key < nAccounts; key++ {
+			if err := lsmTree.Insert(key, 100); err != nil {
+				t.Fatalf("Insert failed: %v", err)
+			}
+		}
+
+		// Each transfer moves 1 from an account to another, the total must not change
+		transfer := func(rng *rand.Rand) error {
+			from, to := shared.KeyType(rng.Intn(nAccounts)), shared.KeyType(rng.Intn(nAccounts))
+			tx := lsmTree.Begin(mode)
+			for _, step := range []struct {
+				key   shared.KeyType
+				delta int
+			}{{from, -1}, {to, 1}} {
+				value, err := tx.Get(step.key)
+				if err != nil {
+					_ = tx.Rollback()
+					return err
+				}
+				if err := tx.Put(step.key, shared.ValueType(int(*value)+step.delta)); err != nil {
+					_ = tx.Rollback()
+					return err
+				}
+			}
+			return tx.Commit()
+		}
+
+		errs := make(chan error, nWorkers)
+		for w := 0; w < nWorkers; w++ {
+			go func(w int) {
+				rng := rand.New(rand.NewSource(int64(w)))
+				for i := 0; i < nTransfers; i++ {
+					for {
+						err := transfer(rng)
+						if err == nil {
+							break
+						}
+						if !errors.Is(err, shared.TransactionConflictError) && !errors.Is(err, shared.DeadlockError) {
+							errs <- err
+							return
+						}
+					}
+				}
+				errs <- nil
+			}(w)
+		}
+		for w := 0; w < nWorkers; w++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("worker failed: %v", err)
+			}
+		}
+
+		total := shared.ValueType(0)
+		for key := shared.KeyType(0); key < nAccounts; key++ {
+			value, _, _, err := lsmTree.Get(key)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			total += *value
+		}
+		if total != nAccounts*100 {
+			t.Errorf("mode %d: expected a total of %d, got %d", mode, nAccounts*100, total)
+		}
+	}
+}
+
+func TestTransaction_BatchIsAtomicInLog(t *testing.T) {
+	rootDirectory := t.TempDir()
+	lsmTree := newTestLSMTree(t, rootDirectory)
+
+	tx := lsmTree.Begin(OptimisticTransaction)
+	if err := tx.Put(1, 10); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tx.Put(2, 20); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The committed batch is replayed from the log
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	for key, expected := range map[shared.KeyType]shared.ValueType{1: 10, 2: 20} {
+		if value, _, _, err := lsmTree.Get(key); err != nil || *value != expected {
+			t.Fatalf("Get(%d) = %v, %v, expected %d", key, value, err, expected)
+		}
+	}
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A batch cut by a crash is not replayed at all
+	logPath := NewWriteAheadLog(path.Join(rootDirectory, logDirectory)).GetPath()
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := os.Truncate(logPath, info.Size()-int64(LogEntrySize)); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	lsmTree = newTestLSMTree(t, rootDirectory)
+	for _, key := range []shared.KeyType{1, 2} {
+		if _, _, _, err := lsmTree.Get(key); !errors.Is(err, shared.KeyNotFoundError) {
+			t.Fatalf("expected key %d of the cut batch not to be found, got %v", key, err)
+		}
+	}
+	if err := lsmTree.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,285 @@
+package lsm_tree
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ColumnFamilyIDType is the type of the identifiers of the column families in the write-ahead log.
+type ColumnFamilyIDType = uint32
+
+// ColumnFamilyIDSize is the size of the column family identifier type in bytes.
+const ColumnFamilyIDSize = uint64(unsafe.Sizeof(ColumnFamilyIDType(0)))
+
+// LogEntrySize is the size of an entry of the write-ahead log: column family identifier | record.
+const LogEntrySize = ColumnFamilyIDSize + shared.BlockSize
+
+const (
+	// LogMagic starts the log files, it is followed by the version of the log format.
+	LogMagic uint64 = 0x0000_474f_4c4c_4157 // "WALLOG" in little endian
+	// LogFormatVersion is the version of the log files written: the header of LogHeaderSize bytes is followed by entries of
+	// LogEntrySize bytes.
+	LogFormatVersion = 1
+	// LogHeaderSize is the size of the header of the log files: LogMagic | version.
+	LogHeaderSize = 2 * 8
+)
+
+// LogEntry is a record written to the memory level of a column family.
+type LogEntry struct {
+	FamilyID ColumnFamilyIDType
+	Record   shared.Record
+}
+
+// WriteAheadLog is the log shared by the memory levels of all the column families, the records are written to it before
+// being inserted in the memory levels so that they can be replayed after a restart.
+// The log is made of numbered files (<number>.sl): a new file is started when the memory levels are flushed, and the
+// previous files are removed once the flush is done. Each file starts with a header giving the version of its format.
+type WriteAheadLog struct {
+	directory  string
+	osFile     *os.File
+	fileNumber uint64   // Number of the file currently written
+	oldFiles   []string // Files whose records have not all been flushed yet, from the oldest to the most recent
+}
+
+// GetPath returns the path of the file currently written.
+func (w *WriteAheadLog) GetPath() string {
+	return path.Join(w.directory, w.fileName(w.fileNumber))
+}
+
+func (w *WriteAheadLog) fileName(number uint64) string {
+	return fmt.Sprintf("%020d", number) + shared.SkipListExtension
+}
+
+// Open replays the entries of the log files, from the oldest to the most recent, and opens the most recent one to append
+// new entries to it (a new file is created if there is none).
+// The entries of an atomic batch are replayed together, a batch that has not been entirely written (e.g. crash during the
+// write) is ignored along with the rest of its file. The most recent file is cut after its last complete entry or batch before
+// appending to it, so that the new entries are not read as the rest of an incomplete batch.
+func (w *WriteAheadLog) Open(replay func(entries []LogEntry) error) error {
+	if err := os.MkdirAll(w.directory, 0755); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(w.directory)
+	if err != nil {
+		return err
+	}
+
+	numbers := make([]uint64, 0)
+	for _, file := range files {
+		if file.IsDir() || path.Ext(file.Name()) != shared.SkipListExtension {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), shared.SkipListExtension), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid log file name %s: %w", file.Name(), err)
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	completeSize := uint64(0)
+	for _, number := range numbers {
+		if completeSize, err = w.replayFile(w.fileName(number), replay); err != nil {
+			return err
+		}
+	}
+
+	if len(numbers) == 0 {
+		return w.createFile(0)
+	}
+
+	// New entries are appended to the most recent file
+	for _, number := range numbers[:len(numbers)-1] {
+		w.oldFiles = append(w.oldFiles, w.fileName(number))
+	}
+	w.fileNumber = numbers[len(numbers)-1]
+	if completeSize < LogHeaderSize {
+		return w.createFile(w.fileNumber)
+	}
+	osFile, err := os.OpenFile(w.GetPath(), os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := osFile.Truncate(int64(completeSize)); err != nil {
+		_ = osFile.Close()
+		return err
+	}
+	w.osFile = osFile
+	return nil
+}
+
+// replayFile reads the entries of a log file and replays them one by one, or batch by batch. It returns the size of the file
+// up to the end of its last complete entry or batch, 0 if the header has not been entirely written (e.g. crash during the
+// creation of the file).
+func (w *WriteAheadLog) replayFile(fileName string, replay func(entries []LogEntry) error) (uint64, error) {
+	osFile, err := os.Open(path.Join(w.directory, fileName))
+	if err != nil {
+		return 0, err
+	}
+	defer osFile.Close()
+
+	data, err := io.ReadAll(osFile)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(len(data)) < LogHeaderSize {
+		return 0, nil
+	}
+	if shared.Endianess.Uint64(data) != LogMagic {
+		return 0, fmt.Errorf("%s: invalid log file header", fileName)
+	}
+	if version := shared.Endianess.Uint64(data[8:]); version != LogFormatVersion {
+		return 0, fmt.Errorf("%s: unsupported log format version %d", fileName, version)
+	}
+	data = data[LogHeaderSize:]
+
+	offset := uint64(0)
+	for offset+LogEntrySize <= uint64(len(data)) {
+		entry, err := byteToLogEntry(data[offset : offset+LogEntrySize])
+		if err != nil {
+			return 0, err
+		}
+
+		// A batch is made of its header followed by its entries
+		end := offset + LogEntrySize
+		if entry.Record.Kind == shared.KindBatch {
+			if entry.Record.Value > uint64(len(data))/LogEntrySize {
+				break
+			}
+			end += entry.Record.Value * LogEntrySize
+		}
+		if end > uint64(len(data)) {
+			break
+		}
+
+		entries := make([]LogEntry, 0, (end-offset)/LogEntrySize)
+		for ; offset < end; offset += LogEntrySize {
+			entry, err := byteToLogEntry(data[offset : offset+LogEntrySize])
+			if err != nil {
+				return 0, err
+			}
+			if entry.Record.Kind != shared.KindBatch {
+				entries = append(entries, entry)
+			}
+		}
+
+		if err := replay(entries); err != nil {
+			return 0, err
+		}
+	}
+
+	return LogHeaderSize + offset, nil
+}
+
+// Append writes the entries to the log in a single write. Several entries are written as an atomic batch:
+// after a crash, either all of them or none of them are replayed.
+func (w *WriteAheadLog) Append(entries []LogEntry) error {
+	if w.osFile == nil {
+		return errors.New("log not open")
+	}
+
+	_, err := w.osFile.Write(encodeLogEntries(entries))
+	return err
+}
+
+// encodeLogEntries converts the entries to a byte slice, several entries are preceded by the header of their batch.
+func encodeLogEntries(entries []LogEntry) []byte {
+	buf := make([]byte, 0, uint64(len(entries)+1)*LogEntrySize)
+	if len(entries) > 1 {
+		header := LogEntry{Record: shared.Record{Value: shared.ValueType(len(entries)), Kind: shared.KindBatch, SequenceNumber: entries[0].Record.SequenceNumber}}
+		buf = append(buf, header.ToByte()...)
+	}
+	for _, entry := range entries {
+		buf = append(buf, entry.ToByte()...)
+	}
+	return buf
+}
+
+// Rotate starts a new log file, the entries written afterwards go to it.
+// It returns the files holding the entries written before, to be removed once they have been flushed.
+func (w *WriteAheadLog) Rotate() ([]string, error) {
+	if err := w.osFile.Close(); err != nil {
+		return nil, err
+	}
+
+	files := append(w.oldFiles, w.fileName(w.fileNumber))
+	w.oldFiles = nil
+	if err := w.createFile(w.fileNumber + 1); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Remove removes the log files returned by Rotate, the ones already removed by a previous call that failed are skipped.
+func (w *WriteAheadLog) Remove(files []string) error {
+	for _, fileName := range files {
+		if err := os.Remove(path.Join(w.directory, fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WriteAheadLog) createFile(number uint64) error {
+	osFile, err := os.Create(path.Join(w.directory, w.fileName(number)))
+	if err != nil {
+		return err
+	}
+	if _, err := osFile.Write(logHeader()); err != nil {
+		_ = osFile.Close()
+		return err
+	}
+	w.osFile = osFile
+	w.fileNumber = number
+	return nil
+}
+
+// Close closes the file currently written.
+func (w *WriteAheadLog) Close() error {
+	if w.osFile == nil {
+		return nil
+	}
+	err := w.osFile.Close()
+	w.osFile = nil
+	return err
+}
+
+// logHeader returns the header of the log files.
+func logHeader() []byte {
+	header := make([]byte, 0, LogHeaderSize)
+	header = shared.Endianess.AppendUint64(header, LogMagic)
+	return shared.Endianess.AppendUint64(header, LogFormatVersion)
+}
+
+// ToByte converts the entry to a byte slice of LogEntrySize bytes.
+func (e LogEntry) ToByte() []byte {
+	data := make([]byte, ColumnFamilyIDSize, LogEntrySize)
+	shared.Endianess.PutUint32(data, e.FamilyID)
+	return append(data, e.Record.ToByte()...)
+}
+
+func byteToLogEntry(data []byte) (LogEntry, error) {
+	if uint64(len(data)) != LogEntrySize {
+		return LogEntry{}, errors.New("invalid data size")
+	}
+
+	record, err := shared.ByteToRecord(data[ColumnFamilyIDSize:])
+	if err != nil {
+		return LogEntry{}, err
+	}
+	return LogEntry{FamilyID: shared.Endianess.Uint32(data), Record: record}, nil
+}
+
+// NewWriteAheadLog creates a write-ahead log stored in directory, it must be opened with Open.
+func NewWriteAheadLog(directory string) *WriteAheadLog {
+	return &WriteAheadLog{directory: directory}
+}
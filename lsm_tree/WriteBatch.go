@@ -0,0 +1,80 @@
+package lsm_tree
+
+import "dmds_lab2/shared"
+
+// WriteBatch holds writes to one or more column families, applied atomically by LSMTree.Write.
+type WriteBatch struct {
+	entries []batchEntry
+}
+
+type batchEntry struct {
+	family *ColumnFamily
+	key    shared.KeyType
+	value  shared.ValueType
+	kind   shared.RecordKind // KindValue, or KindMerge for a merge operand
+}
+
+// Put adds the insertion of the key-value pair into the column family to the batch.
+func (b *WriteBatch) Put(family *ColumnFamily, key shared.KeyType, value shared.ValueType) {
+	b.entries = append(b.entries, batchEntry{family: family, key: key, value: value, kind: shared.KindValue})
+}
+
+// Merge adds a merge operand for the key of the column family to the batch (see ColumnFamily.Merge).
+func (b *WriteBatch) Merge(family *ColumnFamily, key shared.KeyType, operand shared.ValueType) {
+	b.entries = append(b.entries, batchEntry{family: family, key: key, value: operand, kind: shared.KindMerge})
+}
+
+// Delete adds the deletion of the key from the column family to the batch.
+func (b *WriteBatch) Delete(family *ColumnFamily, key shared.KeyType) {
+	b.Put(family, key, shared.TombstoneValue)
+}
+
+// Count returns the number of writes in the batch.
+func (b *WriteBatch) Count() int {
+	return len(b.entries)
+}
+
+// Write applies the writes of the batch atomically: they are written to the log in a single batch, so that after a crash
+// either all of them or none of them are replayed, and they share the same sequence number, so that a snapshot sees
+// either all of them or none of them.
+func (L *LSMTree) Write(batch *WriteBatch) error {
+	if batch == nil || len(batch.entries) == 0 {
+		return nil
+	}
+
+	L.mutex.Lock()
+	defer L.mutex.Unlock()
+
+	for _, entry := range batch.entries {
+		if entry.family == nil || entry.family.tree != L {
+			return shared.ColumnFamilyNotFoundError
+		}
+		if entry.kind == shared.KindMerge && entry.family.mergeOperator == nil {
+			return shared.MergeOperatorNotSetError
+		}
+	}
+
+	L.sequenceNumber++
+	entries := make([]LogEntry, 0, len(batch.entries))
+	for _, entry := range batch.entries {
+		record := shared.Record{Key: entry.key, Value: entry.value, Kind: entry.kind, SequenceNumber: L.sequenceNumber}
+		entries = append(entries, LogEntry{FamilyID: entry.family.id, Record: record})
+	}
+	if err := L.log.Append(entries); err != nil {
+		return err
+	}
+
+	full := false
+	for i, entry := range batch.entries {
+		memoryLevel := entry.family.memoryLevel()
+		if err := memoryLevel.apply([]shared.Record{entries[i].Record}); err != nil {
+			return err
+		}
+		full = full || memoryLevel.IsFull()
+	}
+
+	if !full {
+		return nil
+	}
+	return L.compact()
+}
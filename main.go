@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"strconv"
 )
 
 const rootDirectory = ".ss_tables"
@@ -23,23 +22,14 @@ func testBPlusTree() {
 		doesExist := exists[uint64(n)]
 		if !doesExist {
 			fmt.Println(n)
-			ln.Insert(shared.KeyType(n))
+			ln.Insert(shared.KeyType(n), shared.ValueType(i))
 		}
 		exists[uint64(n)] = true
 	}
-	leaf, _, _, _ := ln.Get(1)
-
-	for leaf != nil {
-		for _, k := range leaf.GetKeys() {
-			if k != 0 {
-				fmt.Print(strconv.FormatUint(k, 10), " ")
-			} else {
-				fmt.Print(" . ")
-			}
-		}
-		fmt.Print(" -> ")
-		leaf = leaf.GetNext()
+	for cursor := ln.Min(); cursor.Valid(); cursor.Next() {
+		fmt.Printf("%d: %d -> ", cursor.Key(), cursor.Value())
 	}
+	fmt.Println()
 }
 
 func testRetrieval(expectedValues map[shared.KeyType]shared.ValueType, lsmTree *lsm_tree.LSMTree) {
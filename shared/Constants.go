@@ -10,8 +10,19 @@ import (
 // RandomGenerator is the random number generator at a given seed.
 var RandomGenerator = rand.New(rand.NewSource(1))
 
-// BlockSize is the size of a block in the SSTable (KeySize + ValueSize).
-const BlockSize = KeySize + ValueSize
+// BlockSize is the size of a block (record) in the SSTable (KeySize + ValueSize + KindSize + SequenceNumberSize + TimestampSize).
+const BlockSize = KeySize + ValueSize + KindSize + SequenceNumberSize + TimestampSize
+
+const (
+	// KindValue is the kind of the records holding a key-value pair (or a tombstone, see TombstoneValue).
+	KindValue RecordKind = iota
+	// KindRangeTombstone is the kind of the records holding a range tombstone, the key is the start and the value the end of the range.
+	KindRangeTombstone
+	// KindMerge is the kind of the records holding a merge operand, it is folded into the value of the key by a merge operator.
+	KindMerge
+	// KindBatch is the kind of the records starting an atomic batch in the logs, the value is the number of records of the batch.
+	KindBatch
+)
 
 // Endianess is the endianess used for encoding and decoding
 var Endianess = binary.LittleEndian
@@ -34,4 +45,7 @@ const SkipListExtension = ".sl"
 // SSTableExtension is the extension used for the SSTable files.
 const SSTableExtension = ".sst"
 
+// TemporaryExtension is the extension used for the SSTable files that are still being written.
+const TemporaryExtension = ".tmp"
+
 var HashFunctions = []hash_function.HashFunction{&hash_function.FNVHashFunction{}, &hash_function.MD5HashFunction{}}
@@ -1,6 +1,9 @@
 package shared
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // KeyNotFoundError is the error returned when the key is not found.
 var KeyNotFoundError = errors.New("key not found")
@@ -10,3 +13,39 @@ var KeyTombstonedError = errors.New("key is tombstoned")
 
 // LevelFullError is the error returned when the level is full in the LSM Tree.
 var LevelFullError = errors.New("level is full")
+
+// MergeOperatorNotSetError is the error returned when merge operands have to be folded but no merge operator is set.
+var MergeOperatorNotSetError = errors.New("merge operator not set")
+
+// ConditionFailedError is the error returned when the condition of a conditional write (e.g. compare-and-swap) does not hold.
+var ConditionFailedError = errors.New("condition failed")
+
+// ConditionError is the error returned by the conditional writes, it holds the value of the key at the time of the write.
+// It wraps ConditionFailedError so that it can be checked with errors.Is.
+type ConditionError struct {
+	Key    KeyType
+	Actual *ValueType // Current value of the key, nil if the key does not exist
+}
+
+func (e *ConditionError) Error() string {
+	if e.Actual == nil {
+		return fmt.Sprintf("%v: key %d does not exist", ConditionFailedError, e.Key)
+	}
+	return fmt.Sprintf("%v: key %d holds %d", ConditionFailedError, e.Key, *e.Actual)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return ConditionFailedError
+}
+
+// TransactionConflictError is the error returned when a transaction writes a key that has been written after its snapshot.
+var TransactionConflictError = errors.New("transaction conflict")
+
+// DeadlockError is the error returned when waiting for the lock of a key would create a deadlock between transactions.
+var DeadlockError = errors.New("deadlock detected")
+
+// TransactionClosedError is the error returned when a transaction is used after it has been committed or rolled back.
+var TransactionClosedError = errors.New("transaction closed")
+
+// ColumnFamilyNotFoundError is the error returned when a column family does not exist.
+var ColumnFamilyNotFoundError = errors.New("column family not found")
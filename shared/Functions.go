@@ -34,7 +34,7 @@ func ByteToKeyValue(data []byte) (KeyType, ValueType, error) {
 	if err != nil {
 		return 0, ValueType(0), err
 	}
-	value, err := ByteToValue(data[KeySize:BlockSize])
+	value, err := ByteToValue(data[KeySize : KeySize+ValueSize])
 	if err != nil {
 		return 0, ValueType(0), err
 	}
@@ -55,12 +55,23 @@ func ValueToByte(value ValueType) []byte {
 	return data
 }
 
+// ByteToKind returns the kind of the record stored in the byte slice.
+func ByteToKind(data []byte) (RecordKind, error) {
+	if uint64(len(data)) != BlockSize {
+		return KindValue, errors.New("invalid data size")
+	}
+
+	return data[KeySize+ValueSize], nil
+}
+
 // KeyValueToByte converts a key-value pair to a byte slice.
 func KeyValueToByte(key KeyType, value ValueType) []byte {
-	data := make([]byte, BlockSize)
-	copy(data, KeyToByte(key))
-	copy(data[KeySize:], ValueToByte(value))
-	return data
+	return Record{Key: key, Value: value, Kind: KindValue}.ToByte()
+}
+
+// IsExpired returns true if a value expiring at expiresAt (0 meaning never) is expired at now.
+func IsExpired(expiresAt TimestampType, now TimestampType) bool {
+	return expiresAt != 0 && expiresAt <= now
 }
 
 // IsTombstone returns true if the data is a tombstone.
@@ -111,3 +122,20 @@ func ReadValuesFromFile(filePath string) ([]int, []int) {
 
 	return keys, values
 }
+
+// WriteFileSync writes the data to a new file and flushes it to the disk.
+func WriteFileSync(filePath string, data []byte) error {
+	osFile, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := osFile.Write(data); err != nil {
+		_ = osFile.Close()
+		return err
+	}
+	if err := osFile.Sync(); err != nil {
+		_ = osFile.Close()
+		return err
+	}
+	return osFile.Close()
+}
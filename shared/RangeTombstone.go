@@ -0,0 +1,58 @@
+package shared
+
+import "sort"
+
+// RangeTombstone marks all the keys in [Start, End) written before it (with a lower sequence number) as deleted.
+type RangeTombstone struct {
+	Start          KeyType
+	End            KeyType
+	SequenceNumber SequenceNumberType
+}
+
+// Covers returns true if the key is within the range of the tombstone.
+func (rt RangeTombstone) Covers(key KeyType) bool {
+	return rt.Start <= key && key < rt.End
+}
+
+// Overlaps returns true if the range of the tombstone overlaps the (inclusive) key range [minKey, maxKey].
+func (rt RangeTombstone) Overlaps(minKey KeyType, maxKey KeyType) bool {
+	return rt.Start <= maxKey && minKey < rt.End
+}
+
+// ToByte converts the range tombstone to a record of kind KindRangeTombstone.
+func (rt RangeTombstone) ToByte() []byte {
+	return Record{Key: rt.Start, Value: rt.End, Kind: KindRangeTombstone, SequenceNumber: rt.SequenceNumber}.ToByte()
+}
+
+// ByteToRangeTombstone converts a record of kind KindRangeTombstone to a range tombstone.
+func ByteToRangeTombstone(data []byte) (RangeTombstone, error) {
+	record, err := ByteToRecord(data)
+	if err != nil {
+		return RangeTombstone{}, err
+	}
+	return RangeTombstone{Start: record.Key, End: record.Value, SequenceNumber: record.SequenceNumber}, nil
+}
+
+// GetCoveringSequenceNumber returns the sequence number of the most recent range tombstone covering the key
+// among the ones visible at the snapshot (sequence number lower than or equal to snapshot).
+// It returns false if none of them covers the key.
+func GetCoveringSequenceNumber(rangeTombstones []RangeTombstone, key KeyType, snapshot SequenceNumberType) (SequenceNumberType, bool) {
+	sequenceNumber, found := SequenceNumberType(0), false
+	for _, rt := range rangeTombstones {
+		if rt.Covers(key) && rt.SequenceNumber <= snapshot && (!found || rt.SequenceNumber > sequenceNumber) {
+			sequenceNumber, found = rt.SequenceNumber, true
+		}
+	}
+	return sequenceNumber, found
+}
+
+// SortRangeTombstones sorts the range tombstones by start.
+// They are not merged since range tombstones written at different times delete different versions of the keys.
+func SortRangeTombstones(rangeTombstones []RangeTombstone) []RangeTombstone {
+	sorted := make([]RangeTombstone, len(rangeTombstones))
+	copy(sorted, rangeTombstones)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	return sorted
+}
@@ -0,0 +1,44 @@
+package shared
+
+import "errors"
+
+// Record is a record of the logs and SSTables:
+// key (KeySize) | value (ValueSize) | kind (KindSize) | sequence number (SequenceNumberSize) | expiry (TimestampSize).
+type Record struct {
+	Key            KeyType
+	Value          ValueType
+	Kind           RecordKind
+	SequenceNumber SequenceNumberType // Sequence number of the write, a more recent write has a greater sequence number
+	ExpiresAt      TimestampType      // Time at which the value expires, 0 if it never expires
+}
+
+// ToByte converts the record to a byte slice of BlockSize bytes.
+func (r Record) ToByte() []byte {
+	data := make([]byte, BlockSize)
+	Endianess.PutUint64(data, r.Key)
+	Endianess.PutUint64(data[KeySize:], r.Value)
+	data[KeySize+ValueSize] = r.Kind
+	Endianess.PutUint64(data[KeySize+ValueSize+KindSize:], r.SequenceNumber)
+	Endianess.PutUint64(data[KeySize+ValueSize+KindSize+SequenceNumberSize:], r.ExpiresAt)
+	return data
+}
+
+// ByteToRecord converts a byte slice of BlockSize bytes to a record.
+func ByteToRecord(data []byte) (Record, error) {
+	if uint64(len(data)) != BlockSize {
+		return Record{}, errors.New("invalid data size")
+	}
+
+	return Record{
+		Key:            Endianess.Uint64(data),
+		Value:          Endianess.Uint64(data[KeySize:]),
+		Kind:           data[KeySize+ValueSize],
+		SequenceNumber: Endianess.Uint64(data[KeySize+ValueSize+KindSize:]),
+		ExpiresAt:      Endianess.Uint64(data[KeySize+ValueSize+KindSize+SequenceNumberSize:]),
+	}, nil
+}
+
+// IsExpired returns true if the record expires at or before now.
+func (r Record) IsExpired(now TimestampType) bool {
+	return IsExpired(r.ExpiresAt, now)
+}
@@ -13,3 +13,21 @@ const KeySize = uint64(unsafe.Sizeof(KeyType(0)))
 
 // ValueSize is the size of the value type in bytes.
 const ValueSize = uint64(unsafe.Sizeof(ValueType(0)))
+
+// RecordKind is the kind of record stored in the logs and SSTables.
+type RecordKind = uint8
+
+// KindSize is the size of the record kind in bytes.
+const KindSize = uint64(unsafe.Sizeof(RecordKind(0)))
+
+// TimestampType is the type of the timestamps (Unix time in nanoseconds) at which the keys inserted with a TTL expire.
+type TimestampType = uint64
+
+// TimestampSize is the size of the timestamp type in bytes.
+const TimestampSize = uint64(unsafe.Sizeof(TimestampType(0)))
+
+// SequenceNumberType is the type of the sequence numbers ordering the writes of the LSM Tree.
+type SequenceNumberType = uint64
+
+// SequenceNumberSize is the size of the sequence number type in bytes.
+const SequenceNumberSize = uint64(unsafe.Sizeof(SequenceNumberType(0)))
@@ -7,6 +7,9 @@ import (
 type Node struct {
 	key    shared.KeyType
 	value  shared.ValueType
+	kind   shared.RecordKind
+	seq    shared.SequenceNumberType
+	expiry shared.TimestampType
 	next   []*Node
 	height uint64
 }
@@ -23,6 +26,31 @@ func (s *Node) GetValue() shared.ValueType {
 	return s.value
 }
 
+func (s *Node) SetValue(value shared.ValueType) {
+	s.value = value
+}
+
+func (s *Node) GetKind() shared.RecordKind {
+	return s.kind
+}
+
+func (s *Node) SetKind(kind shared.RecordKind) {
+	s.kind = kind
+}
+
+func (s *Node) GetSequenceNumber() shared.SequenceNumberType {
+	return s.seq
+}
+
+func (s *Node) GetExpiresAt() shared.TimestampType {
+	return s.expiry
+}
+
+// GetRecord returns the record held by the node.
+func (s *Node) GetRecord() shared.Record {
+	return shared.Record{Key: s.key, Value: s.value, Kind: s.kind, SequenceNumber: s.seq, ExpiresAt: s.expiry}
+}
+
 func (s *Node) GetNext() []*Node {
 	return s.next
 }
@@ -37,12 +65,15 @@ func getNodeLevel(p float32, maxLevel uint64) uint64 {
 	return level
 }
 
-func newNode(height uint64, key shared.KeyType, value shared.ValueType) *Node {
+func newNode(height uint64, record shared.Record) *Node {
 	newSkipList := &Node{
 		next:   make([]*Node, height),
 		height: height,
-		key:    key,
-		value:  value,
+		key:    record.Key,
+		value:  record.Value,
+		kind:   record.Kind,
+		seq:    record.SequenceNumber,
+		expiry: record.ExpiresAt,
 	}
 	return newSkipList
 }
@@ -2,6 +2,7 @@ package skip_list
 
 import (
 	"dmds_lab2/shared"
+	"sort"
 )
 
 // MaxLevel is the maximum level of the SkipList.
@@ -45,6 +46,33 @@ func (s *SkipList) GetNode(key shared.KeyType) (*Node, error) {
 	return current, shared.KeyNotFoundError
 }
 
+// Seek returns the first node with a key greater than or equal to the given key, or nil if there is none.
+func (s *SkipList) Seek(key shared.KeyType) *Node {
+	current := s.head
+	for i := current.height; i > 0; i-- {
+		idx := i - 1
+		for current.next[idx] != nil && current.next[idx].key < key {
+			current = current.next[idx]
+		}
+	}
+
+	return current.next[0]
+}
+
+// GetVersions returns the nodes of the key ordered from the most recent to the oldest (by sequence number).
+func (s *SkipList) GetVersions(key shared.KeyType) []*Node {
+	versions := make([]*Node, 0, 1)
+	for node := s.Seek(key); node != nil && node.key == key; node = node.next[0] {
+		versions = append(versions, node)
+	}
+
+	// Insertion order is not always the order of the sequence numbers (e.g. when indexing an SSTable)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].seq > versions[j].seq
+	})
+	return versions
+}
+
 // Get returns the value of the key.
 func (s *SkipList) Get(key shared.KeyType) (*shared.ValueType, error) {
 	node, err := s.GetNode(key)
@@ -56,8 +84,15 @@ func (s *SkipList) Get(key shared.KeyType) (*shared.ValueType, error) {
 
 // Insert inserts the key-value pair into the node.
 func (s *SkipList) Insert(key shared.KeyType, value shared.ValueType) error {
+	return s.InsertRecord(shared.Record{Key: key, Value: value, Kind: shared.KindValue})
+}
+
+// InsertRecord inserts the record (key, value, kind and sequence number) into the node.
+// If the key already exists, the new record is inserted before the existing ones so that the most recent record is found first.
+func (s *SkipList) InsertRecord(record shared.Record) error {
+	key := record.Key
 	current := s.head
-	newSkipListNode := newNode(getNodeLevel(p, maxLevel), key, value)
+	newSkipListNode := newNode(getNodeLevel(p, maxLevel), record)
 
 	for i := current.height; i > 0; i-- {
 		idx := i - 1
@@ -109,7 +144,7 @@ func (s *SkipList) Delete(key shared.KeyType) error {
 
 // NewSkipList returns a new SkipList.
 func NewSkipList() *SkipList {
-	head := newNode(maxLevel, 0, 0)
+	head := newNode(maxLevel, shared.Record{})
 	return &SkipList{
 		head:  head,
 		tail:  head,
@@ -0,0 +1,224 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// KeyEncoding is the way the keys are encoded in the data blocks of an SSTable (see Metadata.IsEncoded).
+// The other fields of the records are stored as varints, except the kind.
+// Every RestartInterval records, a restart point stores the key in full so that a record can be decoded without decoding the
+// whole block: its offset is stored at the end of the block, a record is found by decoding from the restart point before it.
+type KeyEncoding uint8
+
+const (
+	// NoKeyEncoding stores the records as is, at a fixed stride of shared.BlockSize bytes.
+	NoKeyEncoding KeyEncoding = iota
+	// DeltaKeyEncoding stores the difference with the previous key of the block as a varint, which suits dense integer keys.
+	DeltaKeyEncoding
+	// PrefixKeyEncoding stores the length of the prefix shared with the previous key of the block, seen as 8 big-endian bytes,
+	// followed by the rest of the key, which suits byte keys sharing long prefixes.
+	PrefixKeyEncoding
+)
+
+const (
+	// RestartInterval is the number of records between two restart points of an encoded data block.
+	RestartInterval = 16
+
+	// encodedBlockHeaderSize is the size of the header of an encoded block: its key encoding and restart interval.
+	encodedBlockHeaderSize = 2
+	// restartSize is the size of the offset of a restart point, or of the number of restart points, at the end of an encoded block.
+	restartSize = 4
+)
+
+// encodeBlock encodes the records of a data block with the key encoding: the header, the records and the offsets of the restart
+// points followed by their number.
+func encodeBlock(keyEncoding KeyEncoding, records []byte) ([]byte, error) {
+	if keyEncoding != DeltaKeyEncoding && keyEncoding != PrefixKeyEncoding {
+		return nil, fmt.Errorf("unknown key encoding %d", keyEncoding)
+	}
+
+	data := make([]byte, 0, len(records)/2)
+	data = append(data, byte(keyEncoding), RestartInterval)
+	restarts := make([]uint32, 0)
+
+	previousKey := shared.KeyType(0)
+	for i := 0; i*int(shared.BlockSize) < len(records); i++ {
+		record, err := shared.ByteToRecord(records[i*int(shared.BlockSize) : (i+1)*int(shared.BlockSize)])
+		if err != nil {
+			return nil, err
+		}
+
+		restart := i%RestartInterval == 0
+		if restart {
+			restarts = append(restarts, uint32(len(data)))
+		}
+
+		switch {
+		case keyEncoding == DeltaKeyEncoding && restart:
+			data = binary.AppendUvarint(data, record.Key)
+		case keyEncoding == DeltaKeyEncoding:
+			data = binary.AppendUvarint(data, record.Key-previousKey)
+		default:
+			sharedPrefix := 0
+			if !restart {
+				sharedPrefix = bits.LeadingZeros64(record.Key^previousKey) / 8
+			}
+			data = append(data, byte(sharedPrefix))
+			data = append(data, binary.BigEndian.AppendUint64(nil, record.Key)[sharedPrefix:]...)
+		}
+		previousKey = record.Key
+
+		data = binary.AppendUvarint(data, record.Value)
+		data = append(data, record.Kind)
+		data = binary.AppendUvarint(data, record.SequenceNumber)
+		data = binary.AppendUvarint(data, record.ExpiresAt)
+	}
+
+	for _, restart := range restarts {
+		data = binary.LittleEndian.AppendUint32(data, restart)
+	}
+	return binary.LittleEndian.AppendUint32(data, uint32(len(restarts))), nil
+}
+
+// encodedBlock is a data block encoded by encodeBlock.
+type encodedBlock struct {
+	keyEncoding     KeyEncoding
+	restartInterval int
+	records         []byte   // Encoded records, starting at the first restart point
+	restarts        []uint32 // Offsets of the restart points in records
+}
+
+// parseEncodedBlock parses the header and restart points of an encoded block.
+func parseEncodedBlock(data []byte) (*encodedBlock, error) {
+	if len(data) < encodedBlockHeaderSize+restartSize {
+		return nil, errors.New("invalid encoded block size")
+	}
+
+	restartCount := int(binary.LittleEndian.Uint32(data[len(data)-restartSize:]))
+	restartsStart := len(data) - restartSize*(restartCount+1)
+	if restartCount == 0 || restartsStart < encodedBlockHeaderSize || data[1] == 0 {
+		return nil, errors.New("invalid encoded block restart points")
+	}
+
+	block := &encodedBlock{
+		keyEncoding:     KeyEncoding(data[0]),
+		restartInterval: int(data[1]),
+		records:         data[encodedBlockHeaderSize:restartsStart],
+		restarts:        make([]uint32, restartCount),
+	}
+	for i := range block.restarts {
+		offset := binary.LittleEndian.Uint32(data[restartsStart+i*restartSize:])
+		if i == 0 && offset != encodedBlockHeaderSize || int(offset) >= restartsStart || i > 0 && offset-encodedBlockHeaderSize <= block.restarts[i-1] {
+			return nil, errors.New("invalid encoded block restart point")
+		}
+		block.restarts[i] = offset - encodedBlockHeaderSize
+	}
+	return block, nil
+}
+
+// decodeRecord decodes the record at the offset of the encoded records, previousKey is ignored at a restart point.
+// It returns the record and the offset of the next one.
+func (b *encodedBlock) decodeRecord(offset int, previousKey shared.KeyType, restart bool) (shared.Record, int, error) {
+	data := b.records[offset:]
+	record := shared.Record{}
+
+	readUvarint := func() (uint64, error) {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return 0, errors.New("invalid encoded record")
+		}
+		data = data[n:]
+		return value, nil
+	}
+
+	switch b.keyEncoding {
+	case DeltaKeyEncoding:
+		delta, err := readUvarint()
+		if err != nil {
+			return record, 0, err
+		}
+		record.Key = delta
+		if !restart {
+			record.Key += previousKey
+		}
+	case PrefixKeyEncoding:
+		if len(data) == 0 || data[0] > byte(shared.KeySize) || restart && data[0] != 0 || len(data) < 1+int(shared.KeySize)-int(data[0]) {
+			return record, 0, errors.New("invalid encoded key")
+		}
+		key := binary.BigEndian.AppendUint64(nil, previousKey)
+		copy(key[data[0]:], data[1:1+int(shared.KeySize)-int(data[0])])
+		data = data[1+int(shared.KeySize)-int(data[0]):]
+		record.Key = binary.BigEndian.Uint64(key)
+	default:
+		return record, 0, fmt.Errorf("unknown key encoding %d", b.keyEncoding)
+	}
+
+	var err error
+	if record.Value, err = readUvarint(); err != nil {
+		return record, 0, err
+	}
+	if len(data) == 0 {
+		return record, 0, errors.New("invalid encoded record")
+	}
+	record.Kind, data = data[0], data[1:]
+	if record.SequenceNumber, err = readUvarint(); err != nil {
+		return record, 0, err
+	}
+	if record.ExpiresAt, err = readUvarint(); err != nil {
+		return record, 0, err
+	}
+
+	return record, len(b.records) - len(data), nil
+}
+
+// record decodes the record at the given position of the block, from the restart point before it.
+func (b *encodedBlock) record(position int) ([]byte, error) {
+	restart := position / b.restartInterval
+	if restart >= len(b.restarts) {
+		return nil, errors.New("record position out of range")
+	}
+
+	offset := int(b.restarts[restart])
+	end := len(b.records)
+	if restart+1 < len(b.restarts) {
+		end = int(b.restarts[restart+1])
+	}
+
+	var record shared.Record
+	var err error
+	for i := restart * b.restartInterval; i <= position; i++ {
+		if offset >= end {
+			return nil, errors.New("record position out of range")
+		}
+		if record, offset, err = b.decodeRecord(offset, record.Key, i == restart*b.restartInterval); err != nil {
+			return nil, err
+		}
+	}
+	return record.ToByte(), nil
+}
+
+// decodeBlock decodes all the records of an encoded block to records of shared.BlockSize bytes.
+func decodeBlock(data []byte) ([]byte, error) {
+	block, err := parseEncodedBlock(data)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]byte, 0, DataBlockSize)
+	var record shared.Record
+	for i, offset := 0, 0; offset < len(block.records); i++ {
+		restart := i%block.restartInterval == 0
+		if restart && (i/block.restartInterval >= len(block.restarts) || int(block.restarts[i/block.restartInterval]) != offset) {
+			return nil, errors.New("invalid encoded block restart point")
+		}
+		if record, offset, err = block.decodeRecord(offset, record.Key, restart); err != nil {
+			return nil, err
+		}
+		records = append(records, record.ToByte()...)
+	}
+	return records, nil
+}
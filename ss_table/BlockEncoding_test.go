@@ -0,0 +1,162 @@
+package ss_table
+
+import (
+	"bytes"
+	"dmds_lab2/block_cache"
+	"dmds_lab2/shared"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+// randomRecords returns count records sorted by key, with dense keys or keys sharing high bytes, some with several versions.
+func randomRecords(rng *rand.Rand, count int, dense bool) []byte {
+	records := make([]shared.Record, 0, count)
+	for i := 0; i < count; i++ {
+		key := shared.KeyType(rng.Intn(4 * count))
+		if !dense {
+			key = 0xABCD<<48 | shared.KeyType(rng.Int63n(1<<40))
+		}
+		records = append(records, shared.Record{Key: key, Value: rng.Uint64() >> uint(rng.Intn(64)), Kind: shared.KindValue,
+			SequenceNumber: shared.SequenceNumberType(rng.Intn(1_000)), ExpiresAt: shared.TimestampType(rng.Intn(2))})
+	}
+	records[0].Key, records[0].Value = math.MaxUint64, shared.TombstoneValue
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	data := make([]byte, 0, count*int(shared.BlockSize))
+	for _, record := range records {
+		data = append(data, record.ToByte()...)
+	}
+	return data
+}
+
+func TestBlockEncoding_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(41))
+	for _, keyEncoding := range []KeyEncoding{DeltaKeyEncoding, PrefixKeyEncoding} {
+		for _, count := range []int{1, RestartInterval, RestartInterval + 1, DataBlockRecordCount} {
+			records := randomRecords(rng, count, keyEncoding == DeltaKeyEncoding)
+			data, err := encodeBlock(keyEncoding, records)
+			if err != nil {
+				t.Fatalf("encodeBlock failed: %v", err)
+			}
+			if count == DataBlockRecordCount && len(data) > len(records)*2/3 {
+				t.Errorf("encoding %d: expected the block to shrink, got %d bytes for %d", keyEncoding, len(data), len(records))
+			}
+
+			decoded, err := decodeBlock(data)
+			if err != nil || !bytes.Equal(decoded, records) {
+				t.Fatalf("encoding %d, %d records: round trip failed: %v", keyEncoding, count, err)
+			}
+
+			// Every record can be decoded from the restart point before it
+			block, err := parseEncodedBlock(data)
+			if err != nil {
+				t.Fatalf("parseEncodedBlock failed: %v", err)
+			}
+			for i := 0; i < count; i++ {
+				record, err := block.record(i)
+				if err != nil || !bytes.Equal(record, records[i*int(shared.BlockSize):(i+1)*int(shared.BlockSize)]) {
+					t.Fatalf("encoding %d: record %d does not match: %v", keyEncoding, i, err)
+				}
+			}
+			if _, err := block.record(count); err == nil {
+				t.Fatalf("expected an error past the last record")
+			}
+
+			// Corrupted blocks are rejected without panicking
+			for i := 0; i < 100; i++ {
+				corrupted := append([]byte(nil), data[:rng.Intn(len(data)+1)]...)
+				if len(corrupted) > 0 {
+					corrupted[rng.Intn(len(corrupted))] ^= byte(1 + rng.Intn(255))
+				}
+				_, _ = decodeBlock(corrupted)
+			}
+		}
+	}
+}
+
+func TestBlockEncoding_FormatVersion(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	records := randomRecords(rng, 1_000, true)
+
+	for _, keyEncoding := range []KeyEncoding{NoKeyEncoding, DeltaKeyEncoding, PrefixKeyEncoding} {
+		writer := NewWriter(t.TempDir(), uint64(len(records)))
+		writer.SetKeyEncoding(keyEncoding)
+		for i := 0; i < len(records); i += int(shared.BlockSize) {
+			if err := writer.Write(records[i : i+int(shared.BlockSize)]); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		paths, err := writer.Close()
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("expected one file, got %v, %v", paths, err)
+		}
+
+		for _, readMode := range []ReadMode{InMemoryReadMode, PreadReadMode, MmapReadMode} {
+			t.Run(fmt.Sprintf("%d/%d", keyEncoding, readMode), func(t *testing.T) {
+				ssTable := NewSSTable()
+				ssTable.SetPath(paths[0])
+				ssTable.SetReadMode(readMode)
+				ssTable.SetBlockCache(block_cache.NewBlockCache(block_cache.DefaultOptions()))
+				if err := ssTable.Open(); err != nil {
+					t.Fatalf("Open failed: %v", err)
+				}
+				defer ssTable.Close()
+				defer ssTable.Unmap()
+				if err := ssTable.Load(); err != nil {
+					t.Fatalf("Load failed: %v", err)
+				}
+
+				if ssTable.metadata.GetFormatVersion() != FormatVersion || ssTable.metadata.IsEncoded() != (keyEncoding != NoKeyEncoding) {
+					t.Fatalf("format version %d, encoded %v", ssTable.metadata.GetFormatVersion(), ssTable.metadata.IsEncoded())
+				}
+
+				for i := 0; i < len(records); i += int(shared.BlockSize) {
+					record, _ := shared.ByteToRecord(records[i : i+int(shared.BlockSize)])
+					versions, err := ssTable.GetVersions(record.Key)
+					if err != nil || len(versions) == 0 {
+						t.Fatalf("GetVersions(%d) = %v, %v", record.Key, versions, err)
+					}
+				}
+
+				it, err := ssTable.NewIterator()
+				if err != nil {
+					t.Fatalf("NewIterator failed: %v", err)
+				}
+				defer it.Close()
+				iterated := make([]byte, 0, len(records))
+				for it.Next() {
+					iterated = append(iterated, it.Record()...)
+				}
+				if it.Error() != nil || !bytes.Equal(iterated, records) {
+					t.Fatalf("iterator does not return the records: %v", it.Error())
+				}
+			})
+		}
+
+		// A file of a later format version is rejected
+		metadata := Metadata{}
+		metadata.SetFormatVersion(FormatVersion + 1)
+		osFile, err := os.OpenFile(paths[0], os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		if _, err := osFile.WriteAt(metadata.ToByte(), 0); err != nil {
+			t.Fatalf("WriteAt failed: %v", err)
+		}
+		_ = osFile.Close()
+
+		ssTable := NewSSTable()
+		ssTable.SetPath(paths[0])
+		if err := ssTable.Open(); err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		if err := ssTable.LoadMetadata(); err == nil {
+			t.Fatalf("expected an unsupported format version error")
+		}
+		_ = ssTable.Close()
+	}
+}
@@ -0,0 +1,168 @@
+package ss_table
+
+import (
+	"bytes"
+	"compress/flate"
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compression is the codec of a data block, it is stored in the first byte of every block of a compressed SSTable so that a
+// file may mix blocks of different codecs.
+type Compression uint8
+
+const (
+	// NoCompression stores the records as is. An SSTable written without compression nor key encoding keeps the records at a
+	// fixed stride in the file, which can then be searched in place (see ReadMode).
+	NoCompression Compression = iota
+	// LZCompression is a fast LZ77 codec in the style of Snappy (see lzCompress).
+	LZCompression
+	// DeflateCompression is the DEFLATE codec of the standard library, slower than LZCompression with a better ratio.
+	DeflateCompression
+)
+
+// blockIndex locates the compressed or encoded data blocks of an SSTable, it is stored at the end of the file:
+// the number of records followed by the offset of every block in the data section and the end of the last block.
+type blockIndex struct {
+	recordCount uint64
+	offsets     []uint64
+}
+
+// getBlockCount returns the number of data blocks.
+func (b *blockIndex) getBlockCount() uint64 {
+	return uint64(len(b.offsets)) - 1
+}
+
+// ToByte encodes the block index as stored in the file.
+func (b *blockIndex) ToByte() []byte {
+	data := make([]byte, 8*(len(b.offsets)+1))
+	shared.Endianess.PutUint64(data[0:8], b.recordCount)
+	for i, offset := range b.offsets {
+		shared.Endianess.PutUint64(data[8*(i+1):8*(i+2)], offset)
+	}
+	return data
+}
+
+// byteToBlockIndex decodes a block index encoded by ToByte.
+func byteToBlockIndex(data []byte) (*blockIndex, error) {
+	if len(data) < 16 || len(data)%8 != 0 {
+		return nil, errors.New("invalid block index size")
+	}
+
+	index := &blockIndex{recordCount: shared.Endianess.Uint64(data[0:8]), offsets: make([]uint64, 0, len(data)/8-1)}
+	for offset := 8; offset < len(data); offset += 8 {
+		blockOffset := shared.Endianess.Uint64(data[offset : offset+8])
+		if len(index.offsets) > 0 && blockOffset < index.offsets[len(index.offsets)-1] {
+			return nil, errors.New("invalid block index offsets")
+		}
+		index.offsets = append(index.offsets, blockOffset)
+	}
+	return index, nil
+}
+
+// getBlockIndexByteSize returns the size in the file of the block index of blockCount blocks, 0 if the records are stored at a fixed stride.
+func getBlockIndexByteSize(blockCount uint64) uint64 {
+	if blockCount == 0 {
+		return 0
+	}
+	return 8 * (blockCount + 2)
+}
+
+// readBlockIndex reads the block index of an SSTable file whose data section is dataSize bytes long, nil if the records are
+// stored at a fixed stride.
+func readBlockIndex(reader io.ReaderAt, metadata *Metadata, dataSize uint64) (*blockIndex, error) {
+	if metadata.GetBlockCount() == 0 {
+		return nil, nil
+	}
+
+	offset := MetadataSize + dataSize + metadata.GetRangeTombstoneCount()*shared.BlockSize + getLearnedIndexByteSize(metadata.GetLearnedIndexSize())
+	data := make([]byte, getBlockIndexByteSize(metadata.GetBlockCount()))
+	if _, err := reader.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+
+	index, err := byteToBlockIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	if index.getBlockCount() != metadata.GetBlockCount() || index.offsets[len(index.offsets)-1] != dataSize {
+		return nil, errors.New("block index does not match the data")
+	}
+	return index, nil
+}
+
+// compressBlock encodes the block with the codec, prefixed with the codec. The block is stored uncompressed if the codec
+// does not make it smaller.
+func compressBlock(compression Compression, block []byte) ([]byte, error) {
+	var compressed []byte
+	switch compression {
+	case NoCompression:
+	case LZCompression:
+		compressed = lzCompress(block)
+	case DeflateCompression:
+		var buffer bytes.Buffer
+		writer, err := flate.NewWriter(&buffer, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(block); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		compressed = buffer.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown compression %d", compression)
+	}
+
+	if compressed == nil || len(compressed) >= len(block) {
+		return append([]byte{byte(NoCompression)}, block...), nil
+	}
+	return append([]byte{byte(compression)}, compressed...), nil
+}
+
+// decompressBlock decodes a block encoded by compressBlock, encoded tells whether it holds encoded records (see KeyEncoding)
+// or records of shared.BlockSize bytes.
+func decompressBlock(data []byte, encoded bool) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty block")
+	}
+
+	var block []byte
+	var err error
+	switch Compression(data[0]) {
+	case NoCompression:
+		block = append([]byte(nil), data[1:]...)
+	case LZCompression:
+		block, err = lzDecompress(data[1:])
+	case DeflateCompression:
+		block, err = io.ReadAll(flate.NewReader(bytes.NewReader(data[1:])))
+	default:
+		return nil, fmt.Errorf("unknown compression %d", data[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !encoded && len(block)%int(shared.BlockSize) != 0 {
+		return nil, errors.New("invalid block size")
+	}
+	return block, nil
+}
+
+// readIndexedBlock reads the compressed (or encoded) data block of the index from the file and returns its decoded records.
+func readIndexedBlock(reader io.ReaderAt, index *blockIndex, encoded bool, i uint64) ([]byte, error) {
+	start, end := index.offsets[i], index.offsets[i+1]
+	data := make([]byte, end-start)
+	if _, err := reader.ReadAt(data, int64(MetadataSize+start)); err != nil {
+		return nil, err
+	}
+	records, err := decompressBlock(data, encoded)
+	if err == nil && encoded {
+		records, err = decodeBlock(records)
+	}
+	return records, err
+}
@@ -0,0 +1,135 @@
+package ss_table
+
+import (
+	"bytes"
+	"dmds_lab2/block_cache"
+	"dmds_lab2/shared"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCompression_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(39))
+	random := make([]byte, DataBlockSize)
+	rng.Read(random)
+	repetitive := bytes.Repeat([]byte("abcdefgh"), int(DataBlockSize)/8+1)[:DataBlockSize]
+
+	for _, compression := range []Compression{NoCompression, LZCompression, DeflateCompression} {
+		for name, block := range map[string][]byte{"random": random, "repetitive": repetitive, "empty": {}} {
+			data, err := compressBlock(compression, block)
+			if err != nil {
+				t.Fatalf("%d/%s: compressBlock failed: %v", compression, name, err)
+			}
+			decompressed, err := decompressBlock(data, false)
+			if err != nil || !bytes.Equal(decompressed, block) {
+				t.Fatalf("%d/%s: round trip failed: %v", compression, name, err)
+			}
+			if name == "repetitive" && compression != NoCompression && len(data) > len(block)/10 {
+				t.Errorf("%d: expected repetitive data to compress, got %d bytes", compression, len(data))
+			}
+			if name == "random" && Compression(data[0]) != NoCompression {
+				t.Errorf("%d: expected random data to be stored uncompressed", compression)
+			}
+		}
+	}
+
+	// Corrupted blocks are rejected without panicking
+	data, _ := compressBlock(LZCompression, repetitive)
+	for i := 0; i < 200; i++ {
+		corrupted := append([]byte(nil), data[:rng.Intn(len(data))]...)
+		if len(corrupted) > 1 {
+			corrupted[1+rng.Intn(len(corrupted)-1)] ^= byte(1 + rng.Intn(255))
+		}
+		if block, err := decompressBlock(corrupted, false); err == nil && bytes.Equal(block, repetitive) {
+			t.Fatalf("corrupted block decoded to the original data")
+		}
+	}
+}
+
+func TestCompression_MixedBlocks(t *testing.T) {
+	rng := rand.New(rand.NewSource(40))
+	records := make([]shared.Record, 0)
+	for key := shared.KeyType(0); key < 1_000; key++ {
+		records = append(records, shared.Record{Key: key * 3, Value: rng.Uint64(), Kind: shared.KindValue, SequenceNumber: rng.Uint64()})
+	}
+
+	// The codec changes in the middle of the file, every block records its own
+	for _, compressions := range [][2]Compression{{LZCompression, DeflateCompression}, {DeflateCompression, LZCompression}} {
+		writer := NewWriter(t.TempDir(), uint64(len(records))*shared.BlockSize)
+		writer.SetCompression(compressions[0])
+		writer.SetRangeTombstones([]shared.RangeTombstone{{Start: 10, End: 20, SequenceNumber: 1}})
+		for i, record := range records {
+			if i == len(records)/2 {
+				writer.SetCompression(compressions[1])
+			}
+			if err := writer.Write(record.ToByte()); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		paths, err := writer.Close()
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("expected one file, got %v, %v", paths, err)
+		}
+
+		for _, readMode := range []ReadMode{InMemoryReadMode, PreadReadMode, MmapReadMode} {
+			t.Run(fmt.Sprintf("%v/%d", compressions, readMode), func(t *testing.T) {
+				ssTable := NewSSTable()
+				ssTable.SetPath(paths[0])
+				ssTable.SetReadMode(readMode)
+				ssTable.SetBlockCache(block_cache.NewBlockCache(block_cache.DefaultOptions()))
+				if err := ssTable.Open(); err != nil {
+					t.Fatalf("Open failed: %v", err)
+				}
+				defer ssTable.Close()
+				defer ssTable.Unmap()
+				if err := ssTable.Load(); err != nil {
+					t.Fatalf("Load failed: %v", err)
+				}
+
+				codecs := make(map[Compression]bool)
+				for i := uint64(0); i < ssTable.GetDataBlockCount(); i++ {
+					codecs[Compression(ssTable.mustReadBlockCodec(t, i))] = true
+				}
+				if !codecs[compressions[0]] || !codecs[compressions[1]] {
+					t.Fatalf("expected blocks of both codecs, got %v", codecs)
+				}
+				if ssTable.GetRecordCount() != uint64(len(records)) || len(ssTable.GetRangeTombstones()) != 1 {
+					t.Fatalf("unexpected record count %d or range tombstones %v", ssTable.GetRecordCount(), ssTable.GetRangeTombstones())
+				}
+
+				for _, record := range records {
+					versions, err := ssTable.GetVersions(record.Key)
+					if err != nil || len(versions) != 1 || versions[0] != record {
+						t.Fatalf("GetVersions(%d) = %v, %v, expected %v", record.Key, versions, err, record)
+					}
+				}
+
+				it, err := ssTable.NewIterator()
+				if err != nil {
+					t.Fatalf("NewIterator failed: %v", err)
+				}
+				defer it.Close()
+				for i := 0; it.Next(); i++ {
+					if !bytes.Equal(it.Record(), records[i].ToByte()) {
+						t.Fatalf("iterator record %d does not match", i)
+					}
+				}
+				if it.Error() != nil {
+					t.Fatalf("iterator failed: %v", it.Error())
+				}
+			})
+		}
+	}
+}
+
+// mustReadBlockCodec returns the codec of the data block of the given index, as stored in the file.
+func (s *SSTable) mustReadBlockCodec(t *testing.T, index uint64) byte {
+	t.Helper()
+
+	data, err := s.readData(s.blockIndex.offsets[index], s.blockIndex.offsets[index]+1)
+	if err != nil {
+		t.Fatalf("readData failed: %v", err)
+	}
+	return data[0]
+}
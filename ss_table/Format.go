@@ -0,0 +1,133 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// LegacyFormatError is the error returned when reading an SSTable file written before its header started with FormatMagic,
+// it must be rewritten in the current format first (see Migrate).
+var LegacyFormatError = errors.New("SSTable file written in a legacy format, it must be migrated")
+
+const (
+	// FormatMagic starts the header of the SSTable files, it is followed by the format version.
+	// Both are at offsets that never change, whatever the version: they tell how the rest of the file is laid out.
+	FormatMagic uint64 = 0x0045_4c42_4154_5353 // "SSTABLE" in little endian
+	// FormatVersion is the version of the SSTable files written, bumped by every change of their layout: the header of MetadataSize bytes is followed by the records,
+	// of shared.BlockSize bytes, stored at a fixed stride or in data blocks, the range tombstones, the learned index and the block index.
+	// The files written before the header started with FormatMagic are converted by Migrate.
+	FormatVersion = 6
+)
+
+// readMetadata reads the header of the SSTable file of fileSize bytes, it returns LegacyFormatError if the file has been
+// written in a legacy format.
+func readMetadata(reader io.ReaderAt, fileSize uint64) (Metadata, error) {
+	if fileSize < 2*8 {
+		return Metadata{}, LegacyFormatError
+	}
+	prefix := make([]byte, 2*8)
+	if _, err := reader.ReadAt(prefix, 0); err != nil {
+		return Metadata{}, err
+	}
+	if shared.Endianess.Uint64(prefix) != FormatMagic {
+		return Metadata{}, LegacyFormatError
+	}
+	if version := shared.Endianess.Uint64(prefix[8:]); version != FormatVersion {
+		return Metadata{}, fmt.Errorf("unsupported SSTable format version %d", version)
+	}
+
+	data := [MetadataSize]byte{}
+	if fileSize < MetadataSize {
+		return Metadata{}, errors.New("truncated SSTable header")
+	}
+	if _, err := reader.ReadAt(data[:], 0); err != nil {
+		return Metadata{}, err
+	}
+	metadata := Metadata{}
+	metadata.FromByte(data)
+	return metadata, nil
+}
+
+// legacyRecordSize is the size of the records of the SSTable files written before the header started with FormatMagic:
+// key | value.
+const legacyRecordSize = shared.KeySize + shared.ValueSize
+
+// invalidLegacyFileError is the error returned when a file is not consistent with the legacy format.
+var invalidLegacyFileError = errors.New("file not consistent with the legacy format")
+
+// migrateLegacyFile converts the content of an SSTable file written before its header started with FormatMagic to the current
+// format. Such a file is made of a header of 16 bytes, minKey | maxKey, followed by the key-value pairs sorted by key, each one
+// of legacyRecordSize bytes: they become records of kind shared.KindValue (a tombstone keeps shared.TombstoneValue).
+// It returns invalidLegacyFileError if the content is not consistent with the legacy format: the size of the file must be a
+// multiple of legacyRecordSize, and the keys must be strictly increasing in the key range of the header.
+func migrateLegacyFile(data []byte) ([]byte, error) {
+	if len(data) < 2*8 || uint64(len(data))%legacyRecordSize != 0 {
+		return nil, invalidLegacyFileError
+	}
+	metadata := NewMetadata(shared.Endianess.Uint64(data), shared.Endianess.Uint64(data[8:]))
+	if metadata.GetMinKey() > metadata.GetMaxKey() {
+		return nil, invalidLegacyFileError
+	}
+
+	records := data[2*8:]
+	migrated := make([]byte, 0, MetadataSize+uint64(len(records))/legacyRecordSize*shared.BlockSize)
+	migrated = append(migrated, metadata.ToByte()...)
+	previousKey := metadata.GetMinKey()
+	for offset := uint64(0); offset < uint64(len(records)); offset += legacyRecordSize {
+		key, value, err := shared.ByteToKeyValue(records[offset : offset+legacyRecordSize])
+		if err != nil {
+			return nil, err
+		}
+		if key < metadata.GetMinKey() || key > metadata.GetMaxKey() || offset > 0 && key <= previousKey {
+			return nil, invalidLegacyFileError
+		}
+		migrated = append(migrated, shared.KeyValueToByte(key, value)...)
+		previousKey = key
+	}
+	return migrated, nil
+}
+
+// Migrate rewrites the SSTable file in the current format (see FormatVersion) if it has been written in a legacy format, and
+// returns whether it has been rewritten. The file is replaced atomically: a crash leaves either the legacy file or the migrated one.
+func Migrate(filePath string) (bool, error) {
+	osFile, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	fileInfo, err := osFile.Stat()
+	if err != nil {
+		_ = osFile.Close()
+		return false, err
+	}
+	_, err = readMetadata(osFile, uint64(fileInfo.Size()))
+	_ = osFile.Close()
+	if !errors.Is(err, LegacyFormatError) {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	migrated, err := migrateLegacyFile(data)
+	if errors.Is(err, invalidLegacyFileError) {
+		return false, fmt.Errorf("%s: unrecognized SSTable format", filePath)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	temporaryPath := filePath + shared.TemporaryExtension
+	if err := shared.WriteFileSync(temporaryPath, migrated); err != nil {
+		_ = os.Remove(temporaryPath)
+		return false, err
+	}
+	if err := os.Rename(temporaryPath, filePath); err != nil {
+		_ = os.Remove(temporaryPath)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,146 @@
+package ss_table
+
+import (
+	"bytes"
+	"dmds_lab2/shared"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+// legacyKeyValue converts a key-value pair to a record of the legacy SSTable files.
+func legacyKeyValue(key shared.KeyType, value shared.ValueType) []byte {
+	return append(shared.KeyToByte(key), shared.ValueToByte(value)...)
+}
+
+// writeLegacyFile writes an SSTable file of the legacy format: minKey | maxKey followed by the key-value pairs.
+func writeLegacyFile(t *testing.T, filePath string, keys []shared.KeyType, values []shared.ValueType) {
+	t.Helper()
+
+	data := make([]byte, 0, (len(keys)+1)*int(legacyRecordSize))
+	data = append(data, legacyKeyValue(keys[0], keys[len(keys)-1])...)
+	for i, key := range keys {
+		data = append(data, legacyKeyValue(key, values[i])...)
+	}
+	if err := os.WriteFile(filePath, data, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestMigrate_LegacyFormat(t *testing.T) {
+	rng := rand.New(rand.NewSource(40))
+	randomKeys := make([]shared.KeyType, 0, 1_000)
+	randomValues := make([]shared.ValueType, 0, 1_000)
+	for _, key := range rng.Perm(4_000)[:1_000] {
+		randomKeys = append(randomKeys, shared.KeyType(key))
+	}
+	sort.Slice(randomKeys, func(i, j int) bool { return randomKeys[i] < randomKeys[j] })
+	for range randomKeys {
+		randomValues = append(randomValues, rng.Uint64()>>uint(rng.Intn(64)))
+	}
+	randomValues[10] = shared.TombstoneValue
+
+	// The files with key 0, a single record or small values are no different from the others
+	cases := []struct {
+		keys   []shared.KeyType
+		values []shared.ValueType
+	}{
+		{[]shared.KeyType{0}, []shared.ValueType{3}},
+		{[]shared.KeyType{7}, []shared.ValueType{shared.TombstoneValue}},
+		{[]shared.KeyType{0, 1}, []shared.ValueType{0, 0}},
+		{[]shared.KeyType{1, 2, 3}, []shared.ValueType{1, 2, 3}},
+		{[]shared.KeyType{0, 4, 5, 10, 19, 31, 49, 80}, []shared.ValueType{0, 0, 3, 68, 3, 44, 4, 1}},
+		{randomKeys, randomValues},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(len(c.keys), c.keys[0]), func(t *testing.T) {
+			directory := t.TempDir()
+			writer := NewWriter(directory, uint64(len(c.keys))*shared.BlockSize)
+			for i, key := range c.keys {
+				if err := writer.Write(shared.KeyValueToByte(key, c.values[i])); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+			paths, err := writer.Close()
+			if err != nil || len(paths) != 1 {
+				t.Fatalf("expected one file, got %v, %v", paths, err)
+			}
+			current, err := os.ReadFile(paths[0])
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+
+			legacyPath := path.Join(directory, "legacy"+shared.SSTableExtension)
+			writeLegacyFile(t, legacyPath, c.keys, c.values)
+
+			// The legacy file is not read as is
+			ssTable := NewSSTable()
+			ssTable.SetPath(legacyPath)
+			if err := ssTable.Open(); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if err := ssTable.LoadMetadata(); !errors.Is(err, LegacyFormatError) {
+				t.Fatalf("LoadMetadata of a legacy file = %v, expected LegacyFormatError", err)
+			}
+			_ = ssTable.Close()
+
+			if migrated, err := Migrate(legacyPath); err != nil || !migrated {
+				t.Fatalf("Migrate = %v, %v", migrated, err)
+			}
+			if migrated, err := Migrate(legacyPath); err != nil || migrated {
+				t.Fatalf("Migrate of a migrated file = %v, %v", migrated, err)
+			}
+			data, err := os.ReadFile(legacyPath)
+			if err != nil || !bytes.Equal(data, current) {
+				t.Fatalf("the migrated file differs from the file written in the current format: %v", err)
+			}
+
+			ssTable = NewSSTable()
+			ssTable.SetPath(legacyPath)
+			if err := ssTable.Open(); err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer ssTable.Close()
+			if err := ssTable.LoadMetadata(); err != nil {
+				t.Fatalf("LoadMetadata failed: %v", err)
+			}
+			if err := ssTable.LoadDataToMemory(); err != nil {
+				t.Fatalf("LoadDataToMemory failed: %v", err)
+			}
+			if err := ssTable.CreateIndex(); err != nil {
+				t.Fatalf("CreateIndex failed: %v", err)
+			}
+			for i, key := range c.keys {
+				if value, err := ssTable.Get(key); err != nil || *value != c.values[i] {
+					t.Fatalf("Get(%d) = %v, %v, expected %d", key, value, err, c.values[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMigrate_UnrecognizedFile(t *testing.T) {
+	cases := map[string][]byte{
+		"corrupted":  bytes.Repeat([]byte{0xFF}, 100),
+		"truncated":  append(legacyKeyValue(1, 2), legacyKeyValue(1, 5)[:12]...),
+		"unsorted":   append(legacyKeyValue(1, 2), append(legacyKeyValue(2, 5), legacyKeyValue(1, 5)...)...),
+		"outOfRange": append(legacyKeyValue(1, 2), legacyKeyValue(3, 5)...),
+	}
+	for name, data := range cases {
+		filePath := path.Join(t.TempDir(), name+shared.SSTableExtension)
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := Migrate(filePath); err == nil {
+			t.Fatalf("%s: expected the file not to be recognized", name)
+		}
+		// The file is left as is
+		if kept, err := os.ReadFile(filePath); err != nil || !bytes.Equal(kept, data) {
+			t.Fatalf("%s: the file has been modified: %v", name, err)
+		}
+	}
+}
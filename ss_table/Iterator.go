@@ -0,0 +1,223 @@
+package ss_table
+
+import (
+	"bufio"
+	"dmds_lab2/shared"
+	"errors"
+	"io"
+	"os"
+)
+
+// Iterator iterates over the records (shared.BlockSize bytes each) of a sorted run in ascending key order.
+// The slice returned by Record is only valid until the next call to Next.
+// The range tombstones of the sorted run are not part of the records, they are returned by RangeTombstones.
+type Iterator interface {
+	Next() bool
+	Record() []byte
+	RangeTombstones() []shared.RangeTombstone
+	Error() error
+	Close() error
+}
+
+// SliceIterator iterates over the records of a byte slice already resident in memory.
+type SliceIterator struct {
+	data            []byte
+	rangeTombstones []shared.RangeTombstone
+	offset          uint64
+	record          []byte
+}
+
+// Next advances the iterator to the next record, it returns false once the slice is exhausted.
+func (it *SliceIterator) Next() bool {
+	if it.offset+shared.BlockSize > uint64(len(it.data)) {
+		it.record = nil
+		return false
+	}
+
+	it.record = it.data[it.offset : it.offset+shared.BlockSize]
+	it.offset += shared.BlockSize
+	return true
+}
+
+// Record returns the current record.
+func (it *SliceIterator) Record() []byte {
+	return it.record
+}
+
+// RangeTombstones returns the range tombstones of the sorted run.
+func (it *SliceIterator) RangeTombstones() []shared.RangeTombstone {
+	return it.rangeTombstones
+}
+
+// Error always returns nil since reading from memory cannot fail.
+func (it *SliceIterator) Error() error {
+	return nil
+}
+
+// Close releases the underlying slice.
+func (it *SliceIterator) Close() error {
+	it.data = nil
+	it.record = nil
+	return nil
+}
+
+// NewSliceIterator creates an iterator over the records stored in data and the given range tombstones.
+func NewSliceIterator(data []byte, rangeTombstones []shared.RangeTombstone) *SliceIterator {
+	return &SliceIterator{data: data, rangeTombstones: rangeTombstones}
+}
+
+// FileIterator streams the records of an SSTable file without loading the whole file in memory.
+// It reads the file with positional reads so it does not interfere with the other readers of the file handle.
+type FileIterator struct {
+	reader          *bufio.Reader
+	close           func() error // Closes or releases the file handle
+	record          []byte
+	rangeTombstones []shared.RangeTombstone
+	err             error
+}
+
+// Next reads the next record from the file, it returns false at the end of the file or on error.
+func (it *FileIterator) Next() bool {
+	if it.err != nil || it.reader == nil {
+		return false
+	}
+
+	_, err := io.ReadFull(it.reader, it.record)
+	if errors.Is(err, io.EOF) {
+		return false
+	}
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	return true
+}
+
+// Record returns the current record.
+func (it *FileIterator) Record() []byte {
+	return it.record
+}
+
+// RangeTombstones returns the range tombstones of the SSTable.
+func (it *FileIterator) RangeTombstones() []shared.RangeTombstone {
+	return it.rangeTombstones
+}
+
+// Error returns the first error encountered while reading the file.
+func (it *FileIterator) Error() error {
+	return it.err
+}
+
+// Close closes the file handle of the iterator, or releases it if it comes from a table cache.
+func (it *FileIterator) Close() error {
+	if it.close == nil {
+		return FileNotOpenError
+	}
+
+	err := it.close()
+	it.close = nil
+	it.reader = nil
+	return err
+}
+
+// NewIterator opens a streaming iterator over the records of the SSTable file with its own read-only file handle, skipping the metadata.
+// The range tombstones, stored at the end of the file, are read when the iterator is opened.
+func (s *SSTable) NewIterator() (*FileIterator, error) {
+	osFile, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := newFileIterator(osFile, osFile.Close)
+	if err != nil {
+		_ = osFile.Close()
+		return nil, err
+	}
+
+	return it, nil
+}
+
+// newFileIterator creates an iterator over the records of the SSTable file, close is called when the iterator is closed.
+func newFileIterator(osFile *os.File, close func() error) (*FileIterator, error) {
+	fileInfo, err := osFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := readMetadata(osFile, uint64(fileInfo.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	rangeTombstonesSize := metadata.GetRangeTombstoneCount() * shared.BlockSize
+	trailerSize := getTrailerSize(&metadata)
+	if MetadataSize+trailerSize > uint64(fileInfo.Size()) {
+		return nil, errors.New("invalid range tombstone count, learned index size or block count")
+	}
+	dataSize := uint64(fileInfo.Size()) - MetadataSize - trailerSize
+
+	rangeTombstonesByte := make([]byte, rangeTombstonesSize)
+	if _, err := osFile.ReadAt(rangeTombstonesByte, int64(MetadataSize+dataSize)); err != nil {
+		return nil, err
+	}
+	rangeTombstones, err := ByteToRangeTombstones(rangeTombstonesByte)
+	if err != nil {
+		return nil, err
+	}
+
+	// The records of a compressed file are decompressed block by block
+	var reader io.Reader = io.NewSectionReader(osFile, int64(MetadataSize), int64(dataSize))
+	index, err := readBlockIndex(osFile, &metadata, dataSize)
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		readBlock := func(i uint64) ([]byte, error) { return readIndexedBlock(osFile, index, metadata.IsEncoded(), i) }
+		reader = &blockReader{readBlock: readBlock, blockCount: index.getBlockCount()}
+	}
+
+	return &FileIterator{
+		close:           close,
+		reader:          bufio.NewReader(reader),
+		record:          make([]byte, shared.BlockSize),
+		rangeTombstones: rangeTombstones,
+	}, nil
+}
+
+// newBlockIterator creates an iterator over the records of the SSTable, whose metadata is loaded, reading its data blocks
+// with ReadBlock (through the block cache), close is called when the iterator is closed.
+func newBlockIterator(ssTable *SSTable, close func() error) *FileIterator {
+	return &FileIterator{
+		close:           close,
+		reader:          bufio.NewReader(&blockReader{readBlock: ssTable.ReadBlock, blockCount: ssTable.GetDataBlockCount()}),
+		record:          make([]byte, shared.BlockSize),
+		rangeTombstones: ssTable.GetRangeTombstones(),
+	}
+}
+
+// blockReader reads the records of the data blocks of an SSTable file in order, one block at a time.
+type blockReader struct {
+	readBlock  func(index uint64) ([]byte, error) // Returns the records of the data block of the index
+	blockCount uint64
+	next       uint64 // Index of the next block to read
+	records    []byte // Records of the current block not read yet
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for len(r.records) == 0 {
+		if r.next >= r.blockCount {
+			return 0, io.EOF
+		}
+
+		records, err := r.readBlock(r.next)
+		if err != nil {
+			return 0, err
+		}
+		r.records = records
+		r.next++
+	}
+
+	n := copy(p, r.records)
+	r.records = r.records[n:]
+	return n, nil
+}
@@ -0,0 +1,128 @@
+package ss_table
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The LZ codec encodes the length of the block followed by a sequence of elements, each one starting with a tag byte:
+// a literal (lzLiteral, length, bytes) or a copy of bytes already decoded (lzCopy, offset back from the end, length).
+// The lengths and offsets are unsigned varints.
+const (
+	lzLiteral = 0
+	lzCopy    = 1
+
+	lzMinMatch  = 4  // Shortest match encoded as a copy
+	lzHashBits  = 14 // Size of the table of the last positions of the 4-byte sequences
+	lzMaxOffset = 1 << 16
+)
+
+// lzCompress compresses the data with a greedy LZ77 matcher: the last position of every 4-byte sequence is kept in a hash table.
+func lzCompress(data []byte) []byte {
+	compressed := binary.AppendUvarint(make([]byte, 0, len(data)/2), uint64(len(data)))
+	table := make([]int32, 1<<lzHashBits)
+	for i := range table {
+		table[i] = -1
+	}
+
+	literalStart := 0
+	appendLiteral := func(end int) {
+		if end > literalStart {
+			compressed = append(compressed, lzLiteral)
+			compressed = binary.AppendUvarint(compressed, uint64(end-literalStart))
+			compressed = append(compressed, data[literalStart:end]...)
+		}
+	}
+
+	for i := 0; i+lzMinMatch <= len(data); {
+		hash := lzHash(binary.LittleEndian.Uint32(data[i:]))
+		candidate := int(table[hash])
+		table[hash] = int32(i)
+
+		if candidate < 0 || i-candidate > lzMaxOffset || binary.LittleEndian.Uint32(data[candidate:]) != binary.LittleEndian.Uint32(data[i:]) {
+			i++
+			continue
+		}
+
+		length := lzMinMatch
+		for i+length < len(data) && data[candidate+length] == data[i+length] {
+			length++
+		}
+
+		appendLiteral(i)
+		compressed = append(compressed, lzCopy)
+		compressed = binary.AppendUvarint(compressed, uint64(i-candidate))
+		compressed = binary.AppendUvarint(compressed, uint64(length))
+		i += length
+		literalStart = i
+	}
+	appendLiteral(len(data))
+
+	return compressed
+}
+
+// lzDecompress decompresses data compressed by lzCompress.
+func lzDecompress(compressed []byte) ([]byte, error) {
+	length, n := binary.Uvarint(compressed)
+	if n <= 0 {
+		return nil, errors.New("invalid LZ block length")
+	}
+	compressed = compressed[n:]
+	data := make([]byte, 0, min(length, DataBlockSize))
+
+	readUvarint := func() (uint64, error) {
+		value, n := binary.Uvarint(compressed)
+		if n <= 0 {
+			return 0, errors.New("invalid LZ varint")
+		}
+		compressed = compressed[n:]
+		return value, nil
+	}
+
+	for len(compressed) > 0 {
+		tag := compressed[0]
+		compressed = compressed[1:]
+
+		switch tag {
+		case lzLiteral:
+			literalLength, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if literalLength > uint64(len(compressed)) || uint64(len(data))+literalLength > length {
+				return nil, errors.New("invalid LZ literal")
+			}
+			data = append(data, compressed[:literalLength]...)
+			compressed = compressed[literalLength:]
+		case lzCopy:
+			offset, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			copyLength, err := readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			if offset == 0 || offset > uint64(len(data)) || uint64(len(data))+copyLength > length {
+				return nil, errors.New("invalid LZ copy")
+			}
+			// The copy may overlap the bytes it produces, so it is done byte by byte
+			start := len(data) - int(offset)
+			for i := 0; i < int(copyLength); i++ {
+				data = append(data, data[start+i])
+			}
+		default:
+			return nil, errors.New("invalid LZ tag")
+		}
+	}
+
+	if uint64(len(data)) != length {
+		return nil, errors.New("truncated LZ block")
+	}
+	return data, nil
+}
+
+// lzHash hashes a 4-byte sequence to an index of the hash table.
+func lzHash(sequence uint32) uint32 {
+	return (sequence * 0x1e35a7bd) >> (32 - lzHashBits)
+}
@@ -0,0 +1,208 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+	"math"
+	"sort"
+)
+
+// DefaultLearnedIndexError is the default maximum error, in records, of the predictions of a learned index.
+const DefaultLearnedIndexError = 16
+
+// learnedSegmentSize is the size of a segment of the learned index in the file: its first key, slope and position.
+const learnedSegmentSize = 3 * 8
+
+// LearnedIndex is a piecewise linear model of the position of the records of an SSTable given their key (a one-level PGM-index).
+// Each segment predicts the position of the first record of a key with an error of at most epsilon records, so a lookup only
+// searches a window of 2*epsilon+1 records around the prediction. It is built while the SSTable is written (see
+// Writer.SetLearnedIndexError) and stored after the range tombstones: epsilon followed by the segments.
+type LearnedIndex struct {
+	epsilon  uint64
+	segments []learnedSegment
+}
+
+type learnedSegment struct {
+	key      shared.KeyType // First key of the segment
+	slope    float64        // Records per key
+	position uint64         // Position of the first record of the key
+}
+
+// GetSegmentCount returns the number of segments of the learned index.
+func (l *LearnedIndex) GetSegmentCount() int {
+	return len(l.segments)
+}
+
+// predict returns the window [low, high) holding the position of the first record whose key is greater than or equal to the key,
+// it is exact for the keys of the SSTable but may miss for the other keys (see SSTable.learnedIndexSearch).
+func (l *LearnedIndex) predict(key shared.KeyType, count uint64) (uint64, uint64) {
+	i := sort.Search(len(l.segments), func(i int) bool { return l.segments[i].key > key }) - 1
+	if i < 0 {
+		return 0, 0
+	}
+
+	segment := l.segments[i]
+	end := count
+	if i+1 < len(l.segments) {
+		end = l.segments[i+1].position
+	}
+	position := segment.position + uint64(min(segment.slope*float64(key-segment.key), float64(end-segment.position)))
+
+	low := position - min(position, l.epsilon)
+	high := min(position+l.epsilon+1, count)
+	return low, max(low, high)
+}
+
+// ToByte encodes the learned index as stored in the file.
+func (l *LearnedIndex) ToByte() []byte {
+	data := make([]byte, getLearnedIndexByteSize(uint64(len(l.segments))))
+	shared.Endianess.PutUint64(data[0:8], l.epsilon)
+	for i, segment := range l.segments {
+		offset := 8 + i*learnedSegmentSize
+		shared.Endianess.PutUint64(data[offset:offset+8], segment.key)
+		shared.Endianess.PutUint64(data[offset+8:offset+16], math.Float64bits(segment.slope))
+		shared.Endianess.PutUint64(data[offset+16:offset+24], segment.position)
+	}
+	return data
+}
+
+// ByteToLearnedIndex decodes a learned index encoded by ToByte.
+func ByteToLearnedIndex(data []byte) (*LearnedIndex, error) {
+	if len(data) < 8 || (len(data)-8)%learnedSegmentSize != 0 {
+		return nil, errors.New("invalid learned index size")
+	}
+
+	index := &LearnedIndex{
+		epsilon:  shared.Endianess.Uint64(data[0:8]),
+		segments: make([]learnedSegment, 0, (len(data)-8)/learnedSegmentSize),
+	}
+	for offset := 8; offset < len(data); offset += learnedSegmentSize {
+		index.segments = append(index.segments, learnedSegment{
+			key:      shared.Endianess.Uint64(data[offset : offset+8]),
+			slope:    math.Float64frombits(shared.Endianess.Uint64(data[offset+8 : offset+16])),
+			position: shared.Endianess.Uint64(data[offset+16 : offset+24]),
+		})
+	}
+	return index, nil
+}
+
+// getLearnedIndexByteSize returns the size in the file of a learned index of segmentCount segments, 0 if there is none.
+func getLearnedIndexByteSize(segmentCount uint64) uint64 {
+	if segmentCount == 0 {
+		return 0
+	}
+	return 8 + segmentCount*learnedSegmentSize
+}
+
+// learnedIndexBuilder builds a learned index from the positions of the keys, in ascending key order, with the shrinking cone
+// algorithm: a segment is extended as long as one slope keeps all its keys within epsilon of their position.
+type learnedIndexBuilder struct {
+	index     LearnedIndex
+	current   learnedSegment
+	slopeLow  float64 // Range of the slopes keeping the keys of the current segment within epsilon
+	slopeHigh float64
+	started   bool
+}
+
+// add adds the position of the first record of the key.
+func (b *learnedIndexBuilder) add(key shared.KeyType, position uint64) {
+	if b.started {
+		distance := float64(key - b.current.key)
+		low := (float64(position) - float64(b.index.epsilon) - float64(b.current.position)) / distance
+		high := (float64(position) + float64(b.index.epsilon) - float64(b.current.position)) / distance
+		if low <= b.slopeHigh && high >= b.slopeLow {
+			b.slopeLow, b.slopeHigh = max(b.slopeLow, low), min(b.slopeHigh, high)
+			return
+		}
+		b.finishSegment()
+	}
+
+	b.current = learnedSegment{key: key, position: position}
+	b.slopeLow, b.slopeHigh = 0, math.Inf(1)
+	b.started = true
+}
+
+// finishSegment adds the current segment to the index with the slope in the middle of its range.
+func (b *learnedIndexBuilder) finishSegment() {
+	if !math.IsInf(b.slopeHigh, 1) {
+		b.current.slope = (b.slopeLow + b.slopeHigh) / 2
+	}
+	b.index.segments = append(b.index.segments, b.current)
+}
+
+// build returns the learned index of the keys added, nil if none has been added.
+func (b *learnedIndexBuilder) build() *LearnedIndex {
+	if !b.started {
+		return nil
+	}
+	b.finishSegment()
+	return &b.index
+}
+
+// newLearnedIndexBuilder creates a builder of a learned index with the maximum error epsilon.
+func newLearnedIndexBuilder(epsilon uint64) *learnedIndexBuilder {
+	return &learnedIndexBuilder{index: LearnedIndex{epsilon: epsilon, segments: make([]learnedSegment, 0)}}
+}
+
+// LoadLearnedIndex loads the learned index stored in the file (or in the mapping), if the SSTable has been written with one.
+func (s *SSTable) LoadLearnedIndex() error {
+	segmentCount := s.metadata.GetLearnedIndexSize()
+	if segmentCount == 0 {
+		s.learnedIndex = nil
+		return nil
+	}
+
+	offset := MetadataSize + s.dataSize + s.metadata.GetRangeTombstoneCount()*shared.BlockSize
+	data := make([]byte, getLearnedIndexByteSize(segmentCount))
+	if s.mapping != nil {
+		copy(data, s.mapping[offset:])
+	} else if s.osFile == nil {
+		return FileNotOpenError
+	} else if _, err := s.osFile.ReadAt(data, int64(offset)); err != nil {
+		return err
+	}
+
+	learnedIndex, err := ByteToLearnedIndex(data)
+	if err != nil {
+		return err
+	}
+	s.learnedIndex = learnedIndex
+	return nil
+}
+
+// GetLearnedIndex returns the learned index of the SSTable, nil if it has not been loaded (see LoadLearnedIndex).
+func (s *SSTable) GetLearnedIndex() *LearnedIndex {
+	return s.learnedIndex
+}
+
+// learnedIndexSearch returns the position of the first record whose key is greater than or equal to the key, searching the window
+// predicted by the learned index. If the window turns out not to hold it (the key is not in the SSTable), the search goes on
+// on the side of the window where it is.
+func (s *SSTable) learnedIndexSearch(key shared.KeyType) (uint64, error) {
+	count := s.GetRecordCount()
+	low, high := s.learnedIndex.predict(key, count)
+	position, err := s.binarySearch(key, low, high)
+	if err != nil {
+		return 0, err
+	}
+
+	if position == low && low > 0 {
+		previousKey, err := s.readKey(low - 1)
+		if err != nil {
+			return 0, err
+		}
+		if previousKey >= key {
+			return s.binarySearch(key, 0, low)
+		}
+	}
+	if position == high && high < count {
+		nextKey, err := s.readKey(high)
+		if err != nil {
+			return 0, err
+		}
+		if nextKey < key {
+			return s.binarySearch(key, high, count)
+		}
+	}
+	return position, nil
+}
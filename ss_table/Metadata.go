@@ -2,15 +2,24 @@ package ss_table
 
 import (
 	"dmds_lab2/shared"
-	"unsafe"
+	"strconv"
 )
 
 type Metadata struct {
-	minKey shared.KeyType
-	maxKey shared.KeyType
+	minKey              shared.KeyType
+	maxKey              shared.KeyType
+	rangeTombstoneCount uint64                    // Number of range tombstones stored after the key-value pairs
+	maxSequenceNumber   shared.SequenceNumberType // Greatest sequence number of the records and range tombstones
+	learnedIndexSize    uint64                    // Number of segments of the learned index stored after the range tombstones, 0 if there is none
+	blockCount          uint64                    // Number of data blocks located by the block index stored at the end, 0 if the records are stored at a fixed stride
+	formatVersion       uint64                    // Version of the format of the file (see FormatVersion)
+	encoded             bool                      // True if the records are encoded in data blocks (see KeyEncoding), false if they have a fixed size
 }
 
-const MetadataSize = uint64(unsafe.Sizeof(Metadata{}))
+// MetadataSize is the size of the header of the SSTable files of the current format version: FormatMagic | format version |
+// minKey | maxKey | range tombstone count | max sequence number | learned index size | block count | encoded.
+// The magic and the format version are at fixed offsets that never change, the rest of the header depends on the version.
+const MetadataSize = 9 * 8
 
 func (m *Metadata) GetMinKey() shared.KeyType {
 	return m.minKey
@@ -20,21 +29,91 @@ func (m *Metadata) GetMaxKey() shared.KeyType {
 	return m.maxKey
 }
 
+func (m *Metadata) GetRangeTombstoneCount() uint64 {
+	return m.rangeTombstoneCount
+}
+
+func (m *Metadata) SetRangeTombstoneCount(count uint64) {
+	m.rangeTombstoneCount = count
+}
+
+func (m *Metadata) GetMaxSequenceNumber() shared.SequenceNumberType {
+	return m.maxSequenceNumber
+}
+
+func (m *Metadata) SetMaxSequenceNumber(sequenceNumber shared.SequenceNumberType) {
+	m.maxSequenceNumber = sequenceNumber
+}
+
+func (m *Metadata) GetLearnedIndexSize() uint64 {
+	return m.learnedIndexSize
+}
+
+func (m *Metadata) SetLearnedIndexSize(segmentCount uint64) {
+	m.learnedIndexSize = segmentCount
+}
+
+func (m *Metadata) GetBlockCount() uint64 {
+	return m.blockCount
+}
+
+func (m *Metadata) SetBlockCount(blockCount uint64) {
+	m.blockCount = blockCount
+}
+
+func (m *Metadata) GetFormatVersion() uint64 {
+	return m.formatVersion
+}
+
+func (m *Metadata) SetFormatVersion(formatVersion uint64) {
+	m.formatVersion = formatVersion
+}
+
+func (m *Metadata) IsEncoded() bool {
+	return m.encoded
+}
+
+func (m *Metadata) SetEncoded(encoded bool) {
+	m.encoded = encoded
+}
+
+// GetFileName returns a new unique file name for an SSTable with this metadata: <minKey>_<maxKey>_<randomString>.sst
+func (m *Metadata) GetFileName() string {
+	return strconv.FormatUint(m.minKey, 10) + "_" + strconv.FormatUint(m.maxKey, 10) + "_" + shared.RandomString(32) + shared.SSTableExtension
+}
+
 func (m *Metadata) ToByte() []byte {
 	metadata := [MetadataSize]byte{}
-	shared.Endianess.PutUint64(metadata[0:shared.KeySize], m.minKey)
-	shared.Endianess.PutUint64(metadata[shared.KeySize:2*shared.KeySize], m.maxKey)
+	encoded := uint64(0)
+	if m.encoded {
+		encoded = 1
+	}
+	for i, field := range []uint64{FormatMagic, m.formatVersion, m.minKey, m.maxKey, m.rangeTombstoneCount, m.maxSequenceNumber,
+		m.learnedIndexSize, m.blockCount, encoded} {
+		shared.Endianess.PutUint64(metadata[8*i:8*(i+1)], field)
+	}
 	return metadata[:]
 }
 
+// FromByte decodes the fields of the header, whose magic and format version must have been checked (see readMetadata).
 func (m *Metadata) FromByte(data [MetadataSize]byte) {
-	m.minKey = shared.Endianess.Uint64(data[0:shared.KeySize])
-	m.maxKey = shared.Endianess.Uint64(data[shared.KeySize : 2*shared.KeySize])
+	field := func(i int) uint64 {
+		return shared.Endianess.Uint64(data[8*i : 8*(i+1)])
+	}
+	m.formatVersion = field(1)
+	m.minKey = field(2)
+	m.maxKey = field(3)
+	m.rangeTombstoneCount = field(4)
+	m.maxSequenceNumber = field(5)
+	m.learnedIndexSize = field(6)
+	m.blockCount = field(7)
+	m.encoded = field(8) != 0
 }
 
 func NewMetadata(minKey shared.KeyType, maxKey shared.KeyType) Metadata {
 	return Metadata{
-		minKey,
-		maxKey,
+		minKey:        minKey,
+		maxKey:        maxKey,
+		formatVersion: FormatVersion,
 	}
 }
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package ss_table
+
+import (
+	"errors"
+	"os"
+)
+
+// mmap is not supported on this platform, PreadReadMode or InMemoryReadMode must be used instead.
+func mmap(osFile *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+// munmap unmaps a mapping returned by mmap.
+func munmap(mapping []byte) error {
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package ss_table
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmap maps the first size bytes of the file in memory, read-only.
+func mmap(osFile *os.File, size int) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	return syscall.Mmap(int(osFile.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap unmaps a mapping returned by mmap.
+func munmap(mapping []byte) error {
+	if len(mapping) == 0 {
+		return nil
+	}
+	return syscall.Munmap(mapping)
+}
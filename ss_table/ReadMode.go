@@ -0,0 +1,136 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"errors"
+)
+
+// ReadMode is the way the records of an SSTable are read by Get.
+type ReadMode int
+
+const (
+	// InMemoryReadMode loads the records of the SSTable in memory along with a bloom filter.
+	InMemoryReadMode ReadMode = iota
+	// PreadReadMode reads the records from the file with positional reads, block by block through the block cache, and searches them.
+	// Only the metadata and range tombstones are loaded, the file must be open (see TableCache) to read the records.
+	PreadReadMode
+	// MmapReadMode maps the file in memory and searches the records directly in the mapping, which is left to the page cache of the OS.
+	MmapReadMode
+)
+
+// SetReadMode sets the way the records of the SSTable are read, it must be called before Load.
+func (s *SSTable) SetReadMode(readMode ReadMode) {
+	s.readMode = readMode
+}
+
+// ReadsFromFile returns true if the file must be open to read the records of the SSTable (see PreadReadMode).
+func (s *SSTable) ReadsFromFile() bool {
+	return s.readMode == PreadReadMode && s.array == nil && s.shallowIndex == nil
+}
+
+// Load loads the SSTable from its file, which must be open, according to its read mode (see ReadMode) and search method (see SearchMethod).
+// With PreadReadMode and MmapReadMode, no index is built: opening the SSTable only reads its metadata and range tombstones.
+func (s *SSTable) Load() error {
+	if err := s.LoadMetadata(); err != nil {
+		return err
+	}
+	if s.searchMethod == LearnedIndexSearch {
+		if err := s.LoadLearnedIndex(); err != nil {
+			return err
+		}
+	}
+
+	switch s.readMode {
+	case PreadReadMode:
+		return s.LoadRangeTombstones()
+	case MmapReadMode:
+		return s.Map()
+	default:
+		if err := s.LoadDataToMemory(); err != nil {
+			return err
+		}
+		if s.searchMethod == SkipListSearch {
+			if err := s.CreateIndex(); err != nil {
+				return err
+			}
+		}
+		return s.CreateBloomFilter(shared.HashFunctions)
+	}
+}
+
+// LoadRangeTombstones loads the range tombstones, stored after the records, from the file.
+func (s *SSTable) LoadRangeTombstones() error {
+	if s.osFile == nil {
+		return FileNotOpenError
+	}
+
+	data := make([]byte, s.metadata.GetRangeTombstoneCount()*shared.BlockSize)
+	if _, err := s.osFile.ReadAt(data, int64(MetadataSize+s.dataSize)); err != nil {
+		return err
+	}
+
+	rangeTombstones, err := ByteToRangeTombstones(data)
+	if err != nil {
+		return err
+	}
+	s.rangeTombstones = rangeTombstones
+	return nil
+}
+
+// Map maps the file, which must be open, in memory: the records are then read from the mapping (see GetData), which stays
+// valid once the file is closed, until Unmap is called. The records of a compressed file are decompressed from the mapping
+// block by block.
+func (s *SSTable) Map() error {
+	if s.osFile == nil {
+		return FileNotOpenError
+	}
+	if s.mapping != nil {
+		return errors.New("file already mapped")
+	}
+
+	fileSize, err := s.GetFileByteSize()
+	if err != nil {
+		return err
+	}
+	mapping, err := mmap(s.osFile, int(fileSize))
+	if err != nil {
+		return err
+	}
+
+	rangeTombstonesEnd := MetadataSize + s.dataSize + s.metadata.GetRangeTombstoneCount()*shared.BlockSize
+	rangeTombstones, err := ByteToRangeTombstones(mapping[MetadataSize+s.dataSize : rangeTombstonesEnd])
+	if err != nil {
+		_ = munmap(mapping)
+		return err
+	}
+
+	// The compressed records are read block by block (see ReadBlock)
+	s.mapping = mapping
+	if s.blockIndex == nil {
+		s.array = mapping[MetadataSize : MetadataSize+s.dataSize]
+	}
+	s.rangeTombstones = rangeTombstones
+	return nil
+}
+
+// Unmap unmaps the file mapped by Map, the records cannot be read anymore.
+func (s *SSTable) Unmap() error {
+	if s.mapping == nil {
+		return nil
+	}
+
+	mapping := s.mapping
+	s.mapping, s.array = nil, nil
+	return munmap(mapping)
+}
+
+// GetRecordCount returns the number of records (excluding the range tombstones) of the SSTable.
+func (s *SSTable) GetRecordCount() uint64 {
+	if s.array != nil {
+		return uint64(len(s.array)) / shared.BlockSize
+	}
+	if s.blockIndex != nil {
+		return s.blockIndex.recordCount
+	}
+	return s.dataSize / shared.BlockSize
+}
@@ -1,11 +1,13 @@
 package ss_table
 
 import (
+	"dmds_lab2/block_cache"
 	"dmds_lab2/bloom_filter"
 	"dmds_lab2/hash_function"
 	"dmds_lab2/shared"
 	"dmds_lab2/skip_list"
 	"errors"
+	"math"
 	"os"
 )
 
@@ -14,13 +16,31 @@ var FileNotOpenError = errors.New("file not open")
 
 var FileAlreadyOpenError = errors.New("file already open")
 
+// DataBlockRecordCount is the number of records in a data block, the unit in which the records are read from the file and cached.
+const DataBlockRecordCount = 128
+
+// DataBlockSize is the size of a data block in bytes, the last data block of a file may be smaller.
+const DataBlockSize = DataBlockRecordCount * shared.BlockSize
+
+// filterBlockOffset is the offset of the block key of the bloom filter in the block cache, past the end of any file.
+const filterBlockOffset = math.MaxUint64
+
 type SSTable struct {
-	metadata     Metadata
-	path         string
-	osFile       *os.File
-	array        []byte
-	shallowIndex *skip_list.SkipList
-	bloomFilter  *bloom_filter.BloomFilter
+	metadata        Metadata
+	path            string
+	osFile          *os.File
+	array           []byte
+	rangeTombstones []shared.RangeTombstone
+	shallowIndex    *skip_list.SkipList
+	bloomFilter     *bloom_filter.BloomFilter
+	blockCache      *block_cache.BlockCache
+	fileID          uint64        // Identifier of the file in the block cache
+	readMode        ReadMode      // How the records are read, see Load
+	mapping         []byte        // The whole file, mapped in memory with MmapReadMode
+	dataSize        uint64        // Size of the records in the file (compressed or not), excluding the metadata and trailer
+	searchMethod    SearchMethod  // How the records are searched by GetVersions, see Load
+	learnedIndex    *LearnedIndex // Learned index loaded from the file with LearnedIndexSearch
+	blockIndex      *blockIndex   // Location of the data blocks, nil if the records are stored at a fixed stride
 }
 
 func (s *SSTable) GetPath() string {
@@ -51,6 +71,16 @@ func (s *SSTable) GetData() []byte {
 	return s.array
 }
 
+// SetRangeTombstones sets the range tombstones of the SSTable, they must be sorted by start.
+func (s *SSTable) SetRangeTombstones(rangeTombstones []shared.RangeTombstone) {
+	s.rangeTombstones = rangeTombstones
+	s.metadata.SetRangeTombstoneCount(uint64(len(rangeTombstones)))
+}
+
+func (s *SSTable) GetRangeTombstones() []shared.RangeTombstone {
+	return s.rangeTombstones
+}
+
 // Create creates the file.
 func (s *SSTable) Create() error {
 	// Throw an error if the file is already open
@@ -97,14 +127,56 @@ func (s *SSTable) Close() error {
 		return FileNotOpenError
 	}
 
-	return s.osFile.Close()
+	err := s.osFile.Close()
+	s.osFile = nil
+	return err
 }
 
-// Delete deletes the file.
+// Delete deletes the file, unmaps it and removes its blocks from the block cache.
 func (s *SSTable) Delete() error {
+	if err := s.Unmap(); err != nil {
+		return err
+	}
+	if s.blockCache != nil {
+		s.blockCache.EraseFile(s.fileID)
+	}
 	return os.Remove(s.path)
 }
 
+// SetBlockCache sets the block cache used by ReadBlock, it may be shared with other SSTables. The index and filter blocks
+// already loaded are pinned in it (see pinIndexBlocks).
+func (s *SSTable) SetBlockCache(blockCache *block_cache.BlockCache) {
+	if s.blockCache != nil {
+		s.blockCache.EraseFile(s.fileID)
+	}
+	s.blockCache = blockCache
+	if blockCache != nil {
+		s.fileID = blockCache.NewFileID()
+		s.pinIndexBlocks()
+	}
+}
+
+// pinIndexBlocks pins the loaded index and filter blocks in the block cache: the learned index and the block index, at their
+// offset in the file, and the bloom filter, which is not stored in the file, at filterBlockOffset. They are charged to the
+// cache for as long as the SSTable is loaded and are never evicted, they are erased along with the file (see Delete).
+func (s *SSTable) pinIndexBlocks() {
+	if s.blockCache == nil {
+		return
+	}
+
+	offset := MetadataSize + s.dataSize + s.metadata.GetRangeTombstoneCount()*shared.BlockSize
+	if s.learnedIndex != nil {
+		s.blockCache.Pin(block_cache.BlockKey{FileID: s.fileID, Offset: offset}, s.learnedIndex.ToByte())
+	}
+	offset += getLearnedIndexByteSize(s.metadata.GetLearnedIndexSize())
+	if s.blockIndex != nil {
+		s.blockCache.Pin(block_cache.BlockKey{FileID: s.fileID, Offset: offset}, s.blockIndex.ToByte())
+	}
+	if s.bloomFilter != nil {
+		s.blockCache.Pin(block_cache.BlockKey{FileID: s.fileID, Offset: filterBlockOffset}, s.bloomFilter.ToByte())
+	}
+}
+
 // Exists checks if the file exists.
 func (s *SSTable) Exists() (bool, error) {
 	_, err := os.Stat(s.path)
@@ -144,26 +216,34 @@ func (s *SSTable) GetFileByteSize() (uint64, error) {
 	return uint64(fileInfo.Size()), nil
 }
 
-// LoadMetadata loads the metadata from the file to the SSTable struct.
+// LoadMetadata loads the metadata from the file to the SSTable struct, along with the size of the records in the file and the
+// block index of a compressed or encoded file. A file written in a legacy format returns LegacyFormatError (see Migrate).
 func (s *SSTable) LoadMetadata() error {
 	if s.osFile == nil {
 		return FileNotOpenError
 	}
 
-	_, err := s.osFile.Seek(0, 0)
+	fileSize, err := s.GetFileByteSize()
 	if err != nil {
 		return err
 	}
-
-	metadata := [MetadataSize]byte{}
-	_, err = s.osFile.Read(metadata[:])
-	if err != nil {
+	if s.metadata, err = readMetadata(s.osFile, fileSize); err != nil {
 		return err
 	}
+	trailerSize := getTrailerSize(&s.metadata)
+	if MetadataSize+trailerSize > fileSize {
+		return errors.New("invalid range tombstone count, learned index size or block count")
+	}
+	s.dataSize = fileSize - MetadataSize - trailerSize
 
-	s.metadata.FromByte(metadata)
+	s.blockIndex, err = readBlockIndex(s.osFile, &s.metadata, s.dataSize)
+	return err
+}
 
-	return nil
+// getTrailerSize returns the size of what is stored after the records: the range tombstones, the learned index and the block index.
+func getTrailerSize(metadata *Metadata) uint64 {
+	return metadata.GetRangeTombstoneCount()*shared.BlockSize + getLearnedIndexByteSize(metadata.GetLearnedIndexSize()) +
+		getBlockIndexByteSize(metadata.GetBlockCount())
 }
 
 // readByte reads n bytes from the file starting from the start position, ignoring the metadata.
@@ -186,21 +266,132 @@ func (s *SSTable) readByte(start uint64, n uint64) ([]byte, error) {
 	return data, nil
 }
 
-// Get retrieves the value of the given key from the SSTable using binary search.
+// GetDataBlockCount returns the number of data blocks of the file (see ReadBlock).
+func (s *SSTable) GetDataBlockCount() uint64 {
+	if s.blockIndex != nil {
+		return s.blockIndex.getBlockCount()
+	}
+	return (s.dataSize + DataBlockSize - 1) / DataBlockSize
+}
+
+// ReadBlock returns the records of the data block of the given index, read from the block cache if it holds it or else
+// from the file (which must be open or mapped, and whose metadata must be loaded) with a positional read, decompressed,
+// and then inserted into the block cache. The records of an encoded block (see KeyEncoding) are decoded.
+// It is safe for concurrent use as long as the file stays open.
+func (s *SSTable) ReadBlock(index uint64) ([]byte, error) {
+	block, err := s.readBlockData(index)
+	if err != nil || !s.isEncoded() {
+		return block, err
+	}
+	return decodeBlock(block)
+}
+
+// isEncoded returns true if the records are encoded in data blocks (see KeyEncoding).
+func (s *SSTable) isEncoded() bool {
+	return s.metadata.IsEncoded()
+}
+
+// readBlockData returns the data block of the given index as cached: decompressed but still encoded (see ReadBlock).
+func (s *SSTable) readBlockData(index uint64) ([]byte, error) {
+	key := block_cache.BlockKey{FileID: s.fileID, Offset: MetadataSize + index*DataBlockSize}
+	if s.blockCache != nil {
+		if block, ok := s.blockCache.Get(key); ok {
+			return block, nil
+		}
+	}
+
+	if index >= s.GetDataBlockCount() {
+		return nil, errors.New("block index out of range")
+	}
+
+	var block []byte
+	if s.blockIndex != nil {
+		data, err := s.readData(s.blockIndex.offsets[index], s.blockIndex.offsets[index+1])
+		if err != nil {
+			return nil, err
+		}
+		if block, err = decompressBlock(data, s.isEncoded()); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := s.readData(index*DataBlockSize, min((index+1)*DataBlockSize, s.dataSize))
+		if err != nil {
+			return nil, err
+		}
+		block = data
+	}
+
+	if s.blockCache != nil {
+		s.blockCache.Insert(key, block)
+	}
+	return block, nil
+}
+
+// readData returns a copy of the bytes [start, end) of the data section, read from the mapping or from the file.
+func (s *SSTable) readData(start uint64, end uint64) ([]byte, error) {
+	data := make([]byte, end-start)
+	if s.mapping != nil {
+		copy(data, s.mapping[MetadataSize+start:MetadataSize+end])
+		return data, nil
+	}
+
+	if s.osFile == nil {
+		return nil, FileNotOpenError
+	}
+	if _, err := s.osFile.ReadAt(data, int64(MetadataSize+start)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Get retrieves the most recent value of the given key from the SSTable.
+// If the key is deleted by one of the range tombstones of the SSTable, a tombstone value is returned.
 func (s *SSTable) Get(key uint64) (*shared.ValueType, error) {
-	if s.shallowIndex == nil {
-		return nil, errors.New("index not created")
+	value, _, err := s.GetRecord(key)
+	return value, err
+}
+
+// GetRecord retrieves the most recent value and the kind of the record of the given key from the SSTable (see Get).
+func (s *SSTable) GetRecord(key uint64) (*shared.ValueType, shared.RecordKind, error) {
+	versions, err := s.GetVersions(key)
+	if err != nil {
+		return nil, shared.KindValue, err
+	}
+
+	sequenceNumber, covered := shared.GetCoveringSequenceNumber(s.rangeTombstones, key, math.MaxUint64)
+	if len(versions) > 0 && (!covered || versions[0].SequenceNumber >= sequenceNumber) {
+		return &versions[0].Value, versions[0].Kind, nil
 	}
+	if covered {
+		tombstone := shared.TombstoneValue
+		return &tombstone, shared.KindValue, nil
+	}
+
+	return nil, shared.KindValue, shared.KeyNotFoundError
+}
 
+// GetVersions retrieves the records of the given key, from the most recent to the oldest, ignoring the range tombstones.
+// The SSTable holds several versions of a key when they are still visible to a snapshot.
+// Without index (see SearchMethod), the sorted records are searched in memory, in the mapping or in the file.
+func (s *SSTable) GetVersions(key uint64) ([]shared.Record, error) {
 	if s.bloomFilter != nil {
 		keyByte := shared.KeyToByte(key)
 		exists := s.bloomFilter.Contains(keyByte)
 		if !exists {
-			return nil, shared.KeyNotFoundError
+			return nil, nil
 		}
 	}
 
-	return s.shallowIndex.Get(key)
+	if s.shallowIndex == nil {
+		return s.searchVersions(key)
+	}
+
+	nodes := s.shallowIndex.GetVersions(key)
+	versions := make([]shared.Record, 0, len(nodes))
+	for _, node := range nodes {
+		versions = append(versions, node.GetRecord())
+	}
+	return versions, nil
 }
 
 func (s *SSTable) LoadDataToMemory() error {
@@ -208,20 +399,50 @@ func (s *SSTable) LoadDataToMemory() error {
 		return FileNotOpenError
 	}
 
-	fileSize, err := s.GetFileByteSize()
+	// The range tombstones are stored after the key-value pairs
+	data, err := s.readByte(0, s.dataSize+s.metadata.GetRangeTombstoneCount()*shared.BlockSize)
 	if err != nil {
 		return err
 	}
 
-	data, err := s.readByte(0, fileSize-MetadataSize)
+	rangeTombstones, err := ByteToRangeTombstones(data[s.dataSize:])
 	if err != nil {
 		return err
 	}
 
-	s.array = data
+	records := data[:s.dataSize]
+	if s.blockIndex != nil {
+		records = make([]byte, 0, s.blockIndex.recordCount*shared.BlockSize)
+		for i := uint64(0); i < s.blockIndex.getBlockCount(); i++ {
+			block, err := decompressBlock(data[s.blockIndex.offsets[i]:s.blockIndex.offsets[i+1]], s.isEncoded())
+			if err == nil && s.isEncoded() {
+				block, err = decodeBlock(block)
+			}
+			if err != nil {
+				return err
+			}
+			records = append(records, block...)
+		}
+	}
+
+	s.array = records
+	s.rangeTombstones = rangeTombstones
 	return nil
 }
 
+// ByteToRangeTombstones decodes a byte slice of records of kind KindRangeTombstone.
+func ByteToRangeTombstones(data []byte) ([]shared.RangeTombstone, error) {
+	rangeTombstones := make([]shared.RangeTombstone, 0, uint64(len(data))/shared.BlockSize)
+	for startIndex := uint64(0); startIndex+shared.BlockSize <= uint64(len(data)); startIndex += shared.BlockSize {
+		rangeTombstone, err := shared.ByteToRangeTombstone(data[startIndex : startIndex+shared.BlockSize])
+		if err != nil {
+			return nil, err
+		}
+		rangeTombstones = append(rangeTombstones, rangeTombstone)
+	}
+	return rangeTombstones, nil
+}
+
 // CreateIndex creates an index for the SSTable file, in this case, a SkipList.
 // It loads the key-value pairs from the file and inserts them into the SkipList allowing for faster lookups.
 func (s *SSTable) CreateIndex() error {
@@ -232,14 +453,12 @@ func (s *SSTable) CreateIndex() error {
 	skipList := skip_list.NewSkipList()
 
 	for startIndex := uint64(0); startIndex < uint64(len(s.array)); startIndex += shared.BlockSize {
-		endIndex := startIndex + shared.BlockSize
-
-		key, value, err := shared.ByteToKeyValue(s.array[startIndex:endIndex])
+		record, err := shared.ByteToRecord(s.array[startIndex : startIndex+shared.BlockSize])
 		if err != nil {
 			return err
 		}
 
-		err = skipList.Insert(key, value)
+		err = skipList.InsertRecord(record)
 		if err != nil {
 			return err
 		}
@@ -257,29 +476,24 @@ func (s *SSTable) CreateBloomFilter(hashFunctions []hash_function.HashFunction)
 	s.bloomFilter = bloom_filter.NewBloomFilter(m, hashFunctions)
 
 	for startIndex := uint64(0); startIndex < uint64(len(s.array)); startIndex += shared.BlockSize {
-		endIndex := startIndex + shared.BlockSize
-
-		value, err := shared.ByteToValue(s.array[startIndex+shared.KeySize : endIndex])
-		if err != nil {
-			return err
-		}
-
-		if !shared.IsTombstone(value) {
-			keyByte := s.array[startIndex : startIndex+shared.KeySize]
-			s.bloomFilter.Add(keyByte)
-		}
+		// Tombstones are added as well: a tombstoned key must be found so that it shadows the older levels
+		keyByte := s.array[startIndex : startIndex+shared.KeySize]
+		s.bloomFilter.Add(keyByte)
 	}
 
 	return nil
 }
 
-// Write writes the data to the file including the metadata.
+// Write writes the data to the file including the metadata and the range tombstones.
 func (s *SSTable) Write() error {
 	if s.osFile == nil {
 		return FileNotOpenError
 	}
 
 	dataWithMetadata := append(s.metadata.ToByte(), s.array...)
+	for _, rangeTombstone := range s.rangeTombstones {
+		dataWithMetadata = append(dataWithMetadata, rangeTombstone.ToByte()...)
+	}
 
 	_, err := s.osFile.Write(dataWithMetadata)
 	return err
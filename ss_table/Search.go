@@ -0,0 +1,171 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"math/bits"
+	"sort"
+)
+
+// SearchMethod is the way GetVersions finds the records of a key among the records of an SSTable, which are sorted by key
+// and stored at a fixed stride of shared.BlockSize bytes.
+type SearchMethod int
+
+const (
+	// SkipListSearch indexes the records in a SkipList when they are loaded in memory (see CreateIndex), it only applies to
+	// InMemoryReadMode: the other read modes use a binary search. It is the default.
+	SkipListSearch SearchMethod = iota
+	// BinarySearch binary-searches the sorted records, no index is built.
+	BinarySearch
+	// InterpolationSearch guesses the position of the key from the keys at the bounds of the search range, which takes
+	// O(log log n) reads for uniformly distributed keys, and falls back to a binary search for skewed keys. No index is built.
+	InterpolationSearch
+	// LearnedIndexSearch predicts the position of the key with the learned index stored in the file (see LearnedIndex) and
+	// binary-searches a small window around it. The files written without learned index use a binary search.
+	LearnedIndexSearch
+)
+
+// interpolationSearchMinRange is the size of the search range under which the interpolation search finishes with a binary search.
+const interpolationSearchMinRange = 8
+
+// SetSearchMethod sets the way the records of the SSTable are searched, it must be called before Load.
+func (s *SSTable) SetSearchMethod(searchMethod SearchMethod) {
+	s.searchMethod = searchMethod
+}
+
+// readRecord returns the record at the given position, from the records in memory (or mapped) or else from the file
+// through the block cache. An encoded record is decoded from the restart point before it (see KeyEncoding).
+func (s *SSTable) readRecord(position uint64) ([]byte, error) {
+	if s.array != nil {
+		return s.array[position*shared.BlockSize : (position+1)*shared.BlockSize], nil
+	}
+
+	if s.isEncoded() {
+		data, err := s.readBlockData(position / DataBlockRecordCount)
+		if err != nil {
+			return nil, err
+		}
+		block, err := parseEncodedBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		return block.record(int(position % DataBlockRecordCount))
+	}
+
+	block, err := s.ReadBlock(position / DataBlockRecordCount)
+	if err != nil {
+		return nil, err
+	}
+	offset := position % DataBlockRecordCount * shared.BlockSize
+	return block[offset : offset+shared.BlockSize], nil
+}
+
+// readKey returns the key of the record at the given position.
+func (s *SSTable) readKey(position uint64) (shared.KeyType, error) {
+	record, err := s.readRecord(position)
+	if err != nil {
+		return 0, err
+	}
+	return shared.Endianess.Uint64(record[:shared.KeySize]), nil
+}
+
+// binarySearch returns the position of the first record of the range [low, high) whose key is greater than or equal to the key,
+// high if there is none.
+func (s *SSTable) binarySearch(key shared.KeyType, low uint64, high uint64) (uint64, error) {
+	var err error
+	position := sort.Search(int(high-low), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		var recordKey shared.KeyType
+		recordKey, err = s.readKey(low + uint64(i))
+		return err != nil || recordKey >= key
+	})
+	if err != nil {
+		return 0, err
+	}
+	return low + uint64(position), nil
+}
+
+// interpolationSearch returns the position of the first record whose key is greater than or equal to the key, the record count
+// if there is none. Every record before low has a smaller key and every record from high has a greater or equal key.
+// The number of interpolation steps is bounded by twice the number of binary search steps, then the binary search takes over.
+func (s *SSTable) interpolationSearch(key shared.KeyType) (uint64, error) {
+	low, high := uint64(0), s.GetRecordCount()
+	for steps := 2 * bits.Len64(high); high-low > interpolationSearchMinRange && steps > 0; steps-- {
+		lowKey, err := s.readKey(low)
+		if err != nil {
+			return 0, err
+		}
+		if key <= lowKey {
+			return low, nil
+		}
+		highKey, err := s.readKey(high - 1)
+		if err != nil {
+			return 0, err
+		}
+		if key > highKey {
+			return high, nil
+		}
+
+		// lowKey < key <= highKey, so the probe is in [low, high-1]
+		position := low + interpolate(key-lowKey, highKey-lowKey, high-1-low)
+		positionKey, err := s.readKey(position)
+		if err != nil {
+			return 0, err
+		}
+		if positionKey < key {
+			low = position + 1
+		} else {
+			high = position
+		}
+	}
+	return s.binarySearch(key, low, high)
+}
+
+// interpolate returns offset * length / distance without overflow, offset must not be greater than distance which must not be 0.
+func interpolate(offset uint64, distance uint64, length uint64) uint64 {
+	high, low := bits.Mul64(offset, length)
+	quotient, _ := bits.Div64(high, low, distance)
+	return quotient
+}
+
+// searchVersions searches the records, sorted by key, for the versions of the key (see SearchMethod).
+func (s *SSTable) searchVersions(key shared.KeyType) ([]shared.Record, error) {
+	count := s.GetRecordCount()
+
+	var position uint64
+	var err error
+	switch {
+	case s.searchMethod == InterpolationSearch:
+		position, err = s.interpolationSearch(key)
+	case s.searchMethod == LearnedIndexSearch && s.learnedIndex != nil:
+		position, err = s.learnedIndexSearch(key)
+	default:
+		position, err = s.binarySearch(key, 0, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]shared.Record, 0, 1)
+	for ; position < count; position++ {
+		data, err := s.readRecord(position)
+		if err != nil {
+			return nil, err
+		}
+		record, err := shared.ByteToRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		if record.Key != key {
+			break
+		}
+		versions = append(versions, record)
+	}
+
+	// The versions of a key are written from the most recent to the oldest, this only guards against older files
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].SequenceNumber > versions[j].SequenceNumber
+	})
+	return versions, nil
+}
@@ -0,0 +1,80 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSSTable_SearchMethods(t *testing.T) {
+	rng := rand.New(rand.NewSource(37))
+
+	// Uniform keys and skewed keys, some with several versions
+	keySets := map[string][]shared.KeyType{"uniform": {}, "skewed": {}}
+	for i := 0; i < 1_000; i++ {
+		keySets["uniform"] = append(keySets["uniform"], shared.KeyType(rng.Int63n(1<<40)))
+		keySets["skewed"] = append(keySets["skewed"], shared.KeyType(rng.ExpFloat64()*1_000)<<uint(rng.Intn(50)))
+	}
+
+	for name, keys := range keySets {
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		versions := make(map[shared.KeyType]int)
+		writer := NewWriter(t.TempDir(), uint64(len(keys))*shared.BlockSize)
+		writer.SetLearnedIndexError(4)
+		for _, key := range keys {
+			versions[key]++
+			record := shared.Record{Key: key, Value: key, Kind: shared.KindValue, SequenceNumber: shared.SequenceNumberType(1_000 - versions[key])}
+			if err := writer.Write(record.ToByte()); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+		}
+		paths, err := writer.Close()
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("expected one file, got %v, %v", paths, err)
+		}
+
+		for _, readMode := range []ReadMode{InMemoryReadMode, PreadReadMode, MmapReadMode} {
+			for _, searchMethod := range []SearchMethod{BinarySearch, InterpolationSearch, SkipListSearch, LearnedIndexSearch} {
+				t.Run(fmt.Sprintf("%s/%d/%d", name, readMode, searchMethod), func(t *testing.T) {
+					ssTable := NewSSTable()
+					ssTable.SetPath(paths[0])
+					ssTable.SetReadMode(readMode)
+					ssTable.SetSearchMethod(searchMethod)
+					if err := ssTable.Open(); err != nil {
+						t.Fatalf("Open failed: %v", err)
+					}
+					defer ssTable.Close()
+					defer ssTable.Unmap()
+					if err := ssTable.Load(); err != nil {
+						t.Fatalf("Load failed: %v", err)
+					}
+					if learnedIndex := ssTable.GetLearnedIndex(); searchMethod == LearnedIndexSearch &&
+						(learnedIndex == nil || name == "uniform" && learnedIndex.GetSegmentCount() > len(keys)/20) {
+						t.Fatalf("expected a small learned index for %s keys, got %v", name, learnedIndex)
+					}
+
+					for i := 0; i < 2_000; i++ {
+						key := keys[rng.Intn(len(keys))]
+						if i%2 == 1 {
+							key = key + 1
+						}
+						records, err := ssTable.GetVersions(key)
+						if err != nil {
+							t.Fatalf("GetVersions(%d) failed: %v", key, err)
+						}
+						if len(records) != versions[key] {
+							t.Fatalf("GetVersions(%d) returned %d versions, expected %d", key, len(records), versions[key])
+						}
+						for j, record := range records {
+							if record.Key != key || j > 0 && record.SequenceNumber >= records[j-1].SequenceNumber {
+								t.Fatalf("GetVersions(%d) returned unexpected versions %v", key, records)
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
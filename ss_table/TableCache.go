@@ -0,0 +1,171 @@
+package ss_table
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// DefaultTableCacheCapacity is the default maximum number of SSTable files kept open by a table cache.
+const DefaultTableCacheCapacity = 64
+
+// TableCache opens the files of the SSTables lazily, when they are read, and keeps them open for the next reads up to a
+// maximum number of open files: once it is reached, the least recently used files that are not being read are closed.
+// The readers of an SSTable are counted (see Acquire and Release), the file of an SSTable is never closed while it is read:
+// deleting an SSTable that is being read (see Delete) only deletes its file once the last reader has released it.
+type TableCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[*SSTable]*tableEntry // SSTables whose file is open
+	lru      *list.List               // SSTables whose file is open but not read, the least recently used at the back
+}
+
+type tableEntry struct {
+	ssTable    *SSTable
+	references int
+	element    *list.Element // Position in the LRU list, nil while the SSTable is read
+	deleted    bool          // The file is deleted once the last reader has released it
+}
+
+// Acquire opens the file of the SSTable if it is not open yet, it stays open until the matching call to Release.
+func (c *TableCache) Acquire(ssTable *SSTable) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[ssTable]
+	if !ok {
+		// Make room for the file before opening it
+		if err := c.evict(c.capacity - 1); err != nil {
+			return err
+		}
+		if err := ssTable.Open(); err != nil {
+			return err
+		}
+		entry = &tableEntry{ssTable: ssTable}
+		c.entries[ssTable] = entry
+	}
+	if entry.deleted {
+		return errors.New("SSTable deleted")
+	}
+
+	if entry.element != nil {
+		c.lru.Remove(entry.element)
+		entry.element = nil
+	}
+	entry.references++
+	return nil
+}
+
+// Release releases the SSTable acquired by Acquire, its file may then be closed to stay under the capacity.
+func (c *TableCache) Release(ssTable *SSTable) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[ssTable]
+	if !ok || entry.references == 0 {
+		return errors.New("SSTable not acquired")
+	}
+
+	entry.references--
+	if entry.references > 0 {
+		return nil
+	}
+	if entry.deleted {
+		return c.remove(entry)
+	}
+
+	entry.element = c.lru.PushFront(entry)
+	return c.evict(c.capacity)
+}
+
+// Evict closes the file of the SSTable if it is open and not being read.
+func (c *TableCache) Evict(ssTable *SSTable) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[ssTable]
+	if !ok || entry.references > 0 {
+		return nil
+	}
+	return c.remove(entry)
+}
+
+// Delete deletes the file of the SSTable (see SSTable.Delete), or once the last reader has released it if it is being read.
+func (c *TableCache) Delete(ssTable *SSTable) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[ssTable]
+	if !ok {
+		return ssTable.Delete()
+	}
+
+	entry.deleted = true
+	if entry.references > 0 {
+		return nil
+	}
+	return c.remove(entry)
+}
+
+// GetOpenCount returns the number of SSTable files currently open, it may exceed the capacity if they are all being read.
+func (c *TableCache) GetOpenCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.entries)
+}
+
+// SetCapacity sets the maximum number of SSTable files kept open.
+func (c *TableCache) SetCapacity(capacity int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.capacity = max(capacity, 1)
+	return c.evict(c.capacity)
+}
+
+// evict closes the least recently used files that are not being read until at most limit files are open.
+func (c *TableCache) evict(limit int) error {
+	for len(c.entries) > limit && c.lru.Len() > 0 {
+		if err := c.remove(c.lru.Back().Value.(*tableEntry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove closes the file of the SSTable, which is not being read, and deletes it if it has been deleted.
+func (c *TableCache) remove(entry *tableEntry) error {
+	if entry.element != nil {
+		c.lru.Remove(entry.element)
+		entry.element = nil
+	}
+	delete(c.entries, entry.ssTable)
+
+	if err := entry.ssTable.Close(); err != nil {
+		return err
+	}
+	if entry.deleted {
+		return entry.ssTable.Delete()
+	}
+	return nil
+}
+
+// NewIterator opens a streaming iterator over the records of the SSTable, whose metadata must be loaded (see Load), through
+// the table cache: the data blocks are read with ReadBlock, through the block cache, and the SSTable is released when the
+// iterator is closed.
+func (c *TableCache) NewIterator(ssTable *SSTable) (*FileIterator, error) {
+	if err := c.Acquire(ssTable); err != nil {
+		return nil, err
+	}
+	return newBlockIterator(ssTable, func() error { return c.Release(ssTable) }), nil
+}
+
+// NewTableCache creates a table cache keeping at most capacity SSTable files open.
+func NewTableCache(capacity int) *TableCache {
+	return &TableCache{
+		capacity: max(capacity, 1),
+		entries:  make(map[*SSTable]*tableEntry),
+		lru:      list.New(),
+	}
+}
@@ -0,0 +1,139 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"os"
+	"sync"
+	"testing"
+)
+
+// writeTestSSTables writes count SSTable files of 10 records each and returns them with their metadata loaded.
+func writeTestSSTables(t *testing.T, cache *TableCache, count int) []*SSTable {
+	t.Helper()
+
+	writer := NewWriter(t.TempDir(), 10*shared.BlockSize)
+	for key := shared.KeyType(0); key < shared.KeyType(10*count); key++ {
+		record := shared.Record{Key: key, Value: key, Kind: shared.KindValue, SequenceNumber: 1}
+		if err := writer.Write(record.ToByte()); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	paths, err := writer.Close()
+	if err != nil || len(paths) != count {
+		t.Fatalf("expected %d files, got %v, %v", count, paths, err)
+	}
+
+	ssTables := make([]*SSTable, 0, count)
+	for _, filePath := range paths {
+		ssTable := NewSSTable()
+		ssTable.SetPath(filePath)
+		if err := cache.Acquire(ssTable); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		if err := ssTable.LoadMetadata(); err != nil {
+			t.Fatalf("LoadMetadata failed: %v", err)
+		}
+		if err := cache.Release(ssTable); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+		ssTables = append(ssTables, ssTable)
+	}
+	return ssTables
+}
+
+func TestTableCache_Capacity(t *testing.T) {
+	cache := NewTableCache(3)
+	ssTables := writeTestSSTables(t, cache, 10)
+	if count := cache.GetOpenCount(); count != 3 {
+		t.Fatalf("expected 3 open files, got %d", count)
+	}
+
+	// The files being read are never closed, even above the capacity
+	for _, ssTable := range ssTables {
+		if err := cache.Acquire(ssTable); err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+	}
+	if count := cache.GetOpenCount(); count != len(ssTables) {
+		t.Fatalf("expected %d open files, got %d", len(ssTables), count)
+	}
+	for _, ssTable := range ssTables {
+		if _, err := ssTable.ReadBlock(0); err != nil {
+			t.Fatalf("ReadBlock failed: %v", err)
+		}
+		if err := cache.Release(ssTable); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	}
+	if count := cache.GetOpenCount(); count != 3 {
+		t.Fatalf("expected 3 open files, got %d", count)
+	}
+
+	for _, ssTable := range ssTables {
+		if err := cache.Evict(ssTable); err != nil {
+			t.Fatalf("Evict failed: %v", err)
+		}
+	}
+	if count := cache.GetOpenCount(); count != 0 {
+		t.Fatalf("expected no open file, got %d", count)
+	}
+}
+
+func TestTableCache_DeleteWhileReading(t *testing.T) {
+	cache := NewTableCache(2)
+	ssTables := writeTestSSTables(t, cache, 8)
+	deleted := ssTables[0]
+
+	if err := cache.Acquire(deleted); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Concurrent readers of all the SSTables while the first one is deleted
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				ssTable := ssTables[1+(worker+i)%(len(ssTables)-1)]
+				if err := cache.Acquire(ssTable); err != nil {
+					t.Errorf("Acquire failed: %v", err)
+					return
+				}
+				if _, err := ssTable.ReadBlock(0); err != nil {
+					t.Errorf("ReadBlock failed: %v", err)
+				}
+				if err := cache.Release(ssTable); err != nil {
+					t.Errorf("Release failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	if err := cache.Delete(deleted); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	block, err := deleted.ReadBlock(0)
+	if err != nil || len(block) != 10*int(shared.BlockSize) {
+		t.Fatalf("expected the deleted SSTable to still be readable, got %d bytes, %v", len(block), err)
+	}
+	if _, err := os.Stat(deleted.GetPath()); err != nil {
+		t.Fatalf("expected the file to be kept while it is read: %v", err)
+	}
+	if err := cache.Acquire(deleted); err == nil {
+		t.Fatalf("expected Acquire of a deleted SSTable to fail")
+	}
+
+	if err := cache.Release(deleted); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := os.Stat(deleted.GetPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be deleted once released, got %v", err)
+	}
+
+	wg.Wait()
+	if count := cache.GetOpenCount(); count > 2 {
+		t.Fatalf("expected at most 2 open files, got %d", count)
+	}
+}
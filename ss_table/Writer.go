@@ -0,0 +1,286 @@
+package ss_table
+
+import (
+	"bufio"
+	"dmds_lab2/shared"
+	"errors"
+	"math"
+	"os"
+	"path"
+)
+
+// Writer streams sorted records to SSTable files in a directory.
+// A new file is started (rolled) every time the current one reaches the target size, so that
+// the memory used while writing does not depend on the total amount of data written.
+// The range tombstones given to SetRangeTombstones are fragmented at the boundaries of the files: each file
+// stores the part of the range tombstones between its first key and the first key of the next file.
+type Writer struct {
+	directory         string
+	targetSize        uint64
+	osFile            *os.File
+	buffer            *bufio.Writer
+	metadata          Metadata
+	size              uint64
+	lowerBound        shared.KeyType // Lower bound of the key space covered by the current file
+	rangeTombstones   []shared.RangeTombstone
+	paths             []string
+	learnedIndexError uint64               // Maximum error of the learned index of the files, 0 to write none
+	learnedIndex      *learnedIndexBuilder // Learned index of the current file
+	compression       Compression          // Codec of the data blocks, the records are not compressed with NoCompression
+	keyEncoding       KeyEncoding          // Encoding of the records in the data blocks, they are stored as is with NoKeyEncoding
+	block             []byte               // Records of the current data block of a compressed file
+	blockOffsets      []uint64             // Offsets of the data blocks of the current compressed file in its data section
+	dataOffset        uint64               // Size of the data section of the current compressed file
+}
+
+// SetCompression makes the writer compress the records of the files in data blocks of DataBlockRecordCount records with the codec,
+// a block is stored uncompressed when the codec does not make it smaller. NoCompression, the default, stores the records as is.
+// It must be called before the first record is written.
+func (w *Writer) SetCompression(compression Compression) {
+	w.compression = compression
+}
+
+// SetKeyEncoding makes the writer encode the records of the files in data blocks (see KeyEncoding), which is recorded in their
+// header (see Metadata.IsEncoded). NoKeyEncoding, the default, stores the records as is.
+// It must be called before the first record is written.
+func (w *Writer) SetKeyEncoding(keyEncoding KeyEncoding) {
+	w.keyEncoding = keyEncoding
+}
+
+// writesBlocks returns true if the records are written in data blocks located by a block index, rather than at a fixed stride.
+func (w *Writer) writesBlocks() bool {
+	return w.compression != NoCompression || w.keyEncoding != NoKeyEncoding
+}
+
+// SetLearnedIndexError makes the writer store a learned index (see LearnedIndex) in every file, whose predictions are at most
+// epsilon records off. An epsilon of 0 disables the learned index, which is the default.
+// It must be called before the first record is written.
+func (w *Writer) SetLearnedIndexError(epsilon uint64) {
+	w.learnedIndexError = epsilon
+}
+
+// SetRangeTombstones sets the range tombstones to write, they must be sorted by start.
+// It must be called before the first record is written.
+func (w *Writer) SetRangeTombstones(rangeTombstones []shared.RangeTombstone) {
+	w.rangeTombstones = rangeTombstones
+}
+
+// Write appends a record to the current SSTable file, rolling to a new file if the target size is reached.
+// Records must be written in ascending key order, the versions of a key from the most recent to the oldest.
+// The versions of a key are never split across two files.
+func (w *Writer) Write(record []byte) error {
+	if uint64(len(record)) != shared.BlockSize {
+		return errors.New("invalid record size")
+	}
+
+	decoded, err := shared.ByteToRecord(record)
+	if err != nil {
+		return err
+	}
+	key := decoded.Key
+
+	// The current file is only finished once the first key of the next one is known, which is the upper bound of its range tombstones
+	if w.osFile != nil && w.size >= w.targetSize && key != w.metadata.maxKey {
+		if err := w.finishFile(key); err != nil {
+			return err
+		}
+	}
+
+	if w.osFile == nil {
+		if err := w.startFile(key); err != nil {
+			return err
+		}
+	}
+
+	// The learned index predicts the position of the first version of each key
+	if w.learnedIndex != nil && (w.size == 0 || key != w.metadata.maxKey) {
+		w.learnedIndex.add(key, w.size/shared.BlockSize)
+	}
+
+	if w.writesBlocks() {
+		w.block = append(w.block, record...)
+		if uint64(len(w.block)) == DataBlockSize {
+			if err := w.writeBlock(); err != nil {
+				return err
+			}
+		}
+	} else if _, err := w.buffer.Write(record); err != nil {
+		return err
+	}
+	w.metadata.maxKey = key
+	w.metadata.SetMaxSequenceNumber(max(w.metadata.GetMaxSequenceNumber(), decoded.SequenceNumber))
+	w.size += shared.BlockSize
+
+	return nil
+}
+
+// Close finishes the current SSTable file and returns the paths of all the files written.
+// If only range tombstones have been given, a file holding only them is written.
+func (w *Writer) Close() ([]string, error) {
+	if w.osFile == nil && len(w.paths) == 0 && len(w.rangeTombstones) > 0 {
+		if err := w.startFile(w.rangeTombstones[0].Start); err != nil {
+			return nil, err
+		}
+	}
+
+	if w.osFile != nil {
+		if err := w.finishFile(math.MaxUint64); err != nil {
+			return nil, err
+		}
+	}
+
+	return w.paths, nil
+}
+
+// Abort closes and removes the SSTable file currently being written along with the files already finished, so that a failed
+// write leaves none of its files behind. It returns the first error encountered.
+func (w *Writer) Abort() error {
+	var firstErr error
+	if w.osFile != nil {
+		name := w.osFile.Name()
+		_ = w.osFile.Close()
+		w.osFile = nil
+		w.buffer = nil
+		firstErr = os.Remove(name)
+	}
+
+	for _, filePath := range w.paths {
+		if err := os.Remove(filePath); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.paths = w.paths[:0]
+	return firstErr
+}
+
+// startFile creates a temporary file and reserves the space of the metadata at its beginning.
+func (w *Writer) startFile(minKey shared.KeyType) error {
+	osFile, err := os.Create(path.Join(w.directory, shared.RandomString(32)+shared.TemporaryExtension))
+	if err != nil {
+		return err
+	}
+
+	w.osFile = osFile
+	w.buffer = bufio.NewWriter(osFile)
+	w.metadata = NewMetadata(minKey, minKey)
+	w.metadata.SetEncoded(w.keyEncoding != NoKeyEncoding)
+	w.size = 0
+	w.block = w.block[:0]
+	w.blockOffsets = make([]uint64, 0)
+	w.dataOffset = 0
+	w.learnedIndex = nil
+	if w.learnedIndexError > 0 {
+		w.learnedIndex = newLearnedIndexBuilder(w.learnedIndexError)
+	}
+
+	// The metadata is only known once the file is finished, it is patched in finishFile
+	_, err = w.buffer.Write(make([]byte, MetadataSize))
+	return err
+}
+
+// writeBlock encodes, compresses and writes the current data block.
+func (w *Writer) writeBlock() error {
+	data := w.block
+	if w.keyEncoding != NoKeyEncoding {
+		encoded, err := encodeBlock(w.keyEncoding, data)
+		if err != nil {
+			return err
+		}
+		data = encoded
+	}
+
+	data, err := compressBlock(w.compression, data)
+	if err != nil {
+		return err
+	}
+	if _, err := w.buffer.Write(data); err != nil {
+		return err
+	}
+
+	w.blockOffsets = append(w.blockOffsets, w.dataOffset)
+	w.dataOffset += uint64(len(data))
+	w.block = w.block[:0]
+	return nil
+}
+
+// finishFile writes the last data block, the fragments of the range tombstones in [lowerBound, upperBound), the learned index,
+// the block index, the metadata, closes the file and renames it to its final name (see Metadata.GetFileName).
+func (w *Writer) finishFile(upperBound shared.KeyType) error {
+	if len(w.block) > 0 {
+		if err := w.writeBlock(); err != nil {
+			return err
+		}
+	}
+
+	fragments := make([]shared.RangeTombstone, 0)
+	for _, rangeTombstone := range w.rangeTombstones {
+		fragment := shared.RangeTombstone{
+			Start:          max(rangeTombstone.Start, w.lowerBound),
+			End:            min(rangeTombstone.End, upperBound),
+			SequenceNumber: rangeTombstone.SequenceNumber,
+		}
+		if fragment.Start < fragment.End {
+			fragments = append(fragments, fragment)
+		}
+	}
+
+	for _, fragment := range fragments {
+		if _, err := w.buffer.Write(fragment.ToByte()); err != nil {
+			return err
+		}
+		w.metadata.minKey = min(w.metadata.minKey, fragment.Start)
+		w.metadata.maxKey = max(w.metadata.maxKey, fragment.End-1)
+		w.metadata.SetMaxSequenceNumber(max(w.metadata.GetMaxSequenceNumber(), fragment.SequenceNumber))
+	}
+	w.metadata.SetRangeTombstoneCount(uint64(len(fragments)))
+
+	if w.learnedIndex != nil {
+		if learnedIndex := w.learnedIndex.build(); learnedIndex != nil {
+			if _, err := w.buffer.Write(learnedIndex.ToByte()); err != nil {
+				return err
+			}
+			w.metadata.SetLearnedIndexSize(uint64(learnedIndex.GetSegmentCount()))
+		}
+	}
+
+	if len(w.blockOffsets) > 0 {
+		index := blockIndex{recordCount: w.size / shared.BlockSize, offsets: append(w.blockOffsets, w.dataOffset)}
+		if _, err := w.buffer.Write(index.ToByte()); err != nil {
+			return err
+		}
+		w.metadata.SetBlockCount(index.getBlockCount())
+	}
+
+	if err := w.buffer.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := w.osFile.WriteAt(w.metadata.ToByte(), 0); err != nil {
+		return err
+	}
+
+	if err := w.osFile.Close(); err != nil {
+		return err
+	}
+
+	finalPath := path.Join(w.directory, w.metadata.GetFileName())
+	if err := os.Rename(w.osFile.Name(), finalPath); err != nil {
+		return err
+	}
+
+	w.paths = append(w.paths, finalPath)
+	w.osFile = nil
+	w.buffer = nil
+	w.lowerBound = upperBound
+	return nil
+}
+
+// NewWriter creates a writer that writes SSTable files of targetSize bytes (excluding metadata) to directory.
+func NewWriter(directory string, targetSize uint64) *Writer {
+	return &Writer{
+		directory:  directory,
+		targetSize: targetSize,
+		lowerBound: 0,
+		paths:      make([]string, 0),
+	}
+}
@@ -0,0 +1,36 @@
+package ss_table
+
+import (
+	"dmds_lab2/shared"
+	"os"
+	"testing"
+)
+
+func TestWriter_AbortRemovesAllFiles(t *testing.T) {
+	directory := t.TempDir()
+	writer := NewWriter(directory, 10*shared.BlockSize)
+	for key := shared.KeyType(0); key < 35; key++ {
+		if err := writer.Write(shared.KeyValueToByte(key, key)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	// Three files are finished and renamed, the fourth one is still being written
+	entries, err := os.ReadDir(directory)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("expected 4 files before Abort, got %d, %v", len(entries), err)
+	}
+
+	if err := writer.Abort(); err != nil {
+		t.Fatalf("Abort failed: %v", err)
+	}
+	entries, err = os.ReadDir(directory)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected no file after Abort, got %v, %v", entries, err)
+	}
+
+	// Nothing is left to close
+	if paths, err := writer.Close(); err != nil || len(paths) != 0 {
+		t.Fatalf("Close after Abort = %v, %v", paths, err)
+	}
+}
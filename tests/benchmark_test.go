@@ -1,20 +1,31 @@
 package tests
 
 import (
+	"cmp"
+	"dmds_lab2/b_plus_tree"
+	"dmds_lab2/generic"
+	"dmds_lab2/key_value"
+	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
 	"fmt"
 	"testing"
 )
 
-func BenchmarkSequentialInserts1kTo1m(b *testing.B) {
-	b.Run("SequentialInserts", func(b *testing.B) {
-		keys := GenerateRandomKeys(b.N)
-		b.ResetTimer()
+// benchmarkSizes are the numbers of keys of the trees built by the benchmarks, from 1k to 1M.
+var benchmarkSizes = []int{1_000, 10_000, 100_000, 1_000_000}
 
-		_, _, err := SequentialInserts(keys)
-		if err != nil {
-			b.Error(err)
-		}
-	})
+func BenchmarkSequentialInserts1kTo1m(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("SequentialInserts/%d", n), func(b *testing.B) {
+			keys := GenerateRandomKeys(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := SequentialInserts(keys); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
 }
 
 func BenchmarkSequentialLookups(b *testing.B) {
@@ -32,3 +43,181 @@ func BenchmarkSequentialLookups(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkBulkLoad compares building a tree by sorting and bulk loading the keys with inserting them one by one.
+func BenchmarkBulkLoad(b *testing.B) {
+	for _, n := range []int{10_000, 100_000, 1_000_000} {
+		keys := GenerateRandomKeys(n)
+
+		b.Run(fmt.Sprintf("SequentialInserts/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := SequentialInserts(keys); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("BulkLoad/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := BulkLoad(keys, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBulkLoadedLookups looks up every key of a bulk loaded tree, to compare with BenchmarkSequentialLookups.
+func BenchmarkBulkLoadedLookups(b *testing.B) {
+	keys := GenerateRandomKeys(b.N)
+	index, _, err := BulkLoad(keys, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	if err := SequentialLookups(index, keys); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkFanout compares the capacities of the leaves and the interior nodes: building a tree by inserting every key (one
+// operation per tree), and looking up the keys of the tree (one operation per key).
+func BenchmarkFanout(b *testing.B) {
+	type fanout struct {
+		name             string
+		leafCapacity     uint64
+		interiorCapacity uint64
+	}
+	fanouts := []fanout{{"4", 4, 4}, {"16", 16, 16}, {"64", 64, 64}, {"256", 256, 256}, {"Leaf256Interior16", 256, 16}}
+	for _, size := range []struct {
+		name  string
+		bytes uint64
+	}{{"CacheLine", b_plus_tree.CacheLineSize}, {"Page", b_plus_tree.PageSize}} {
+		leafCapacity, interiorCapacity := b_plus_tree.NodeCapacitiesForSize(size.bytes)
+		fanouts = append(fanouts, fanout{size.name, leafCapacity, interiorCapacity})
+	}
+
+	// The keys and the tree to look up are built inside each sub-benchmark, so that running a single one does not build them all
+	for _, fanout := range fanouts {
+		for _, n := range benchmarkSizes {
+			b.Run(fmt.Sprintf("Inserts/%s/%d", fanout.name, n), func(b *testing.B) {
+				keys := GenerateRandomKeys(n)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := SequentialInsertsWithFanout(keys, fanout.leafCapacity, fanout.interiorCapacity); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+
+			b.Run(fmt.Sprintf("Lookups/%s/%d", fanout.name, n), func(b *testing.B) {
+				keys := GenerateRandomKeys(n)
+				index, _, err := SequentialInsertsWithFanout(keys, fanout.leafCapacity, fanout.interiorCapacity)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := index.Get(keys[i%n]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkGenericBPlusTree inserts then looks up random keys in the shared.KeyType specialization of the tree and in the
+// generic tree, with ordered keys and with a comparator function.
+func BenchmarkGenericBPlusTree(b *testing.B) {
+	const capacity = 32
+	keys := GenerateRandomKeys(100_000)
+	trees := []struct {
+		name    string
+		newTree func() key_value.KeyValueStore
+	}{
+		{"Specialized", func() key_value.KeyValueStore { return b_plus_tree.NewBPlusTree(capacity) }},
+		{"Ordered", func() key_value.KeyValueStore {
+			return generic.NewBPlusTree[shared.KeyType, shared.ValueType](capacity)
+		}},
+		{"Comparator", func() key_value.KeyValueStore {
+			return generic.NewBPlusTreeFunc[shared.KeyType, shared.ValueType](capacity, func(a, b shared.KeyType) int {
+				return cmp.Compare(a, b)
+			})
+		}},
+	}
+
+	for _, tree := range trees {
+		b.Run(tree.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				index := tree.newTree()
+				for j, key := range keys {
+					if err := index.Insert(key, shared.ValueType(j)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				for _, key := range keys {
+					if _, err := index.Get(key); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+var searchMethods = []struct {
+	name         string
+	searchMethod ss_table.SearchMethod
+}{
+	{"SkipList", ss_table.SkipListSearch},
+	{"Binary", ss_table.BinarySearch},
+	{"Interpolation", ss_table.InterpolationSearch},
+	{"LearnedIndex", ss_table.LearnedIndexSearch},
+}
+
+// BenchmarkSSTableLoad compares the time and memory needed to load an SSTable of 10k records with each search method.
+func BenchmarkSSTableLoad(b *testing.B) {
+	filePath, err := WriteSSTable(b.TempDir(), GenerateRandomKeys(10_000))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, method := range searchMethods {
+		b.Run(method.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := LoadSSTable(filePath, method.searchMethod); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSSTableLookups compares the lookups in an SSTable of 10k records with each search method.
+func BenchmarkSSTableLookups(b *testing.B) {
+	keys := GenerateRandomKeys(10_000)
+	filePath, err := WriteSSTable(b.TempDir(), keys)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, method := range searchMethods {
+		b.Run(method.name, func(b *testing.B) {
+			ssTable, err := LoadSSTable(filePath, method.searchMethod)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ssTable.Get(keys[i%len(keys)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"dmds_lab2/b_plus_tree"
 	"dmds_lab2/shared"
+	"dmds_lab2/ss_table"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 )
 
 func GenerateRandomKeys(N int) []shared.KeyType {
@@ -47,12 +49,21 @@ func SaveKeysToCSV(keys []shared.KeyType) error {
 	return nil
 }
 
+// bPlusTreeCapacity is the capacity of the nodes of the trees built by SequentialInserts and BulkLoad, see BenchmarkFanout.
+const bPlusTreeCapacity = 64
+
 func SequentialInserts(keys []shared.KeyType) (*b_plus_tree.BPlusTree, []shared.KeyType, error) {
-	alex := b_plus_tree.NewBPlusTree(1)
+	return SequentialInsertsWithFanout(keys, bPlusTreeCapacity, bPlusTreeCapacity)
+}
+
+// SequentialInsertsWithFanout inserts the keys one by one, with their position as value, into a tree with the capacities of
+// the leaves and the interior nodes.
+func SequentialInsertsWithFanout(keys []shared.KeyType, leafCapacity uint64, interiorCapacity uint64) (*b_plus_tree.BPlusTree, []shared.KeyType, error) {
+	alex := b_plus_tree.NewBPlusTreeWithFanout(leafCapacity, interiorCapacity)
 
 	for i := 0; i < len(keys); i++ {
 		key := keys[i]
-		_, _, err := alex.Insert(shared.KeyType(key))
+		err := alex.Insert(shared.KeyType(key), shared.ValueType(i))
 		if err != nil {
 			return alex, keys, err
 		}
@@ -61,12 +72,62 @@ func SequentialInserts(keys []shared.KeyType) (*b_plus_tree.BPlusTree, []shared.
 	return alex, keys, nil
 }
 
+// BulkLoad sorts the keys and bulk loads them into a tree, with the same values as SequentialInserts: the position of the key.
+func BulkLoad(keys []shared.KeyType, fillFactor float64) (*b_plus_tree.BPlusTree, []shared.KeyType, error) {
+	pairs := make([]b_plus_tree.Pair, len(keys))
+	for i, key := range keys {
+		pairs[i] = b_plus_tree.Pair{Key: key, Value: shared.ValueType(i)}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	alex, err := b_plus_tree.BulkLoad(bPlusTreeCapacity, pairs, fillFactor)
+	return alex, keys, err
+}
+
 func SequentialLookups(alex *b_plus_tree.BPlusTree, keys []shared.KeyType) error {
 	for i := 0; i < len(keys); i++ {
-		_, _, _, err := alex.Get(keys[i])
+		value, err := alex.Get(keys[i])
 		if err != nil {
 			return err
 		}
+		if *value != shared.ValueType(i) {
+			return fmt.Errorf("key %d holds %d, expected %d", keys[i], *value, i)
+		}
 	}
 	return nil
 }
+
+// WriteSSTable writes the keys, sorted, to a single SSTable file with a learned index in the directory and returns its path.
+func WriteSSTable(directory string, keys []shared.KeyType) (string, error) {
+	sortedKeys := append([]shared.KeyType(nil), keys...)
+	sort.Slice(sortedKeys, func(i, j int) bool { return sortedKeys[i] < sortedKeys[j] })
+
+	writer := ss_table.NewWriter(directory, uint64(len(keys))*shared.BlockSize)
+	writer.SetLearnedIndexError(ss_table.DefaultLearnedIndexError)
+	for _, key := range sortedKeys {
+		record := shared.Record{Key: key, Value: key, Kind: shared.KindValue, SequenceNumber: 1}
+		if err := writer.Write(record.ToByte()); err != nil {
+			_ = writer.Abort()
+			return "", err
+		}
+	}
+
+	paths, err := writer.Close()
+	if err != nil {
+		return "", err
+	}
+	return paths[0], nil
+}
+
+// LoadSSTable opens and loads the SSTable file with the search method, the file is closed once loaded.
+func LoadSSTable(filePath string, searchMethod ss_table.SearchMethod) (*ss_table.SSTable, error) {
+	ssTable := ss_table.NewSSTable()
+	ssTable.SetPath(filePath)
+	ssTable.SetSearchMethod(searchMethod)
+	if err := ssTable.Open(); err != nil {
+		return nil, err
+	}
+	defer ssTable.Close()
+
+	return ssTable, ssTable.Load()
+}